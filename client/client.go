@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
@@ -39,24 +41,81 @@ var (
 				PaddingLeft(1)
 	textareaStyle = lipgloss.NewStyle().
 			PaddingTop(1)
+	ownSenderStyle = senderStyle.Foreground(lipgloss.Color("#C3E88D"))    // Green for own name
+	pmStyle        = serverMsgStyle.Foreground(lipgloss.Color("#FFCB6B")) // Orange for PMs
+	pendingStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true)
+	separatorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#82AAFF")).Bold(true)
 )
 
+// sendStatus tracks the delivery state of a line we authored.
+type sendStatus int
+
+const (
+	statusConfirmed sendStatus = iota
+	statusPending
+	statusFailed
+)
+
+// chatLine is one entry in the message list. Lines built from server events
+// keep the event so they can be updated in place once the server responds.
+type chatLine struct {
+	event  Event
+	status sendStatus
+	mine   bool // authored by this client
+}
+
 type model struct {
-	viewport     viewport.Model
-	textarea     textarea.Model
-	conn         *websocket.Conn
-	messages     []string
-	err          error
-	connected    bool
-	username     string
-	reconnecting bool
-	done         chan struct{}
-	msgChan      chan receivedMsg // Add a channel for messages
+	viewport       viewport.Model
+	textarea       textarea.Model
+	conn           *websocket.Conn
+	writeMux       *sync.Mutex // commands write from their own goroutines
+	messages       []chatLine
+	nextClientID   int
+	err            error
+	connected      bool
+	username       string
+	named          bool // the server confirmed username on this connection
+	nickAttempts   int  // alternate nicknames tried since connecting
+	reconnecting   bool
+	done           chan struct{}
+	msgChan        chan tea.Msg      // Add a channel for messages
+	lastSeq        map[string]uint64 // last sequence number rendered per room
+	resuming       map[string]bool   // rooms with a /resume request in flight
+	typingTarget   string            // where we last announced typing, "" if idle
+	typingSentAt   time.Time
+	typers         map[string]time.Time  // who is typing to us, until when
+	selectedID     string                // message picked with alt+up/down, "" if none
+	threads        map[string][]chatLine // thread replies keyed by root message ID
+	threadID       string                // root of the thread shown instead of the room
+	unreadMentions int
+	bell           bool                       // ring the terminal bell with the next frames
+	conversations  map[string][]string        // members of our group conversations by ID
+	tabs           []string                   // conversation IDs in the order they were opened
+	activeRoom     string                     // the lobby or the conversation shown
+	unread         map[string]int             // unread lines per tab
+	codeOffset     int                        // columns code snippets are scrolled to the right
+	uploads        map[string]upload          // files announced with /upload, by client ID
+	downloads      map[string]downloadRequest // files requested with /download, by file ID
+	download       *download                  // file being received, nil if none
+	graphics       graphicsMode               // how image previews are drawn
+	previews       map[string]*preview        // image previews by file ID; nil if undecodable
+	previewSpots   []previewSpot              // where sixel and kitty previews sit in the viewport
+	nextImageID    uint32                     // last image ID sent to a kitty terminal
+	search         *searchResults             // open /search overlay, nil if closed
+	identity       *identity                  // keypair for end-to-end encrypted PMs
+	peerKeys       map[string][32]byte        // public keys of the users we exchanged PMs with
+	pendingPMs     map[string][]pendingPM     // PMs waiting for their recipient's key
+	verifying      map[string]bool            // users whose fingerprint /verify asked for
+	topics         map[string]string          // room topics by room
+	pins           map[string][]Event         // pinned messages by room
 }
 
 type connectedMsg struct{ conn *websocket.Conn }
-type disconnectedMsg struct{}
-type receivedMsg struct{ text string }
+
+// disconnectedMsg reports a lost connection. conn is the connection that
+// failed, so that a late report about an old connection is ignored.
+type disconnectedMsg struct{ conn *websocket.Conn }
+type receivedMsg struct{ event Event }
 
 func initialModel() model {
 	ta := textarea.New()
@@ -64,7 +123,8 @@ func initialModel() model {
 	ta.Focus()
 
 	ta.Prompt = "┃ "
-	ta.CharLimit = 280
+	ta.CharLimit = chatCharLimit
+	ta.MaxHeight = maxSnippetLines
 
 	ta.SetWidth(40)
 	ta.SetHeight(3)
@@ -77,14 +137,46 @@ func initialModel() model {
 	vp := viewport.New(40, 20)
 	vp.Style = viewportStyle
 
+	// Without a stored keypair PMs still work, but those sealed for us while
+	// we were offline can't be opened after a restart
+	var notices []chatLine
+	id, err := loadIdentity()
+	if err != nil {
+		log.Printf("Error loading identity: %v", err)
+		notices = append(notices, chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot keep your encryption key (%v); using a temporary one.", err)}})
+		if id == nil {
+			if id, err = newIdentity(); err != nil {
+				log.Fatalf("Error generating a keypair: %v", err)
+			}
+		}
+	}
+
 	return model{
-		textarea:     ta,
-		viewport:     vp,
-		messages:     []string{},
-		username:     fmt.Sprintf("user-%d", rand.Intn(1000)),
-		reconnecting: false,
-		done:         make(chan struct{}),
-		msgChan:      make(chan receivedMsg, 100), // Buffer 100 messages
+		textarea:      ta,
+		viewport:      vp,
+		writeMux:      &sync.Mutex{},
+		messages:      notices,
+		username:      fmt.Sprintf("user-%d", rand.Intn(1000)),
+		reconnecting:  false,
+		done:          make(chan struct{}),
+		msgChan:       make(chan tea.Msg, 100), // Buffer 100 messages
+		lastSeq:       make(map[string]uint64),
+		resuming:      make(map[string]bool),
+		typers:        make(map[string]time.Time),
+		threads:       make(map[string][]chatLine),
+		conversations: make(map[string][]string),
+		activeRoom:    defaultRoom,
+		unread:        make(map[string]int),
+		uploads:       make(map[string]upload),
+		downloads:     make(map[string]downloadRequest),
+		graphics:      detectGraphics(),
+		previews:      make(map[string]*preview),
+		identity:      id,
+		peerKeys:      make(map[string][32]byte),
+		pendingPMs:    make(map[string][]pendingPM),
+		verifying:     make(map[string]bool),
+		topics:        make(map[string]string),
+		pins:          make(map[string][]Event),
 	}
 }
 
@@ -112,6 +204,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.search != nil && m.handleSearchKey(msg) {
+			return m, nil
+		}
+		if m.handleShortcut(msg) {
+			return m, nil
+		}
+		if msg.Type == tea.KeyEsc && m.threadID != "" {
+			m.closeThread()
+			return m, nil
+		}
+		// A draft with a code block may be long; check before a paste is
+		// cut to the chat limit
+		m.textarea.CharLimit = charLimitFor(m.textarea.Value() + string(msg.Runes))
+		if msg.Type == tea.KeyEnter && openSnippet(strings.TrimSpace(m.textarea.Value())) {
+			// Enter starts a new line until the closing fence is typed
+			break
+		}
 		switch msg.Type {
 		case tea.KeyCtrlC, tea.KeyEsc:
 			close(m.done)
@@ -125,51 +234,89 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, tea.Quit
 		case tea.KeyEnter:
+			// Exporting the scrollback is local and works offline too
+			if path, ok := strings.CutPrefix(strings.TrimSpace(m.textarea.Value()), "/export "); ok {
+				m.textarea.Reset()
+				m.exportScrollback(strings.TrimSpace(path))
+				return m, nil
+			}
 			if m.conn != nil && m.connected {
 				message := strings.TrimSpace(m.textarea.Value())
 				if message == "" {
 					return m, nil
 				}
 
+				m.nextClientID++
+				clientID := fmt.Sprintf("c%d", m.nextClientID)
+
+				// File transfers are handled here rather than sent as typed
+				if args, ok := strings.CutPrefix(message, "/send "); ok {
+					m.textarea.Reset()
+					return m, m.sendFile(clientID, strings.TrimSpace(args))
+				}
+				if args, ok := strings.CutPrefix(message, "/download "); ok {
+					m.textarea.Reset()
+					return m, m.requestDownload(strings.TrimSpace(args))
+				}
+
+				// Private messages are sealed here; the server never sees them
+				if args, ok := strings.CutPrefix(message, "/pm "); ok {
+					m.textarea.Reset()
+					if err := m.sendPM(clientID, args); err != nil {
+						log.Printf("Send error: %v", err)
+						conn := m.conn
+						return m, func() tea.Msg { return disconnectedMsg{conn: conn} }
+					}
+					return m, nil
+				}
+				if nick, ok := strings.CutPrefix(message, "/verify "); ok {
+					m.textarea.Reset()
+					if err := m.verify(strings.TrimSpace(nick)); err != nil {
+						log.Printf("Send error: %v", err)
+						conn := m.conn
+						return m, func() tea.Msg { return disconnectedMsg{conn: conn} }
+					}
+					return m, nil
+				}
+
+				// /me actions are chat lines too
+				action := strings.HasPrefix(message, "/me ")
+				chat := action || !strings.HasPrefix(message, "/")
+
+				// Display own message in the UI right away; the server's ack
+				// replaces this optimistic echo with the confirmed message.
+				if chat && m.threadID == "" {
+					echo := Event{Type: eventMessage, ClientID: clientID, Room: m.activeRoom, From: m.username, Text: strings.TrimPrefix(message, "/me "), Action: action, Time: time.Now()}
+					if lang, code, ok := parseSnippet(message); ok {
+						echo.Code, echo.Lang, echo.Text = true, lang, code
+					}
+					m.appendLine(chatLine{event: echo, status: statusPending, mine: true})
+				}
+
+				// While a thread is open, chat lines are posted as replies to
+				// it, and in a conversation tab they go to the conversation
+				if chat {
+					if m.threadID != "" {
+						message = fmt.Sprintf("/reply %s %s", m.threadID, message)
+					} else if isConversation(m.activeRoom) {
+						message = fmt.Sprintf("/dm %s %s", m.activeRoom, message)
+					}
+				}
+
 				// Send any non-empty message to the server
-				err := m.conn.WriteMessage(websocket.TextMessage, []byte(message))
+				err := m.send(clientID, m.roomCommand(message))
 				if err != nil {
 					// Handle potential write errors (e.g., connection closed)
 					m.err = fmt.Errorf("failed to send message: %v", err)
 					log.Printf("Send error: %v", err)
+					m.failPending("not sent")
 					// Trigger disconnection logic if write fails
-					tea.Println(disconnectedMsg{})
-					return m, nil
-				}
-
-				// Display own message in the UI (add this code)
-				if !strings.HasPrefix(message, "/") {
-					timestamp := time.Now().Format("15:04:05")
-					// Style own message differently
-					styledMsg := lipgloss.JoinHorizontal(
-						lipgloss.Top,
-						senderStyle.Render(fmt.Sprintf("[%s] ", timestamp)),
-						senderStyle.Foreground(lipgloss.Color("#C3E88D")).Render(m.username+":"),
-						messageStyle.Render(" "+message),
-					)
-					m.messages = append(m.messages, styledMsg)
-					m.viewport.SetContent(strings.Join(m.messages, "\n"))
-					m.viewport.GotoBottom()
+					conn := m.conn
+					return m, func() tea.Msg { return disconnectedMsg{conn: conn} }
 				}
 
 				m.textarea.Reset()
-				// Check if the message sent was a /nick command and update local username if successful
-				if strings.HasPrefix(message, "/nick ") {
-					parts := strings.SplitN(message, " ", 2)
-					if len(parts) == 2 {
-						newUsername := strings.TrimSpace(parts[1])
-						// Basic client-side validation mirroring server (optional but good practice)
-						if newUsername != "" && len(newUsername) < 20 {
-							// Assume success for now, server confirms via broadcast
-							m.username = newUsername
-						}
-					}
-				}
+				m.textarea.CharLimit = chatCharLimit
 			} else {
 				m.err = fmt.Errorf("not connected to server")
 			}
@@ -183,12 +330,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.connected = true
 		m.err = nil
 		m.reconnecting = false
-
-		// Add a connection message to the UI
-		connectMsg := fmt.Sprintf("[Server] Connected as %s", m.username)
-		m.messages = append(m.messages, serverMsgStyle.Render(connectMsg))
-		m.viewport.SetContent(strings.Join(m.messages, "\n"))
-		m.viewport.GotoBottom()
+		m.named = false
+		m.nickAttempts = 0
+
+		// Add a connection message to the UI; the username follows once the
+		// server accepts it
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: "Connected to the server."}})
+
+		// Ask for everything we missed in the rooms we had already seen
+		var resumes []string
+		for room, seq := range m.lastSeq {
+			m.resuming[room] = true
+			resumes = append(resumes, fmt.Sprintf("/resume %s %d", room, seq))
+		}
 
 		log.Printf("Client connected successfully. Starting listener.")
 		// Return a command to send the /nick message AFTER connection is established
@@ -197,13 +351,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return fmt.Errorf("cannot send nick: connection is nil")
 			}
 			nickMsg := fmt.Sprintf("/nick %s", m.username)
-			err := m.conn.WriteMessage(websocket.TextMessage, []byte(nickMsg))
+			err := m.send(connectNickID, nickMsg)
 			if err != nil {
 				log.Printf("Failed to send initial nick command: %v", err)
 				// Handle error, maybe queue for retry or signal disconnection
-				return disconnectedMsg{}
+				return disconnectedMsg{conn: m.conn}
 			}
 			log.Printf("Sent initial nick command: %s", nickMsg)
+			if err := m.send("", "/pubkey "+m.identity.publicKey()); err != nil {
+				log.Printf("Failed to publish public key: %v", err)
+				return disconnectedMsg{conn: m.conn}
+			}
+			for _, resume := range resumes {
+				if err := m.send("", resume); err != nil {
+					log.Printf("Failed to send %q: %v", resume, err)
+					return disconnectedMsg{conn: m.conn}
+				}
+			}
 			return nil // Indicate success, no state change needed directly
 		}
 		// Start listener AND send nick command
@@ -214,23 +378,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, tea.Batch(nickCmd, m.waitForMessages())
 	case disconnectedMsg:
+		if msg.conn != m.conn {
+			// A report about a connection we already replaced
+			return m, m.waitForMessages()
+		}
 		m.connected = false
 		if m.conn != nil {
 			m.conn.Close()
 			m.conn = nil
 		}
+		m.failPending("connection lost")
+		clear(m.uploads)
+		clear(m.downloads)
+		m.abortDownload("connection lost")
 		if !m.reconnecting {
 			m.reconnecting = true
 			m.err = fmt.Errorf("connection lost")
 			// Add a disconnection message to the UI
-			m.messages = append(m.messages, errorStyle.Render("Disconnected from server. Attempting to reconnect..."))
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
-			m.viewport.GotoBottom()
+			m.appendLine(chatLine{event: Event{Type: eventError, Text: "Disconnected from server. Attempting to reconnect..."}})
 
 			// Continue waiting for more messages
-			cmds = append(cmds, m.waitForMessages())
-
-			return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+			return m, tea.Batch(m.waitForMessages(), tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
 				log.Println("Attempting to reconnect...")
 				c, _, err := websocket.DefaultDialer.Dial(serverAddr, nil)
 				if err != nil {
@@ -238,8 +406,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return fmt.Errorf("reconnection failed: %w", err)
 				}
 				return connectedMsg{conn: c}
-			})
+			}))
 		}
+		return m, m.waitForMessages()
 	case error:
 		currentErr := msg.(error)
 		if m.reconnecting {
@@ -248,9 +417,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			// Add error message to UI
 			errMsg := fmt.Sprintf("Reconnection failed: %v. Retrying...", currentErr)
-			m.messages = append(m.messages, errorStyle.Render(errMsg))
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
-			m.viewport.GotoBottom()
+			m.appendLine(chatLine{event: Event{Type: eventError, Text: errMsg}})
 
 			// Continue waiting for messages
 			cmds = append(cmds, m.waitForMessages())
@@ -266,9 +433,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			// Add error message to UI
 			errMsg := fmt.Sprintf("Connection failed: %v. Attempting to reconnect...", currentErr)
-			m.messages = append(m.messages, errorStyle.Render(errMsg))
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
-			m.viewport.GotoBottom()
+			m.appendLine(chatLine{event: Event{Type: eventError, Text: errMsg}})
 
 			// Continue waiting for messages
 			cmds = append(cmds, m.waitForMessages())
@@ -278,66 +443,381 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			})
 		}
 	case receivedMsg:
-		log.Printf("Received raw message: %s", msg.text) // ADD LOGGING
-		// Parse and style the received message
-		styledMsg := msg.text // Default to raw text
-		if strings.HasPrefix(msg.text, "[Server]") {
-			styledMsg = serverMsgStyle.Render(msg.text)
-		} else if strings.HasPrefix(msg.text, "[PM from") || strings.HasPrefix(msg.text, "[PM to") {
-			// Simple styling for PMs, could be more sophisticated
-			styledMsg = serverMsgStyle.Foreground(lipgloss.Color("#FFCB6B")).Render(msg.text) // Use orange for PMs
-		} else if strings.HasPrefix(msg.text, "[") && strings.Contains(msg.text, "]") && strings.Contains(msg.text, ":") {
-			// Attempt to parse timestamped message: [15:04:05] user: message
-			timestampEnd := strings.Index(msg.text, "]")
-			colonPos := strings.Index(msg.text, ":")
-			if timestampEnd != -1 && colonPos != -1 && colonPos > timestampEnd {
-				timestamp := msg.text[:timestampEnd+1]
-				senderAndMsg := strings.TrimSpace(msg.text[timestampEnd+1:])
-				parts := strings.SplitN(senderAndMsg, ":", 2)
-				if len(parts) == 2 {
-					sender := strings.TrimSpace(parts[0])
-					message := strings.TrimSpace(parts[1])
-
-					// Render parts with styles
-					timestampStyled := senderStyle.Render(timestamp) // Use dim style for timestamp
-					senderStyled := senderStyle.Render(sender + ":")
-					messageStyled := messageStyle.Render(" " + message)
-
-					// Highlight own messages
-					if sender == m.username {
-						senderStyled = senderStyle.Foreground(lipgloss.Color("#C3E88D")).Render(sender + ":")
-					}
-					styledMsg = lipgloss.JoinHorizontal(lipgloss.Top, timestampStyled, senderStyled, messageStyled)
-				}
+		m.openPM(&msg.event)
+		sanitizeEvent(&msg.event)
+		switch msg.event.Type {
+		case eventAck:
+			m.applyAck(msg.event)
+			cmds = append(cmds, m.trackSeq(msg.event))
+		case eventReplay:
+			m.applyReplay(msg.event)
+		case eventMessage:
+			delete(m.typers, msg.event.From)
+			if m.mentionsMe(msg.event) {
+				cmds = append(cmds, m.notifyMention())
 			}
-		} // else: Keep raw styledMsg for unparsed lines
-
-		log.Printf("Styled message: %s", styledMsg) // ADD LOGGING
-
-		// Add the styled message to the list
-		m.messages = append(m.messages, styledMsg)
-
-		// Update the viewport content and scroll to bottom
-		m.viewport.SetContent(strings.Join(m.messages, "\n"))
-		m.viewport.GotoBottom()
+			if msg.event.Thread != "" {
+				m.addReply(chatLine{event: msg.event})
+			} else {
+				m.appendLine(chatLine{event: msg.event})
+				m.countUnread(msg.event)
+			}
+			cmds = append(cmds, m.trackSeq(msg.event))
+		case eventPM:
+			delete(m.typers, msg.event.From)
+			m.appendLine(chatLine{event: msg.event})
+		case eventEdit, eventDelete:
+			m.applyEdit(msg.event)
+		case eventReaction:
+			m.applyReactions(msg.event)
+		case eventThread:
+			m.applyThread(msg.event)
+		case eventReplyCount:
+			m.applyReplyCount(msg.event)
+		case eventMentions:
+			m.unreadMentions = 0
+			m.appendLine(chatLine{event: msg.event})
+		case eventSearch:
+			m.applySearch(msg.event)
+		case eventConversation:
+			m.applyConversation(msg.event)
+		case eventTyping, eventStopTyping:
+			cmds = append(cmds, m.applyTyping(msg.event))
+		case eventUpload:
+			cmds = append(cmds, m.streamFile(msg.event.ClientID))
+		case eventDownload:
+			m.startDownload(msg.event)
+		case eventKey:
+			m.applyKey(msg.event)
+		case eventRoom:
+			m.applyRoom(msg.event)
+		case eventNick:
+			if err := m.applyNick(msg.event); err != nil {
+				log.Printf("Send error: %v", err)
+				conn := m.conn
+				cmds = append(cmds, func() tea.Msg { return disconnectedMsg{conn: conn} })
+			}
+		default:
+			m.appendLine(chatLine{event: msg.event})
+		}
+		switch msg.event.Type {
+		case eventMessage, eventPM, eventAck, eventReplay:
+			m.fetchPreviews(msg.event)
+		}
 
-		log.Printf("Viewport content set. Total messages: %d", len(m.messages)) // ADD LOGGING
+		log.Printf("Viewport content set. Total messages: %d", len(m.messages))
 
 		// Continue waiting for more messages
 		cmds = append(cmds, m.waitForMessages())
+	case fileChunkMsg:
+		m.receiveChunk(msg.data)
+		cmds = append(cmds, m.waitForMessages())
+	case typingExpiredMsg:
+		m.expireTypers()
+	case bellDoneMsg:
+		m.bell = false
 	}
 
 	m.textarea, tiCmd = m.textarea.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
+	if _, ok := msg.(tea.KeyMsg); ok {
+		m.updateTyping()
+	}
 
 	cmds = append(cmds, tiCmd, vpCmd)
 	return m, tea.Batch(cmds...)
 }
 
+// send wraps text in a Command tagged with clientID and writes it to the server.
+func (m *model) send(clientID, text string) error {
+	data, err := json.Marshal(Command{ClientID: clientID, Text: text})
+	if err != nil {
+		return err
+	}
+	m.writeMux.Lock()
+	defer m.writeMux.Unlock()
+	return m.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// trackSeq records ev as the latest message seen in its room and, if the
+// sequence number skipped ahead, returns a command asking the server to
+// replay what we missed.
+func (m *model) trackSeq(ev Event) tea.Cmd {
+	if ev.Room == "" || ev.Seq == 0 {
+		return nil
+	}
+	last, seen := m.lastSeq[ev.Room]
+	m.lastSeq[ev.Room] = ev.Seq
+	if !seen || ev.Seq <= last+1 || m.resuming[ev.Room] || m.conn == nil {
+		return nil
+	}
+
+	log.Printf("Gap in %s: last #%d, got #%d. Requesting replay.", ev.Room, last, ev.Seq)
+	m.resuming[ev.Room] = true
+	conn := m.conn
+	resume := fmt.Sprintf("/resume %s %d", ev.Room, last)
+	return func() tea.Msg {
+		if err := m.send("", resume); err != nil {
+			log.Printf("Failed to send %q: %v", resume, err)
+			return disconnectedMsg{conn: conn}
+		}
+		return nil
+	}
+}
+
+// applyReplay merges the messages a room replayed after a /resume into the
+// list, behind a "missed messages" separator. Messages already shown are
+// skipped, and the batch goes in front of any newer line that arrived live
+// while the replay was in flight.
+func (m *model) applyReplay(replay Event) {
+	delete(m.resuming, replay.Room)
+
+	var missed []chatLine
+	for _, ev := range replay.History {
+		if ev.Seq > m.lastSeq[replay.Room] {
+			m.lastSeq[replay.Room] = ev.Seq
+		}
+		if m.findLine(ev.ID) != nil {
+			continue
+		}
+		line := chatLine{event: ev, mine: ev.From == m.username}
+		if m.mentionsMe(ev) {
+			m.unreadMentions++
+		}
+		if ev.Thread != "" {
+			m.addReply(line)
+			continue
+		}
+		missed = append(missed, line)
+	}
+	if len(missed) == 0 && replay.Text == "" {
+		return
+	}
+
+	label := fmt.Sprintf("%d missed messages", len(missed))
+	if len(missed) == 1 {
+		label = "1 missed message"
+	}
+	if replay.Text != "" {
+		label += " · " + replay.Text
+	}
+	separator := chatLine{event: Event{Type: eventSeparator, Room: replay.Room, Text: label}}
+	batch := append([]chatLine{separator}, missed...)
+
+	at := len(m.messages)
+	if len(missed) > 0 {
+		first := missed[0].event.Seq
+		for i, line := range m.messages {
+			if line.event.Room == replay.Room && line.event.Seq > first {
+				at = i
+				break
+			}
+		}
+	}
+	m.messages = append(m.messages[:at], append(batch, m.messages[at:]...)...)
+	m.refreshViewport()
+}
+
+// appendLine adds a line to the message list and scrolls to it.
+func (m *model) appendLine(line chatLine) {
+	m.messages = append(m.messages, line)
+	m.refreshViewport()
+}
+
+// refreshViewport re-renders every visible line into the viewport.
+func (m *model) refreshViewport() {
+	if m.search != nil {
+		m.showSearch()
+		return
+	}
+	lines := m.visibleLines()
+	rendered := make([]string, len(lines))
+	selected := -1
+	m.previewSpots = m.previewSpots[:0]
+	top := 0
+	for i, line := range lines {
+		rendered[i] = m.renderLine(line)
+		indent := 0
+		if m.selectedID != "" && line.event.ID == m.selectedID {
+			rendered[i] = decorateSelected(rendered[i], line)
+			selected = i
+			indent = lipgloss.Width(selectedMarker)
+		}
+		if p := m.previewOf(line.event); p != nil && (m.graphics == graphicsSixel || m.graphics == graphicsKitty) {
+			// The preview starts on the line after the file card
+			m.previewSpots = append(m.previewSpots, previewSpot{line: top + 1, indent: indent, p: p})
+		}
+		top += lipgloss.Height(rendered[i])
+	}
+	m.viewport.SetContent(strings.Join(rendered, "\n"))
+	if selected >= 0 {
+		m.scrollToLine(rendered, selected)
+	} else {
+		m.viewport.GotoBottom()
+	}
+}
+
+// applyEdit updates an edited or deleted message in place.
+func (m *model) applyEdit(ev Event) {
+	line := m.findLine(ev.ID)
+	if line == nil {
+		return
+	}
+	if ev.Type == eventDelete {
+		line.event.Deleted = true
+		line.event.Text = ""
+		if m.selectedID == ev.ID {
+			m.selectedID = ""
+		}
+	} else {
+		line.event.Text = ev.Text
+		line.event.Spans = ev.Spans
+		line.event.Code, line.event.Lang = ev.Code, ev.Lang
+		line.event.Edited = true
+	}
+	m.refreshViewport()
+}
+
+// applyAck resolves the pending line the ack refers to. Acks that match no
+// pending line (e.g. for /pm, which is not echoed locally) are appended.
+func (m *model) applyAck(ack Event) {
+	delete(m.uploads, ack.ClientID)
+	confirmed := ack
+	confirmed.Type = eventMessage
+	if ack.To != "" {
+		confirmed.Type = eventPM
+	}
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		line := &m.messages[i]
+		if ack.ClientID == "" || line.status != statusPending || line.event.ClientID != ack.ClientID {
+			continue
+		}
+		if ack.Error != "" {
+			line.status = statusFailed
+			line.event.Error = ack.Error
+		} else {
+			line.status = statusConfirmed
+			line.event = confirmed
+		}
+		m.refreshViewport()
+		return
+	}
+
+	if ack.Error != "" {
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: ack.Error, Time: ack.Time}})
+		return
+	}
+	if confirmed.Thread != "" {
+		m.addReply(chatLine{event: confirmed, mine: true})
+		return
+	}
+	m.appendLine(chatLine{event: confirmed, mine: true})
+}
+
+// failPending marks every line still waiting for an ack as failed.
+func (m *model) failPending(reason string) {
+	changed := false
+	for i := range m.messages {
+		if m.messages[i].status == statusPending {
+			m.messages[i].status = statusFailed
+			m.messages[i].event.Error = reason
+			changed = true
+		}
+	}
+	if changed {
+		m.refreshViewport()
+	}
+}
+
+// renderLine styles a single line for the viewport.
+func (m model) renderLine(line chatLine) string {
+	ev := line.event
+	timestamp := fmt.Sprintf("[%s]", ev.Time.Format("15:04:05"))
+
+	switch ev.Type {
+	case eventSystem:
+		return serverMsgStyle.Render("[Server] " + ev.Text)
+	case eventError:
+		return errorStyle.Render(ev.Text)
+	case eventSeparator:
+		return separatorStyle.Render("── " + ev.Text + " ──")
+	case eventMentions:
+		return renderMentions(ev.History)
+	case eventConversation:
+		return serverMsgStyle.Render("[Server] " + ev.Text)
+	case eventPM:
+		body := renderSpans(ev.Text, ev.Spans, pmStyle)
+		if ev.File != nil {
+			body = renderFileCard(ev.File)
+		}
+		header := fmt.Sprintf("%s [PM from %s]: ", timestamp, ev.From)
+		if line.mine {
+			header = fmt.Sprintf("%s [PM to %s]: ", timestamp, ev.To)
+		} else if ev.Auto {
+			header = fmt.Sprintf("%s [PM from %s] (auto-reply): ", timestamp, ev.From)
+		}
+		row := pmStyle.Render(header) + body
+		if p := m.previewOf(ev); p != nil {
+			row = lipgloss.JoinVertical(lipgloss.Left, row, p.rendered)
+		}
+		return row
+	}
+
+	// Highlight own messages
+	senderStyled := senderStyle.Render(" " + ev.From + ":")
+	if line.mine || ev.From == m.username {
+		senderStyled = ownSenderStyle.Render(" " + ev.From + ":")
+	}
+	if ev.Deleted {
+		return lipgloss.JoinHorizontal(lipgloss.Top, senderStyle.Render(timestamp), senderStyled, pendingStyle.Render(" message deleted"))
+	}
+	base := messageStyle
+	if m.mentionsMe(ev) {
+		base = mentionStyle
+	}
+	parts := []string{senderStyle.Render(timestamp), senderStyled, " " + renderSpans(ev.Text, ev.Spans, base)}
+	var code []string
+	if ev.Code {
+		code = snippetLines(ev.Text)
+		parts[2] = " " + snippetHeader(ev, len(code), widestLine(code) > m.codeWidth(len(code)))
+	} else if ev.File != nil {
+		parts[2] = " " + renderFileCard(ev.File)
+	} else if ev.Action {
+		// "* alice waves"
+		parts = []string{senderStyle.Render(timestamp), actionStyle.Render(" * " + ev.From + " "), renderSpans(ev.Text, ev.Spans, base.Inherit(actionStyle))}
+	}
+	if ev.Edited {
+		parts = append(parts, pendingStyle.Render(" (edited)"))
+	}
+
+	switch line.status {
+	case statusPending:
+		parts = append(parts, pendingStyle.Render(" (sending…)"))
+	case statusFailed:
+		parts = append(parts, errorStyle.Render("(failed: "+ev.Error+")"))
+	}
+	row := lipgloss.JoinHorizontal(lipgloss.Top, parts...)
+	if ev.Code {
+		row = lipgloss.JoinVertical(lipgloss.Left, row, renderSnippet(code, ev.Lang, m.codeWidth(len(code)), m.codeOffset))
+	}
+	if p := m.previewOf(ev); p != nil {
+		row = lipgloss.JoinVertical(lipgloss.Left, row, p.rendered)
+	}
+
+	if len(ev.Reactions) > 0 {
+		row = lipgloss.JoinVertical(lipgloss.Left, row, m.renderReactions(ev.Reactions))
+	}
+	if ev.Replies > 0 && m.threadID == "" {
+		row = lipgloss.JoinVertical(lipgloss.Left, row, renderReplyCount(ev.Replies))
+	}
+	return row
+}
+
 func (m *model) listenForMessages() {
 	if m.conn == nil {
 		log.Println("listenForMessages called with nil connection")
-		m.msgChan <- receivedMsg{text: "[Error] Not connected to server"}
+		m.msgChan <- receivedMsg{event: Event{Type: eventError, Text: "[Error] Not connected to server"}}
 		return
 	}
 
@@ -360,7 +840,7 @@ func (m *model) listenForMessages() {
 		default:
 			if localConn == nil {
 				log.Println("Listener goroutine found nil connection during loop.")
-				m.msgChan <- receivedMsg{text: "[Error] Connection lost"}
+				m.msgChan <- receivedMsg{event: Event{Type: eventError, Text: "[Error] Connection lost"}}
 				return
 			}
 
@@ -374,12 +854,22 @@ func (m *model) listenForMessages() {
 					continue
 				}
 
-				m.msgChan <- receivedMsg{text: "[Error] Connection error: " + err.Error()}
+				m.msgChan <- receivedMsg{event: Event{Type: eventError, Text: "[Error] Connection error: " + err.Error()}}
+				m.msgChan <- disconnectedMsg{conn: localConn}
 				return
 			}
-			log.Printf("Received message from server - Type: %d, Content: %s", messageType, string(message))
+			if messageType == websocket.BinaryMessage {
+				m.msgChan <- fileChunkMsg{data: message}
+				continue
+			}
+			log.Printf("Received message from server - Type: %d, Length: %d", messageType, len(message))
+			var ev Event
+			if err := json.Unmarshal(message, &ev); err != nil {
+				log.Printf("Could not decode event, showing raw text: %v", err)
+				ev = Event{Type: eventSystem, Text: string(message), Time: time.Now()}
+			}
 			// Send message to UI for processing through channel
-			m.msgChan <- receivedMsg{text: string(message)}
+			m.msgChan <- receivedMsg{event: ev}
 		}
 	}
 }
@@ -387,9 +877,12 @@ func (m *model) listenForMessages() {
 func (m model) View() string {
 	var status string
 	var statusStyle lipgloss.Style
-	if m.connected {
+	if m.connected && m.named {
 		status = fmt.Sprintf("Connected as %s", m.username)
 		statusStyle = statusConnectedStyle
+	} else if m.connected {
+		status = "Connected, no nickname yet"
+		statusStyle = statusConnectedStyle
 	} else if m.reconnecting {
 		status = "Reconnecting..."
 		statusStyle = statusReconnectingStyle
@@ -404,7 +897,7 @@ func (m model) View() string {
 	}
 
 	// Layout using lipgloss.JoinVertical
-	statusLine := statusStyle.Render(status)
+	statusLine := m.bellString() + statusStyle.Render(status) + m.mentionCounter()
 	if errorMsg != "" {
 		statusLine = lipgloss.JoinVertical(lipgloss.Left,
 			statusLine,
@@ -412,9 +905,27 @@ func (m model) View() string {
 		)
 	}
 
+	if tabs := m.tabBar(); tabs != "" {
+		statusLine = lipgloss.JoinVertical(lipgloss.Left, statusLine, tabs)
+	}
+	if topic := m.topicLine(); topic != "" && m.search == nil && m.threadID == "" {
+		statusLine = lipgloss.JoinVertical(lipgloss.Left, statusLine, topic)
+	}
+	if m.search != nil {
+		statusLine = lipgloss.JoinVertical(lipgloss.Left, statusLine, m.searchHeader())
+	} else if m.threadID != "" {
+		statusLine = lipgloss.JoinVertical(lipgloss.Left, statusLine, m.threadHeader())
+	}
+
+	messages := m.viewport.View() // Viewport now uses viewportStyle
+	if m.graphics == graphicsSixel || m.graphics == graphicsKitty {
+		messages = m.drawPreviews(messages)
+	}
+
 	return lipgloss.JoinVertical(lipgloss.Left,
 		statusLine,
-		m.viewport.View(),                       // Viewport now uses viewportStyle
+		messages,
+		m.typingLine(),
 		textareaStyle.Render(m.textarea.View()), // Apply style to textarea container
 	)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestApplyAck(t *testing.T) {
+	pending := chatLine{event: Event{Type: eventMessage, ClientID: "c1", Text: "hi"}, status: statusPending, mine: true}
+	tests := []struct {
+		name       string
+		lines      []chatLine
+		ack        Event
+		wantLines  int
+		wantStatus sendStatus
+		wantType   string
+		wantID     string
+		wantError  string
+	}{
+		{
+			name:       "confirms pending line",
+			lines:      []chatLine{pending},
+			ack:        Event{Type: eventAck, ID: "m1", ClientID: "c1", Text: "hi"},
+			wantLines:  1,
+			wantStatus: statusConfirmed,
+			wantType:   eventMessage,
+			wantID:     "m1",
+		},
+		{
+			name:       "fails pending line",
+			lines:      []chatLine{pending},
+			ack:        Event{Type: eventAck, ClientID: "c1", Error: "too long"},
+			wantLines:  1,
+			wantStatus: statusFailed,
+			wantType:   eventMessage,
+			wantError:  "too long",
+		},
+		{
+			name:       "appends unmatched private message",
+			lines:      []chatLine{pending},
+			ack:        Event{Type: eventAck, ID: "m2", ClientID: "c2", To: "bob", Text: "psst"},
+			wantLines:  2,
+			wantStatus: statusConfirmed,
+			wantType:   eventPM,
+			wantID:     "m2",
+		},
+		{
+			name:       "appends unmatched error as notice",
+			ack:        Event{Type: eventAck, ClientID: "c3", Error: "User 'bob' not found."},
+			wantLines:  1,
+			wantStatus: statusConfirmed,
+			wantType:   eventSystem,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.messages = append(m.messages, tt.lines...)
+			m.applyAck(tt.ack)
+			if len(m.messages) != tt.wantLines {
+				t.Fatalf("got %d lines, want %d", len(m.messages), tt.wantLines)
+			}
+			last := m.messages[len(m.messages)-1]
+			if last.status != tt.wantStatus || last.event.Type != tt.wantType || last.event.ID != tt.wantID || last.event.Error != tt.wantError {
+				t.Errorf("last line = %+v (status %d), want type %q, ID %q, error %q, status %d",
+					last.event, last.status, tt.wantType, tt.wantID, tt.wantError, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTrackSeq(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastSeq    map[string]uint64
+		resuming   bool
+		ev         Event
+		wantResume bool
+		wantLast   uint64
+	}{
+		{"first message", nil, false, Event{Room: "lobby", Seq: 7}, false, 7},
+		{"next in sequence", map[string]uint64{"lobby": 7}, false, Event{Room: "lobby", Seq: 8}, false, 8},
+		{"gap", map[string]uint64{"lobby": 7}, false, Event{Room: "lobby", Seq: 10}, true, 10},
+		{"gap while resuming", map[string]uint64{"lobby": 7}, true, Event{Room: "lobby", Seq: 10}, false, 10},
+		{"counter restarted", map[string]uint64{"lobby": 7}, false, Event{Room: "lobby", Seq: 1}, false, 1},
+		{"no room", map[string]uint64{"lobby": 7}, false, Event{Seq: 10}, false, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.conn = &websocket.Conn{}
+			for room, seq := range tt.lastSeq {
+				m.lastSeq[room] = seq
+			}
+			m.resuming["lobby"] = tt.resuming
+			cmd := m.trackSeq(tt.ev)
+			if (cmd != nil) != tt.wantResume {
+				t.Errorf("trackSeq requested a replay: %v, want %v", cmd != nil, tt.wantResume)
+			}
+			if got := m.lastSeq["lobby"]; got != tt.wantLast {
+				t.Errorf("lastSeq = %d, want %d", got, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestApplyReplay(t *testing.T) {
+	msg := func(id string, seq uint64) chatLine {
+		return chatLine{event: Event{Type: eventMessage, ID: id, Room: "lobby", Seq: seq}}
+	}
+	tests := []struct {
+		name    string
+		lines   []chatLine
+		history []chatLine
+		text    string
+		want    []string // IDs, with "--" for the separator
+	}{
+		{
+			name:    "appends missed messages",
+			lines:   []chatLine{msg("a", 1)},
+			history: []chatLine{msg("b", 2), msg("c", 3)},
+			want:    []string{"a", "--", "b", "c"},
+		},
+		{
+			name:    "goes before newer live messages",
+			lines:   []chatLine{msg("a", 1), msg("d", 4)},
+			history: []chatLine{msg("b", 2), msg("c", 3), msg("d", 4)},
+			want:    []string{"a", "--", "b", "c", "d"},
+		},
+		{
+			name:    "nothing missed",
+			lines:   []chatLine{msg("a", 1)},
+			history: []chatLine{msg("a", 1)},
+			want:    []string{"a"},
+		},
+		{
+			name:  "notice without messages",
+			lines: []chatLine{msg("a", 1)},
+			text:  "some messages are no longer available",
+			want:  []string{"a", "--"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.messages = append(m.messages, tt.lines...)
+			m.resuming["lobby"] = true
+			replay := Event{Type: eventReplay, Room: "lobby", Text: tt.text}
+			for _, line := range tt.history {
+				replay.History = append(replay.History, line.event)
+			}
+			m.applyReplay(replay)
+
+			var got []string
+			for _, line := range m.messages {
+				if line.event.Type == eventSeparator {
+					got = append(got, "--")
+				} else {
+					got = append(got, line.event.ID)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+			if m.resuming["lobby"] {
+				t.Error("room still marked as resuming")
+			}
+		})
+	}
+}
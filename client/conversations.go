@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// conversationPrefix starts the ID of every group conversation. This mirrors
+// server/conversations.go.
+const conversationPrefix = "dm-"
+
+var (
+	tabStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Padding(0, 1)
+	activeTabStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA")).Background(lipgloss.Color("#5A56E0")).Padding(0, 1)
+	unreadTabStyle = tabStyle.Foreground(lipgloss.Color("#FFCB6B")).Bold(true)
+)
+
+// isConversation reports whether room is a group conversation rather than a
+// public room.
+func isConversation(room string) bool {
+	return strings.HasPrefix(room, conversationPrefix)
+}
+
+// roomOf returns the tab a line belongs to: its conversation, or the lobby
+// for room messages and notices.
+func roomOf(line chatLine) string {
+	if isConversation(line.event.Room) {
+		return line.event.Room
+	}
+	return defaultRoom
+}
+
+// applyConversation records a conversation's members. A conversation we are
+// new to gets a tab and its history; one we are no longer a member of is
+// closed.
+func (m *model) applyConversation(ev Event) {
+	id := ev.Room
+	if !slices.Contains(ev.Members, m.username) {
+		delete(m.conversations, id)
+		delete(m.unread, id)
+		m.tabs = slices.DeleteFunc(m.tabs, func(tab string) bool { return tab == id })
+		if m.activeRoom == id {
+			m.activeRoom = defaultRoom
+		}
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: "You left " + m.conversationTitle(id, ev.Members) + ".", Time: ev.Time}})
+		return
+	}
+
+	_, known := m.conversations[id]
+	m.conversations[id] = ev.Members
+	if !known {
+		m.tabs = append(m.tabs, id)
+		if _, seen := m.lastSeq[id]; !seen && m.conn != nil && !m.resuming[id] {
+			m.resuming[id] = true
+			if err := m.send("", fmt.Sprintf("/resume %s 0", id)); err != nil {
+				log.Printf("Failed to request history of %s: %v", id, err)
+			}
+		}
+	}
+	if ev.Text != "" {
+		m.appendLine(chatLine{event: ev})
+		m.countUnread(ev)
+	} else {
+		m.refreshViewport()
+	}
+}
+
+// countUnread notes a new line in a tab other than the one shown.
+func (m *model) countUnread(ev Event) {
+	if room := roomOf(chatLine{event: ev}); room != m.activeRoom {
+		m.unread[room]++
+	}
+}
+
+// switchTab moves delta tabs to the left or right, wrapping around.
+func (m *model) switchTab(delta int) {
+	tabs := append([]string{defaultRoom}, m.tabs...)
+	current := slices.Index(tabs, m.activeRoom)
+	next := (current + delta + len(tabs)) % len(tabs)
+	m.activeRoom = tabs[next]
+	delete(m.unread, m.activeRoom)
+	m.selectedID = ""
+	m.threadID = ""
+	m.refreshViewport()
+}
+
+// conversationTitle names a conversation after its other members.
+func (m *model) conversationTitle(id string, members []string) string {
+	others := slices.DeleteFunc(slices.Clone(members), func(name string) bool { return name == m.username })
+	if len(others) == 0 {
+		return id
+	}
+	return strings.Join(others, ", ")
+}
+
+// tabBar renders the lobby and conversation tabs, or nothing when we are in
+// no conversations.
+func (m *model) tabBar() string {
+	if len(m.tabs) == 0 {
+		return ""
+	}
+	var tabs []string
+	for _, room := range append([]string{defaultRoom}, m.tabs...) {
+		title := room
+		if isConversation(room) {
+			title = m.conversationTitle(room, m.conversations[room])
+		}
+		switch {
+		case room == m.activeRoom:
+			tabs = append(tabs, activeTabStyle.Render(title))
+		case m.unread[room] > 0:
+			tabs = append(tabs, unreadTabStyle.Render(fmt.Sprintf("%s (%d)", title, m.unread[room])))
+		default:
+			tabs = append(tabs, tabStyle.Render(title))
+		}
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, tabs...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestSwitchTab(t *testing.T) {
+	tests := []struct {
+		name   string
+		active string
+		delta  int
+		want   string
+	}{
+		{"right", defaultRoom, 1, "dm-1"},
+		{"left wraps", defaultRoom, -1, "dm-2"},
+		{"right wraps", "dm-2", 1, defaultRoom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.tabs = []string{"dm-1", "dm-2"}
+			m.activeRoom = tt.active
+			m.unread[tt.want] = 3
+			m.switchTab(tt.delta)
+			if m.activeRoom != tt.want {
+				t.Errorf("active tab = %s, want %s", m.activeRoom, tt.want)
+			}
+			if m.unread[tt.want] != 0 {
+				t.Error("unread count of the shown tab not cleared")
+			}
+		})
+	}
+}
+
+func TestApplyConversation(t *testing.T) {
+	tests := []struct {
+		name     string
+		members  []string
+		wantTabs int
+	}{
+		{"joined", []string{"alice", "bob"}, 1},
+		{"left", []string{"bob"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.username = "alice"
+			m.applyConversation(Event{Type: eventConversation, Room: "dm-1", Members: []string{"alice", "bob"}})
+			m.activeRoom = "dm-1"
+			m.applyConversation(Event{Type: eventConversation, Room: "dm-1", Members: tt.members})
+			if len(m.tabs) != tt.wantTabs {
+				t.Errorf("tabs = %v, want %d", m.tabs, tt.wantTabs)
+			}
+			if tt.wantTabs == 0 && m.activeRoom != defaultRoom {
+				t.Errorf("active tab = %s after leaving", m.activeRoom)
+			}
+		})
+	}
+}
+
+func TestConversationTitle(t *testing.T) {
+	tests := []struct {
+		members []string
+		want    string
+	}{
+		{[]string{"alice", "bob", "carol"}, "bob, carol"},
+		{[]string{"alice"}, "dm-1"},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.username = "alice"
+		if got := m.conversationTitle("dm-1", tt.members); got != tt.want {
+			t.Errorf("conversationTitle(%v) = %q, want %q", tt.members, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// identityEnv overrides where the client keeps its keypair.
+const identityEnv = "CHAT_IDENTITY"
+
+// identity is this client's NaCl box keypair. Its public half is published to
+// the server on connect; private messages are sealed for the recipient's
+// public key, so the server only ever relays ciphertext.
+type identity struct {
+	public, private [32]byte
+}
+
+// pendingPM is a private message waiting for its recipient's public key.
+type pendingPM struct {
+	clientID string
+	text     string
+}
+
+// identityPath is where the keypair is kept: $CHAT_IDENTITY, or
+// identity.key in the chat directory of the user's config directory.
+func identityPath() (string, error) {
+	if path := os.Getenv(identityEnv); path != "" {
+		return expandHome(path), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chat", "identity.key"), nil
+}
+
+// loadIdentity reads the keypair, creating it on first use. It is kept across
+// restarts so that messages sealed while the client was offline can still be
+// opened and so that its fingerprint stays the same.
+func loadIdentity() (*identity, error) {
+	path, err := identityPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		id, err := newIdentity()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return id, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(id.private[:]) + "\n"
+		return id, os.WriteFile(path, []byte(encoded), 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(private) != 32 {
+		return nil, fmt.Errorf("%s is not a private key", path)
+	}
+	id := &identity{}
+	copy(id.private[:], private)
+	curve25519.ScalarBaseMult(&id.public, &id.private)
+	return id, nil
+}
+
+// newIdentity generates a keypair.
+func newIdentity() (*identity, error) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &identity{public: *public, private: *private}, nil
+}
+
+// publicKey is the base64 public key sent with /pubkey.
+func (id *identity) publicKey() string {
+	return base64.StdEncoding.EncodeToString(id.public[:])
+}
+
+// fingerprint renders a public key for people to compare, e.g. over the
+// phone: the start of its SHA-256 in groups of four hex digits.
+func fingerprint(key [32]byte) string {
+	sum := sha256.Sum256(key[:])
+	digits := hex.EncodeToString(sum[:16])
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// sendPM handles "/pm <nick> <message>": it seals the message for nick, first
+// asking the server for nick's public key if we don't know it yet.
+func (m *model) sendPM(clientID, args string) error {
+	to, text, _ := strings.Cut(args, " ")
+	to, text = strings.TrimSpace(to), strings.TrimSpace(text)
+	if to == "" || text == "" {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: "Usage: /pm <username> <message>"}})
+		return nil
+	}
+	if _, ok := m.peerKeys[to]; ok {
+		return m.sealPM(clientID, to, text)
+	}
+	m.pendingPMs[to] = append(m.pendingPMs[to], pendingPM{clientID: clientID, text: text})
+	return m.send("", "/key "+to)
+}
+
+// sealPM encrypts text for to and sends it.
+func (m *model) sealPM(clientID, to, text string) error {
+	peer := m.peerKeys[to]
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	sealed := box.Seal(nonce[:], []byte(text), &nonce, &peer, &m.identity.private)
+	return m.send(clientID, fmt.Sprintf("/epm %s %s", to, base64.StdEncoding.EncodeToString(sealed)))
+}
+
+// applyKey records the public key the server sent for a user, sends the
+// private messages that were waiting for it and answers /verify.
+func (m *model) applyKey(ev Event) {
+	nick := ev.From
+	pending := m.pendingPMs[nick]
+	delete(m.pendingPMs, nick)
+	verifying := m.verifying[nick]
+	delete(m.verifying, nick)
+
+	key, err := decodeKey(ev.Key)
+	if ev.Error != "" || err != nil {
+		reason := ev.Error
+		if reason == "" {
+			reason = fmt.Sprintf("The server sent an invalid key for %s.", nick)
+		}
+		if len(pending) > 0 || verifying {
+			m.appendLine(chatLine{event: Event{Type: eventError, Text: reason}})
+		}
+		return
+	}
+	m.trustKey(nick, key)
+
+	for _, pm := range pending {
+		if err := m.sealPM(pm.clientID, nick, pm.text); err != nil {
+			log.Printf("Error sending PM to %s: %v", nick, err)
+			m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Your message to %s was not sent: %v", nick, err)}})
+		}
+	}
+	if verifying {
+		text := fmt.Sprintf("Fingerprints · you: %s · %s: %s. Compare them with %s over another channel; if they match, nobody can read your private messages.",
+			fingerprint(m.identity.public), nick, fingerprint(key), nick)
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: text}})
+	}
+}
+
+// trustKey remembers key as nick's, warning if it replaces a different one:
+// either nick reinstalled their client or someone is in the middle.
+func (m *model) trustKey(nick string, key [32]byte) {
+	if old, ok := m.peerKeys[nick]; ok && old != key {
+		text := fmt.Sprintf("Warning: %s's encryption key has changed. Check it with /verify %s.", nick, nick)
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: text}})
+	}
+	m.peerKeys[nick] = key
+}
+
+// verify handles "/verify <nick>": it fetches nick's current key and shows
+// both fingerprints once it arrives.
+func (m *model) verify(nick string) error {
+	if nick == "" {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: "Usage: /verify <username>"}})
+		return nil
+	}
+	m.verifying[nick] = true
+	return m.send("", "/key "+nick)
+}
+
+// openPM decrypts a sealed private message in place: one sent to us, sealed
+// with the key it carries, or the server's ack of one we sent, sealed for the
+// key we have for its recipient.
+func (m *model) openPM(ev *Event) {
+	if ev.Sealed == "" {
+		return
+	}
+	var peer [32]byte
+	if ev.Type == eventAck {
+		peer = m.peerKey(ev.To)
+	} else if key, err := decodeKey(ev.Key); err == nil {
+		m.trustKey(ev.From, key)
+		peer = key
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ev.Sealed)
+	ev.Sealed = ""
+	var text []byte
+	ok := err == nil && len(sealed) > 24
+	if ok {
+		var nonce [24]byte
+		copy(nonce[:], sealed)
+		text, ok = box.Open(nil, sealed[24:], &nonce, &peer, &m.identity.private)
+	}
+	if !ok {
+		ev.Text = "[this message could not be decrypted]"
+		return
+	}
+	if lang, code, isSnippet := parseSnippet(string(text)); isSnippet {
+		ev.Code, ev.Lang, ev.Text = true, lang, code
+		return
+	}
+	ev.Text, ev.Spans = parseFormatting(string(text))
+}
+
+// peerKey returns the key we have for nick. The server acks private messages
+// with the recipient's nickname as they wrote it, which may differ in case
+// from the one we typed.
+func (m *model) peerKey(nick string) [32]byte {
+	if key, ok := m.peerKeys[nick]; ok {
+		return key
+	}
+	for name, key := range m.peerKeys {
+		if strings.EqualFold(name, nick) {
+			return key
+		}
+	}
+	return [32]byte{}
+}
+
+// decodeKey parses a base64 public key.
+func decodeKey(encoded string) ([32]byte, error) {
+	var key [32]byte
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != len(key) {
+		return key, errors.New("invalid public key")
+	}
+	copy(key[:], raw)
+	return key, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestOpenPM(t *testing.T) {
+	me, err := newIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := newIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := func(text string, from *identity, to [32]byte) string {
+		var nonce [24]byte
+		rand.Read(nonce[:])
+		return base64.StdEncoding.EncodeToString(box.Seal(nonce[:], []byte(text), &nonce, &to, &from.private))
+	}
+
+	tests := []struct {
+		name      string
+		ev        Event
+		wantText  string
+		wantCode  bool
+		wantSpans int
+	}{
+		{
+			name:     "sent to us",
+			ev:       Event{Type: eventPM, From: "alice", Key: alice.publicKey(), Sealed: seal("hi there", alice, me.public)},
+			wantText: "hi there",
+		},
+		{
+			name:      "formatting",
+			ev:        Event{Type: eventPM, From: "alice", Key: alice.publicKey(), Sealed: seal("*hi* there", alice, me.public)},
+			wantText:  "hi there",
+			wantSpans: 1,
+		},
+		{
+			name:     "snippet",
+			ev:       Event{Type: eventPM, From: "alice", Key: alice.publicKey(), Sealed: seal("```go\nx := 1\n```", alice, me.public)},
+			wantText: "x := 1",
+			wantCode: true,
+		},
+		{
+			name:     "ack of ours",
+			ev:       Event{Type: eventAck, From: "me", To: "alice", Sealed: seal("sent", me, alice.public)},
+			wantText: "sent",
+		},
+		{
+			name:     "sealed for someone else",
+			ev:       Event{Type: eventPM, From: "alice", Key: alice.publicKey(), Sealed: seal("secret", alice, alice.public)},
+			wantText: "[this message could not be decrypted]",
+		},
+		{
+			name:     "garbage",
+			ev:       Event{Type: eventPM, From: "alice", Key: alice.publicKey(), Sealed: "!!"},
+			wantText: "[this message could not be decrypted]",
+		},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.identity = me
+		m.peerKeys["alice"] = alice.public
+		ev := tt.ev
+		m.openPM(&ev)
+		if ev.Text != tt.wantText || ev.Code != tt.wantCode || len(ev.Spans) != tt.wantSpans || ev.Sealed != "" {
+			t.Errorf("%s: opened %+v", tt.name, ev)
+		}
+	}
+}
+
+func TestTrustKeyWarnsOnChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		known    bool
+		same     bool
+		wantWarn bool
+	}{
+		{"first key", false, false, false},
+		{"same key", true, true, false},
+		{"changed key", true, false, true},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		key := [32]byte{1}
+		if tt.known {
+			m.peerKeys["bob"] = key
+			if !tt.same {
+				m.peerKeys["bob"] = [32]byte{2}
+			}
+		}
+		m.trustKey("bob", key)
+		warned := slices.ContainsFunc(m.messages, func(l chatLine) bool { return l.event.Type == eventError })
+		if warned != tt.wantWarn || m.peerKeys["bob"] != key {
+			t.Errorf("%s: warned = %v, want %v", tt.name, warned, tt.wantWarn)
+		}
+	}
+}
+
+func TestPeerKeyFoldsCase(t *testing.T) {
+	m := initialModel()
+	m.peerKeys["bob"] = [32]byte{1}
+	tests := []struct {
+		nick string
+		want [32]byte
+	}{
+		{"bob", [32]byte{1}},
+		{"Bob", [32]byte{1}},
+		{"carol", [32]byte{}},
+	}
+	for _, tt := range tests {
+		if got := m.peerKey(tt.nick); got != tt.want {
+			t.Errorf("peerKey(%q) = %v, want %v", tt.nick, got, tt.want)
+		}
+	}
+}
+
+func TestClientParseFormatting(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantText  string
+		wantSpans []Span
+	}{
+		{"plain", "plain", nil},
+		{"*bold* and _it_", "bold and it", []Span{{Style: spanBold, Start: 0, End: 4}, {Style: spanItalic, Start: 9, End: 11}}},
+		{"run `a*b*c`", "run a*b*c", []Span{{Style: spanCode, Start: 4, End: 9}}},
+		{"2*3*4", "2*3*4", nil},
+		{"snake_case_name", "snake_case_name", nil},
+		{"* not bold *", "* not bold *", nil},
+	}
+	for _, tt := range tests {
+		text, spans := parseFormatting(tt.in)
+		if text != tt.wantText || !slices.Equal(spans, tt.wantSpans) {
+			t.Errorf("parseFormatting(%q) = %q, %v; want %q, %v", tt.in, text, spans, tt.wantText, tt.wantSpans)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportScrollback handles "/export <path>": it writes the messages of the
+// tab or thread shown to a local file, as Markdown for .md, JSON Lines for
+// .jsonl and plain text otherwise. Existing files are never overwritten.
+func (m *model) exportScrollback(path string) {
+	path = expandHome(path)
+	if path == "" {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: "Usage: /export <path>"}})
+		return
+	}
+
+	var events []Event
+	for _, line := range m.visibleLines() {
+		ev := line.event
+		if (ev.Type != eventMessage && ev.Type != eventPM) || ev.Deleted || line.status != statusConfirmed {
+			continue
+		}
+		events = append(events, ev)
+	}
+
+	var b bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		enc := json.NewEncoder(&b)
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot export to %s: %v", path, err)}})
+				return
+			}
+		}
+	case ".md", ".markdown":
+		for _, ev := range events {
+			b.WriteString(markdownLine(ev))
+		}
+	default:
+		for _, ev := range events {
+			b.WriteString(textLine(ev))
+		}
+	}
+
+	// Only we may read our messages, like the server's own exports
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err == nil {
+		_, err = f.Write(b.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot export to %s: %v", path, err)}})
+		return
+	}
+	m.appendLine(chatLine{event: Event{Type: eventSystem, Text: fmt.Sprintf("Exported %d messages to %s", len(events), path)}})
+}
+
+// exportAuthor names who wrote ev, and to whom for a private message.
+func exportAuthor(ev Event) string {
+	if ev.Type == eventPM {
+		return ev.From + " → " + ev.To
+	}
+	return ev.From
+}
+
+// textLine renders ev as plain text, the way it was typed.
+func textLine(ev Event) string {
+	stamp := ev.Time.Local().Format("2006-01-02 15:04")
+	var body string
+	switch {
+	case ev.File != nil:
+		body = fmt.Sprintf("%s: [file] %s (%s)", exportAuthor(ev), ev.File.Name, formatSize(ev.File.Size))
+	case ev.Action:
+		body = fmt.Sprintf("* %s %s", ev.From, withMarkup(ev))
+	default:
+		body = fmt.Sprintf("%s: %s", exportAuthor(ev), withMarkup(ev))
+	}
+	if ev.Edited {
+		body += " (edited)"
+	}
+	return fmt.Sprintf("[%s] %s\n", stamp, body)
+}
+
+// markdownLine renders ev as a Markdown list item, with code snippets as
+// fenced blocks below it.
+func markdownLine(ev Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- %s **%s**", ev.Time.Local().Format("2006-01-02 15:04"), escapeMarkdown(exportAuthor(ev)))
+	switch {
+	case ev.File != nil:
+		fmt.Fprintf(&b, ": 📎 %s (%s)", escapeMarkdown(ev.File.Name), formatSize(ev.File.Size))
+	case ev.Code:
+		b.WriteString(":")
+	case ev.Action:
+		fmt.Fprintf(&b, " _%s_", markdownSpans(ev))
+	default:
+		fmt.Fprintf(&b, ": %s", markdownSpans(ev))
+	}
+	if ev.Edited {
+		b.WriteString(" _(edited)_")
+	}
+	b.WriteString("\n")
+	if ev.Code && ev.File == nil {
+		fence := codeFence
+		for strings.Contains(ev.Text, fence) {
+			fence += "`"
+		}
+		fmt.Fprintf(&b, "\n  %s%s\n", fence, ev.Lang)
+		for _, line := range strings.Split(ev.Text, "\n") {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+		fmt.Fprintf(&b, "  %s\n\n", fence)
+	}
+	return b.String()
+}
+
+// markdownSpans renders the text of ev with its formatting spans as Markdown.
+// This mirrors server/export.go.
+func markdownSpans(ev Event) string {
+	marks := map[string]string{spanBold: "**", spanItalic: "_", spanCode: "`"}
+	var b strings.Builder
+	at := 0
+	for _, span := range ev.Spans {
+		mark, ok := marks[span.Style]
+		if !ok || span.Start < at || span.End > len(ev.Text) || span.Start >= span.End {
+			continue
+		}
+		b.WriteString(escapeMarkdown(ev.Text[at:span.Start]))
+		inner := ev.Text[span.Start:span.End]
+		if span.Style != spanCode {
+			inner = escapeMarkdown(inner)
+		}
+		b.WriteString(mark + inner + mark)
+		at = span.End
+	}
+	b.WriteString(escapeMarkdown(ev.Text[at:]))
+	return b.String()
+}
+
+// escapeMarkdown keeps characters of plain text from being read as Markdown.
+func escapeMarkdown(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ReplaceAll(text, "\n", " ") {
+		if strings.ContainsRune("\\`*_[]<>|~", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
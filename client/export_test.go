@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportLines(t *testing.T) {
+	at := time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		ev       Event
+		wantText string
+		wantMD   string
+	}{
+		{
+			name:     "message",
+			ev:       Event{Type: eventMessage, From: "alice", Text: "hi *all*", Time: at},
+			wantText: "[2026-01-02 15:04] alice: hi *all*\n",
+			wantMD:   "- 2026-01-02 15:04 **alice**: hi \\*all\\*\n",
+		},
+		{
+			name:     "bold and edited",
+			ev:       Event{Type: eventMessage, From: "alice", Text: "hi all", Spans: []Span{{Start: 3, End: 6, Style: spanBold}}, Edited: true, Time: at},
+			wantText: "[2026-01-02 15:04] alice: hi *all* (edited)\n",
+			wantMD:   "- 2026-01-02 15:04 **alice**: hi **all** _(edited)_\n",
+		},
+		{
+			name:     "private message",
+			ev:       Event{Type: eventPM, From: "alice", To: "bob", Text: "psst", Time: at},
+			wantText: "[2026-01-02 15:04] alice → bob: psst\n",
+			wantMD:   "- 2026-01-02 15:04 **alice → bob**: psst\n",
+		},
+		{
+			name:     "action",
+			ev:       Event{Type: eventMessage, From: "alice", Text: "waves", Action: true, Time: at},
+			wantText: "[2026-01-02 15:04] * alice waves\n",
+			wantMD:   "- 2026-01-02 15:04 **alice** _waves_\n",
+		},
+		{
+			name:     "file",
+			ev:       Event{Type: eventMessage, From: "alice", Text: "a.txt", File: &FileInfo{Name: "a_b.txt", Size: 2048}, Time: at},
+			wantText: "[2026-01-02 15:04] alice: [file] a_b.txt (2.0 KB)\n",
+			wantMD:   "- 2026-01-02 15:04 **alice**: 📎 a\\_b.txt (2.0 KB)\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := textLine(tt.ev); got != tt.wantText {
+			t.Errorf("%s: textLine = %q, want %q", tt.name, got, tt.wantText)
+		}
+		if got := markdownLine(tt.ev); got != tt.wantMD {
+			t.Errorf("%s: markdownLine = %q, want %q", tt.name, got, tt.wantMD)
+		}
+	}
+}
+
+func TestMarkdownSnippetFence(t *testing.T) {
+	ev := Event{Type: eventMessage, From: "alice", Text: "a ``` b", Code: true, Lang: "go", Time: time.Now()}
+	if got := markdownLine(ev); !strings.Contains(got, "````go\n") {
+		t.Errorf("markdownLine did not lengthen the fence:\n%s", got)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gorilla/websocket"
+)
+
+// fileChunkSize is the most file data sent in one binary frame. It must stay
+// below the server's frame limit; this mirrors server/files.go.
+const fileChunkSize = 32 * 1024
+
+var fileCardStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA")).Background(lipgloss.Color("#3A3A4A")).Padding(0, 1)
+
+// upload is a file we announced with /upload, waiting for the server to
+// accept it.
+type upload struct {
+	path string
+	size int64
+}
+
+// downloadRequest says what to do with a file we asked the server for.
+type downloadRequest struct {
+	dest    string // where to save it; the current directory if empty
+	preview bool   // keep it in memory to show an image preview instead
+	open    bool   // save it to a temporary file and open it
+}
+
+// download is a file being received from the server.
+type download struct {
+	downloadRequest
+	info     FileInfo
+	path     string
+	file     *os.File      // nil for previews
+	data     *bytes.Buffer // content of a preview
+	hash     hash.Hash
+	received int64
+}
+
+// fileChunkMsg carries a binary frame read from the server.
+type fileChunkMsg struct{ data []byte }
+
+// sendFile handles "/send <nick|room> <path>": it hashes the file and asks
+// the server to accept it. The content follows once the server agrees.
+func (m *model) sendFile(clientID, args string) tea.Cmd {
+	target, path, _ := strings.Cut(args, " ")
+	path = expandHome(strings.TrimSpace(path))
+	if target == "" || path == "" {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: "Usage: /send <nick|room> <path>"}})
+		return nil
+	}
+	size, sum, err := hashFile(path)
+	if err != nil {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot send %s: %v", path, err)}})
+		return nil
+	}
+
+	name := filepath.Base(path)
+	if err := m.send(clientID, fmt.Sprintf("/upload %s %d %s %s", target, size, sum, name)); err != nil {
+		log.Printf("Send error: %v", err)
+		conn := m.conn
+		return func() tea.Msg { return disconnectedMsg{conn: conn} }
+	}
+	m.uploads[clientID] = upload{path: path, size: size}
+	if target == defaultRoom || isConversation(target) {
+		echo := Event{Type: eventMessage, ClientID: clientID, Room: target, From: m.username, Text: name, File: &FileInfo{Name: name, Size: size}}
+		m.appendLine(chatLine{event: echo, status: statusPending, mine: true})
+	}
+	return nil
+}
+
+// hashFile returns the size and hex SHA-256 of the regular file at path.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err != nil {
+		return 0, "", err
+	} else if !st.Mode().IsRegular() {
+		return 0, "", errors.New("not a regular file")
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	if size == 0 {
+		return 0, "", errors.New("file is empty")
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// streamFile returns a command that sends the content of an accepted upload
+// as binary frames. If the file can no longer be read in full, the upload is
+// cancelled so the server stops waiting for it.
+func (m *model) streamFile(clientID string) tea.Cmd {
+	up, ok := m.uploads[clientID]
+	if !ok || m.conn == nil {
+		return nil
+	}
+	conn, writeMux := m.conn, m.writeMux
+	return func() tea.Msg {
+		cancel := func(err error) tea.Msg {
+			log.Printf("Upload of %s failed: %v", up.path, err)
+			if err := m.send("", "/upload cancel"); err != nil {
+				return disconnectedMsg{conn: conn}
+			}
+			return nil
+		}
+
+		f, err := os.Open(up.path)
+		if err != nil {
+			return cancel(err)
+		}
+		defer f.Close()
+		buf := make([]byte, fileChunkSize)
+		var sent int64
+		for sent < up.size {
+			n, err := f.Read(buf[:min(int64(len(buf)), up.size-sent)])
+			if n > 0 {
+				writeMux.Lock()
+				werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n])
+				writeMux.Unlock()
+				if werr != nil {
+					log.Printf("Error sending %s: %v", up.path, werr)
+					return disconnectedMsg{conn: conn}
+				}
+				sent += int64(n)
+			}
+			if err != nil && sent < up.size {
+				if errors.Is(err, io.EOF) {
+					err = errors.New("file shrank while sending")
+				}
+				return cancel(err)
+			}
+		}
+		log.Printf("Sent %d bytes of %s", sent, up.path)
+		return nil
+	}
+}
+
+// requestDownload handles "/download <id> [dest]". The file is saved when
+// the server sends it.
+func (m *model) requestDownload(args string) tea.Cmd {
+	id, dest, _ := strings.Cut(args, " ")
+	if id == "" {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: "Usage: /download <id> [dest]"}})
+		return nil
+	}
+	return m.fetch(id, downloadRequest{dest: expandHome(strings.TrimSpace(dest))})
+}
+
+// fetch asks the server for file id, to be handled as req says.
+func (m *model) fetch(id string, req downloadRequest) tea.Cmd {
+	if err := m.send("", "/download "+id); err != nil {
+		log.Printf("Send error: %v", err)
+		conn := m.conn
+		return func() tea.Msg { return disconnectedMsg{conn: conn} }
+	}
+	m.downloads[id] = req
+	return nil
+}
+
+// startDownload opens the destination of a file the server is about to send.
+// Existing files are never overwritten.
+func (m *model) startDownload(ev Event) {
+	req, ok := m.downloads[ev.ID]
+	if !ok || ev.File == nil {
+		return
+	}
+	delete(m.downloads, ev.ID)
+	// A download still open here was cut short by the server
+	m.abortDownload("incomplete")
+
+	dl := &download{downloadRequest: req, info: *ev.File, hash: sha256.New()}
+	if req.preview {
+		dl.data = &bytes.Buffer{}
+		m.download = dl
+		return
+	}
+
+	name := filepath.Base(ev.File.Name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "download"
+	}
+	var err error
+	switch {
+	case req.open:
+		dl.file, err = os.CreateTemp("", "chat-*"+filepath.Ext(name))
+	case req.dest == "":
+		dl.file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	default:
+		path := req.dest
+		if st, statErr := os.Stat(path); statErr == nil && st.IsDir() {
+			path = filepath.Join(path, name)
+		}
+		dl.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot save %s: %v", name, err)}})
+		return
+	}
+	dl.path = dl.file.Name()
+	m.download = dl
+	m.appendLine(chatLine{event: Event{Type: eventSystem, Text: fmt.Sprintf("Downloading %s (%s)…", name, formatSize(ev.File.Size)), Time: ev.Time}})
+}
+
+// receiveChunk writes a binary frame to the file being downloaded, and
+// checks the file once it is complete.
+func (m *model) receiveChunk(data []byte) {
+	dl := m.download
+	if dl == nil {
+		log.Printf("Ignoring %d bytes of file data: no download in progress", len(data))
+		return
+	}
+	if dl.received+int64(len(data)) > dl.info.Size {
+		m.abortDownload("more data than expected")
+		return
+	}
+	if dl.data != nil {
+		dl.data.Write(data)
+	} else if _, err := dl.file.Write(data); err != nil {
+		m.abortDownload(err.Error())
+		return
+	}
+	dl.hash.Write(data)
+	dl.received += int64(len(data))
+	if dl.received < dl.info.Size {
+		return
+	}
+
+	m.download = nil
+	if dl.preview {
+		if hex.EncodeToString(dl.hash.Sum(nil)) != dl.info.Hash {
+			log.Printf("Discarding preview of %s: hash mismatch", dl.info.Name)
+			return
+		}
+		m.addPreview(dl.info, dl.data.Bytes())
+		return
+	}
+	if err := dl.file.Close(); err != nil {
+		os.Remove(dl.path)
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Download of %s failed: %v", dl.info.Name, err)}})
+		return
+	}
+	if hex.EncodeToString(dl.hash.Sum(nil)) != dl.info.Hash {
+		os.Remove(dl.path)
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Download of %s failed: the file was damaged in transit", dl.info.Name)}})
+		return
+	}
+	if dl.open {
+		m.openPath(dl.path)
+		return
+	}
+	m.appendLine(chatLine{event: Event{Type: eventSystem, Text: fmt.Sprintf("Saved %s to %s", dl.info.Name, dl.path)}})
+}
+
+// abortDownload discards the file being downloaded, if any.
+func (m *model) abortDownload(reason string) {
+	dl := m.download
+	if dl == nil {
+		return
+	}
+	m.download = nil
+	if dl.preview {
+		log.Printf("Preview of %s failed: %s", dl.info.Name, reason)
+		return
+	}
+	dl.file.Close()
+	os.Remove(dl.path)
+	m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Download of %s failed: %s", dl.info.Name, reason)}})
+}
+
+// renderFileCard shows a shared file with the command that fetches it.
+func renderFileCard(file *FileInfo) string {
+	card := fmt.Sprintf("📎 %s · %s", file.Name, formatSize(file.Size))
+	if file.ID != "" {
+		card += " · /download " + file.ID
+	}
+	return fileCardStyle.Render(card)
+}
+
+// expandHome replaces a leading "~/" in path with the home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
+
+// formatSize renders a number of bytes for people, e.g. "1.5 MB". This
+// mirrors server/files.go.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
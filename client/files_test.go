@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{10 << 20, "10.0 MB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.txt")
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(full, []byte("hello"), 0o600)
+	os.WriteFile(empty, nil, 0o600)
+	sum := sha256.Sum256([]byte("hello"))
+
+	tests := []struct {
+		path     string
+		wantSize int64
+		wantHash string
+		wantErr  bool
+	}{
+		{full, 5, hex.EncodeToString(sum[:]), false},
+		{empty, 0, "", true},
+		{dir, 0, "", true},
+		{filepath.Join(dir, "missing"), 0, "", true},
+	}
+	for _, tt := range tests {
+		size, hash, err := hashFile(tt.path)
+		if size != tt.wantSize || hash != tt.wantHash || (err != nil) != tt.wantErr {
+			t.Errorf("hashFile(%s) = %d, %q, %v", filepath.Base(tt.path), size, hash, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	content := []byte("file content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name     string
+		hash     string
+		chunks   [][]byte
+		wantFile bool
+	}{
+		{"whole file", hash, [][]byte{content}, true},
+		{"in chunks", hash, [][]byte{content[:4], content[4:]}, true},
+		{"damaged", hash, [][]byte{[]byte("file CONTENT")}, false},
+		{"too long", hash, [][]byte{[]byte("file content!")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := initialModel()
+			m.downloads["f1"] = downloadRequest{dest: dir}
+			m.startDownload(Event{Type: eventDownload, ID: "f1", File: &FileInfo{ID: "f1", Name: "../notes.txt", Size: int64(len(content)), Hash: tt.hash}})
+			for _, chunk := range tt.chunks {
+				m.receiveChunk(chunk)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+			if (err == nil) != tt.wantFile {
+				t.Fatalf("file saved = %v, want %v", err == nil, tt.wantFile)
+			}
+			if tt.wantFile && string(got) != string(content) {
+				t.Errorf("saved %q", got)
+			}
+			if m.download != nil {
+				t.Errorf("download still open")
+			}
+		})
+	}
+}
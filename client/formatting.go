@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Inline formatting styles carried in Span.Style. These mirror
+// server/formatting.go.
+const (
+	spanBold   = "bold"
+	spanItalic = "italic"
+	spanCode   = "code"
+)
+
+var (
+	actionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#C792EA")).Italic(true)
+	codeStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#C3E88D")).Background(lipgloss.Color("#2E2E3E"))
+
+	spanMarkup  = map[string]string{spanBold: "*", spanItalic: "_", spanCode: "`"}
+	spanMarkers = map[byte]string{'*': spanBold, '_': spanItalic, '`': spanCode}
+)
+
+// renderSpans renders text in base style with its formatting spans applied
+// on top. Spans that don't fit the text are ignored rather than trusted.
+func renderSpans(text string, spans []Span, base lipgloss.Style) string {
+	var b strings.Builder
+	at := 0
+	for _, span := range spans {
+		if span.Start < at || span.End > len(text) || span.Start >= span.End {
+			continue
+		}
+		if span.Start > at {
+			b.WriteString(base.Render(text[at:span.Start]))
+		}
+		b.WriteString(spanStyle(base, span.Style).Render(text[span.Start:span.End]))
+		at = span.End
+	}
+	if at < len(text) {
+		b.WriteString(base.Render(text[at:]))
+	}
+	return b.String()
+}
+
+// spanStyle returns the style for a span of the given kind inside base.
+func spanStyle(base lipgloss.Style, style string) lipgloss.Style {
+	switch style {
+	case spanBold:
+		return base.Bold(true)
+	case spanItalic:
+		return base.Italic(true)
+	case spanCode:
+		return codeStyle
+	}
+	return base
+}
+
+// withMarkup puts the markup of ev's spans back into its text, so that it can
+// be edited the way it was typed.
+func withMarkup(ev Event) string {
+	if ev.Code {
+		return fenced(ev)
+	}
+	var b strings.Builder
+	at := 0
+	for _, span := range ev.Spans {
+		marker, ok := spanMarkup[span.Style]
+		if !ok || span.Start < at || span.End > len(ev.Text) || span.Start >= span.End {
+			continue
+		}
+		b.WriteString(ev.Text[at:span.Start])
+		b.WriteString(marker + ev.Text[span.Start:span.End] + marker)
+		at = span.End
+	}
+	b.WriteString(ev.Text[at:])
+	return b.String()
+}
+
+// parseFormatting strips *bold*, _italic_ and `code` markup from text and
+// returns the plain text with the spans it described. The server parses chat
+// lines itself; the client only needs this for end-to-end encrypted private
+// messages, which the server cannot read. This mirrors server/formatting.go.
+func parseFormatting(text string) (string, []Span) {
+	var (
+		out   strings.Builder
+		spans []Span
+	)
+	for i := 0; i < len(text); {
+		style, isMarker := spanMarkers[text[i]]
+		if !isMarker || !opensSpan(text, i) {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		end := closingMarker(text, i, style == spanCode)
+		if end < 0 {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		start := out.Len()
+		out.WriteString(text[i+1 : end])
+		spans = append(spans, Span{Style: style, Start: start, End: out.Len()})
+		i = end + 1
+	}
+	return out.String(), spans
+}
+
+// opensSpan reports whether the marker at i can start a span: it begins a
+// word and is followed by something other than space.
+func opensSpan(text string, i int) bool {
+	if i+1 >= len(text) || text[i+1] == text[i] {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(text[i+1:])
+	if unicode.IsSpace(next) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsSpace(prev) || unicode.IsPunct(prev)
+}
+
+// closingMarker returns the index of the marker closing the span opened at
+// start, or -1. Outside code, the closing marker must end a word.
+func closingMarker(text string, start int, code bool) int {
+	marker := text[start]
+	for j := start + 2; j < len(text); j++ {
+		if text[j] != marker {
+			continue
+		}
+		if code {
+			return j
+		}
+		prev, _ := utf8.DecodeLastRuneInString(text[:j])
+		if unicode.IsSpace(prev) {
+			continue
+		}
+		if j+1 == len(text) {
+			return j
+		}
+		next, _ := utf8.DecodeRuneInString(text[j+1:])
+		if unicode.IsSpace(next) || unicode.IsPunct(next) {
+			return j
+		}
+	}
+	return -1
+}
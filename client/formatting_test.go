@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func TestWithMarkup(t *testing.T) {
+	tests := []struct {
+		text  string
+		spans []Span
+		want  string
+	}{
+		{"plain", nil, "plain"},
+		{"bold", []Span{{spanBold, 0, 4}}, "*bold*"},
+		{"one and two", []Span{{spanBold, 0, 3}, {spanItalic, 8, 11}}, "*one* and _two_"},
+		{"run go test now", []Span{{spanCode, 4, 11}}, "run `go test` now"},
+		// Spans that don't fit the text are dropped
+		{"short", []Span{{spanBold, 2, 10}}, "short"},
+		{"overlap", []Span{{spanBold, 0, 4}, {spanItalic, 2, 6}}, "*over*lap"},
+		{"unknown", []Span{{"blink", 0, 7}}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := withMarkup(Event{Text: tt.text, Spans: tt.spans}); got != tt.want {
+			t.Errorf("withMarkup(%q, %v) = %q, want %q", tt.text, tt.spans, got, tt.want)
+		}
+	}
+}
+
+func TestRenderSpansKeepsText(t *testing.T) {
+	tests := []struct {
+		text  string
+		spans []Span
+	}{
+		{"plain", nil},
+		{"one and two", []Span{{spanBold, 0, 3}, {spanItalic, 8, 11}}},
+		{"short", []Span{{spanBold, 2, 10}}},
+		{"backwards", []Span{{spanBold, 5, 2}}},
+	}
+	for _, tt := range tests {
+		if got := renderSpans(tt.text, tt.spans, lipgloss.NewStyle()); got != tt.text {
+			t.Errorf("renderSpans(%q, %v) = %q", tt.text, tt.spans, got)
+		}
+	}
+}
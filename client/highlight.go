@@ -0,0 +1,312 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the syntactic class of a piece of code, which decides its
+// color.
+type tokenKind int
+
+const (
+	tokenPlain tokenKind = iota
+	tokenKeyword
+	tokenType // builtin types, functions and constants
+	tokenString
+	tokenNumber
+	tokenComment
+)
+
+// token is a run of code of a single kind.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// language describes just enough of a programming language's syntax to
+// color it: its words, comments and string quotes.
+type language struct {
+	keywords      map[string]bool
+	types         map[string]bool
+	caseFold      bool // keywords match in any case, as in SQL
+	lineComments  []string
+	blockComment  [2]string // opening and closing marker, empty if none
+	quotes        string    // characters that delimit strings
+	rawQuotes     string    // quotes whose strings have no escapes and may span lines
+	noEscapeQuote bool      // backslash does not escape inside strings
+}
+
+// words turns a space-separated list into a set.
+func words(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(list) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cLike = &language{
+		keywords:     words("auto break case catch class const continue default delete do else enum extends extern final finally for goto if implements import inline interface namespace new package private protected public return sizeof static struct switch template this throw throws try typedef union using virtual volatile while"),
+		types:        words("bool boolean byte char double float int long short signed unsigned void String Object true false null nullptr NULL"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+
+	// languages maps the language tag of a code snippet to its syntax.
+	// Snippets in other languages are shown without colors.
+	languages = map[string]*language{
+		"go": {
+			keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+			types:        words("any bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr true false nil iota append cap clear close copy delete len make max min new panic print println recover"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       "\"'`",
+			rawQuotes:    "`",
+		},
+		"python": {
+			keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield"),
+			types:        words("True False None self bool bytes dict float int list object set str tuple len print range isinstance super"),
+			lineComments: []string{"#"},
+			quotes:       `"'`,
+		},
+		"javascript": {
+			keywords:     words("async await break case catch class const continue debugger default delete do else enum export extends finally for from function if implements import in instanceof interface let new of return static super switch this throw try type typeof var void while with yield"),
+			types:        words("true false null undefined NaN Infinity any boolean never number object string unknown Array Object Promise console"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       "\"'`",
+			rawQuotes:    "`",
+		},
+		"rust": {
+			keywords:     words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+			types:        words("bool char str String Vec Option Result Box Some None Ok Err true false i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       `"`,
+		},
+		"c": cLike,
+		"shell": {
+			keywords:     words("if then else elif fi case esac for while until do done in function return local export select"),
+			types:        words("echo cd ls cat grep sed awk printf read set unset exit source test true false"),
+			lineComments: []string{"#"},
+			quotes:       `"'`,
+		},
+		"sql": {
+			keywords:     words("select from where and or not insert into values update set delete create table drop alter add column index view join left right inner outer full cross on group by order having limit offset as distinct is in like between union all exists case when then else end primary key foreign references default unique"),
+			types:        words("null true false int integer bigint smallint text varchar char boolean date timestamp numeric real serial count sum avg min max"),
+			caseFold:     true,
+			lineComments: []string{"--"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       `'"`,
+		},
+		"json": {
+			types:  words("true false null"),
+			quotes: `"`,
+		},
+		"yaml": {
+			types:         words("true false null yes no on off"),
+			lineComments:  []string{"#"},
+			quotes:        `"'`,
+			noEscapeQuote: true,
+		},
+	}
+
+	// languageAliases maps other common tags to the names used in languages.
+	languageAliases = map[string]string{
+		"golang":     "go",
+		"py":         "python",
+		"js":         "javascript",
+		"jsx":        "javascript",
+		"ts":         "javascript",
+		"tsx":        "javascript",
+		"node":       "javascript",
+		"typescript": "javascript",
+		"rs":         "rust",
+		"h":          "c",
+		"cpp":        "c",
+		"c++":        "c",
+		"cc":         "c",
+		"hpp":        "c",
+		"java":       "c",
+		"cs":         "c",
+		"csharp":     "c",
+		"sh":         "shell",
+		"bash":       "shell",
+		"zsh":        "shell",
+		"yml":        "yaml",
+	}
+)
+
+// languageFor returns the syntax for a snippet's language tag, or nil.
+func languageFor(tag string) *language {
+	tag = strings.ToLower(tag)
+	if alias, ok := languageAliases[tag]; ok {
+		tag = alias
+	}
+	return languages[tag]
+}
+
+// highlight splits each line of code into tokens. Block comments and raw
+// strings left open at the end of a line carry over to the next one. A nil
+// language yields a single plain token per line.
+func (lang *language) highlight(lines []string) [][]token {
+	out := make([][]token, len(lines))
+	if lang == nil {
+		for n, line := range lines {
+			out[n] = []token{{kind: tokenPlain, text: line}}
+		}
+		return out
+	}
+
+	var (
+		inComment bool
+		openQuote rune // quote of a raw string continued from an earlier line
+	)
+	for n, line := range lines {
+		rs := []rune(line)
+		var toks []token
+		emit := func(kind tokenKind, from, to int) {
+			if from >= to {
+				return
+			}
+			text := string(rs[from:to])
+			if last := len(toks) - 1; last >= 0 && toks[last].kind == kind {
+				toks[last].text += text
+				return
+			}
+			toks = append(toks, token{kind: kind, text: text})
+		}
+
+		for i := 0; i < len(rs); {
+			switch {
+			case inComment:
+				end := indexFrom(rs, i, lang.blockComment[1])
+				if end < 0 {
+					emit(tokenComment, i, len(rs))
+					i = len(rs)
+					break
+				}
+				end += len([]rune(lang.blockComment[1]))
+				emit(tokenComment, i, end)
+				inComment = false
+				i = end
+			case openQuote != 0:
+				end := lang.stringEnd(rs, i, openQuote)
+				if end < 0 {
+					emit(tokenString, i, len(rs))
+					i = len(rs)
+					break
+				}
+				emit(tokenString, i, end)
+				openQuote = 0
+				i = end
+			case lang.startsLineComment(rs, i):
+				emit(tokenComment, i, len(rs))
+				i = len(rs)
+			case lang.blockComment[0] != "" && hasPrefixAt(rs, i, lang.blockComment[0]):
+				inComment = true
+				emit(tokenComment, i, i+len([]rune(lang.blockComment[0])))
+				i += len([]rune(lang.blockComment[0]))
+			case strings.ContainsRune(lang.quotes, rs[i]):
+				end := lang.stringEnd(rs, i+1, rs[i])
+				if end < 0 {
+					if strings.ContainsRune(lang.rawQuotes, rs[i]) {
+						openQuote = rs[i]
+					}
+					emit(tokenString, i, len(rs))
+					i = len(rs)
+					break
+				}
+				emit(tokenString, i, end)
+				i = end
+			case unicode.IsDigit(rs[i]):
+				end := i + 1
+				for end < len(rs) && (isWordRune(rs[end]) || rs[end] == '.') {
+					end++
+				}
+				emit(tokenNumber, i, end)
+				i = end
+			case isWordRune(rs[i]):
+				end := i + 1
+				for end < len(rs) && isWordRune(rs[end]) {
+					end++
+				}
+				emit(lang.classify(string(rs[i:end])), i, end)
+				i = end
+			default:
+				emit(tokenPlain, i, i+1)
+				i++
+			}
+		}
+		out[n] = toks
+	}
+	return out
+}
+
+// classify returns the kind of an identifier.
+func (lang *language) classify(word string) tokenKind {
+	if lang.caseFold {
+		word = strings.ToLower(word)
+	}
+	switch {
+	case lang.keywords[word]:
+		return tokenKeyword
+	case lang.types[word]:
+		return tokenType
+	}
+	return tokenPlain
+}
+
+// startsLineComment reports whether a line comment starts at rs[i].
+func (lang *language) startsLineComment(rs []rune, i int) bool {
+	for _, marker := range lang.lineComments {
+		if hasPrefixAt(rs, i, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringEnd returns the index just past the quote closing a string that
+// continues at rs[from], or -1 if the string does not end on this line.
+func (lang *language) stringEnd(rs []rune, from int, quote rune) int {
+	escapes := !lang.noEscapeQuote && !strings.ContainsRune(lang.rawQuotes, quote)
+	for i := from; i < len(rs); i++ {
+		switch {
+		case escapes && rs[i] == '\\':
+			i++
+		case rs[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// hasPrefixAt reports whether rs continues with prefix at index i.
+func hasPrefixAt(rs []rune, i int, prefix string) bool {
+	for _, r := range prefix {
+		if i >= len(rs) || rs[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// indexFrom returns the index in rs of the first occurrence of s at or after
+// from, or -1.
+func indexFrom(rs []rune, from int, s string) int {
+	for i := from; i < len(rs); i++ {
+		if hasPrefixAt(rs, i, s) {
+			return i
+		}
+	}
+	return -1
+}
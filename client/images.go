@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// maxPreviewBytes is the largest image fetched to show a preview. Bigger
+	// ones can still be opened with Alt+O.
+	maxPreviewBytes = 2 << 20
+	// maxPreviewPixels guards against images that are small files but huge
+	// once decoded.
+	maxPreviewPixels = 40_000_000
+
+	// A preview fits in previewCols x previewRows cells.
+	previewCols   = 32
+	previewRows   = 12
+	previewIndent = "  "
+
+	// cellWidth and cellHeight approximate the pixel size of a terminal
+	// cell, for the protocols that draw in pixels.
+	cellWidth  = 10
+	cellHeight = 20
+
+	// kittyPlaceholder stands for a cell of an image in kitty's Unicode
+	// placeholder mode, so the image moves with the text around it.
+	kittyPlaceholder = '\U0010EEEE'
+)
+
+// graphicsMode is how the terminal can draw image previews.
+type graphicsMode int
+
+const (
+	graphicsBlocks graphicsMode = iota // colored half blocks, which any color terminal shows
+	graphicsKitty                      // the kitty graphics protocol
+	graphicsSixel                      // DEC sixel graphics
+	graphicsOff                        // no previews
+)
+
+// kittyDiacritics encode the row of a placeholder cell, from kitty's
+// rowcolumn-diacritics list. They cover previewRows.
+var kittyDiacritics = []rune{
+	0x0305, 0x030D, 0x030E, 0x0310, 0x0312, 0x033D, 0x033E, 0x033F,
+	0x0346, 0x034A, 0x034B, 0x034C, 0x0350, 0x0351, 0x0352, 0x0357,
+}
+
+// preview is an image shown under its file card.
+type preview struct {
+	data     []byte // the full image, for Alt+O
+	cols     int
+	rows     int
+	rendered string // the rows shown in the message list
+	sixel    string // sixel drawing placed over rendered, in sixel mode
+	kitty    string // image data the placeholders in rendered show, in kitty mode
+}
+
+// previewSpot is where a sixel or kitty preview sits in the message list.
+type previewSpot struct {
+	line   int // first line of the preview in the viewport content
+	indent int // columns before the preview
+	p      *preview
+}
+
+// detectGraphics guesses how the terminal can draw images from the
+// environment. CHAT_IMAGES=kitty|sixel|blocks|off overrides the guess.
+func detectGraphics() graphicsMode {
+	switch strings.ToLower(os.Getenv("CHAT_IMAGES")) {
+	case "kitty":
+		return graphicsKitty
+	case "sixel":
+		return graphicsSixel
+	case "blocks":
+		return graphicsBlocks
+	case "off":
+		return graphicsOff
+	}
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty", term == "xterm-ghostty", program == "ghostty":
+		return graphicsKitty
+	case strings.Contains(term, "sixel"), strings.HasPrefix(term, "foot"), term == "mlterm", program == "WezTerm", program == "iTerm.app":
+		return graphicsSixel
+	}
+	return graphicsBlocks
+}
+
+// isImage reports whether file looks like an image we can decode.
+func isImage(file *FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(file.Name)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// fetchPreviews asks the server for the images on the file cards in ev, or
+// in the history it carries, that are small enough to preview.
+func (m *model) fetchPreviews(ev Event) {
+	if m.graphics == graphicsOff {
+		return
+	}
+	for _, e := range append([]Event{ev}, ev.History...) {
+		file := e.File
+		if file == nil || file.ID == "" || e.Deleted || !isImage(file) || file.Size > maxPreviewBytes {
+			continue
+		}
+		if _, seen := m.previews[file.ID]; seen {
+			continue
+		}
+		if _, pending := m.downloads[file.ID]; pending {
+			continue
+		}
+		m.fetch(file.ID, downloadRequest{preview: true})
+	}
+}
+
+// addPreview decodes a fetched image and shows it under its card. Images
+// that cannot be decoded are remembered so they are not fetched again.
+func (m *model) addPreview(info FileInfo, data []byte) {
+	m.nextImageID++
+	p, err := newPreview(data, m.graphics, m.nextImageID)
+	if err != nil {
+		log.Printf("No preview for %s: %v", info.Name, err)
+	}
+	m.previews[info.ID] = p
+	m.refreshViewport()
+}
+
+// previewOf returns the preview shown under ev's file card, or nil.
+func (m model) previewOf(ev Event) *preview {
+	if ev.File == nil || ev.Deleted {
+		return nil
+	}
+	return m.previews[ev.File.ID]
+}
+
+// newPreview decodes data and renders it for the given graphics mode. In
+// kitty mode the image is sent to the terminal as image id by drawPreviews.
+func newPreview(data []byte, mode graphicsMode, id uint32) (*preview, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPreviewPixels {
+		return nil, errors.New("image too large")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Cells are about twice as tall as they are wide, so a cell holds one
+	// column and two rows of a square-pixel grid.
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := min(float64(previewCols)/float64(w), float64(previewRows*2)/float64(h), 1)
+	p := &preview{
+		data: data,
+		cols: max(int(float64(w)*scale+0.5), 1),
+		rows: max(int(float64(h)*scale/2+0.5), 1),
+	}
+
+	switch mode {
+	case graphicsKitty:
+		thumb := scaleImage(img, p.cols*cellWidth, p.rows*cellHeight)
+		p.kitty = kittyTransmit(thumb, id, p.cols, p.rows)
+		p.rendered = kittyPlaceholders(id, p.cols, p.rows)
+	case graphicsSixel:
+		p.sixel = sixelImage(scaleImage(img, p.cols*cellWidth, p.rows*cellHeight))
+		blank := previewIndent + strings.Repeat(" ", p.cols)
+		p.rendered = strings.TrimSuffix(strings.Repeat(blank+"\n", p.rows), "\n")
+	default:
+		p.rendered = renderBlocks(scaleImage(img, p.cols, p.rows*2))
+	}
+	return p, nil
+}
+
+// scaleImage resizes src to w x h by averaging the pixels each destination
+// pixel covers, sampling at most 4x4 of them.
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		stepY := max((y1-y0)/4, 1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+			stepX := max((x1-x0)/4, 1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+cr, g+cg, bl+cb, a+ca, n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// renderBlocks draws img with "▀" characters, each showing two pixels: the
+// upper one in the foreground color and the lower one in the background.
+func renderBlocks(img *image.RGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	rows := make([]string, 0, (h+1)/2)
+	for y := 0; y < h; y += 2 {
+		var b strings.Builder
+		b.WriteString(previewIndent)
+		for x := 0; x < w; x++ {
+			style := lipgloss.NewStyle().Foreground(hexColor(img.RGBAAt(x, y))).Background(hexColor(img.RGBAAt(x, y+1)))
+			b.WriteString(style.Render("▀"))
+		}
+		rows = append(rows, b.String())
+	}
+	return strings.Join(rows, "\n")
+}
+
+// hexColor converts a pixel, blended over black, to a lipgloss color.
+func hexColor(c color.RGBA) lipgloss.Color {
+	return lipgloss.Color(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+}
+
+// kittyTransmit returns the escape sequences that upload img to the terminal
+// as image id, shown wherever its placeholders cover cols x rows cells.
+func kittyTransmit(img image.Image, id uint32, cols, rows int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Printf("Error encoding preview: %v", err)
+		return ""
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	// The payload goes in chunks of at most 4096 bytes; m=1 means more follow
+	var b strings.Builder
+	for first := true; first || payload != ""; first = false {
+		chunk := payload[:min(len(payload), 4096)]
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,q=2,U=1,i=%d,c=%d,r=%d,m=%d;%s\x1b\\", id, cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String()
+}
+
+// kittyPlaceholders returns the rows of placeholder cells that show image
+// id. The foreground color carries the image ID, and the first cell of each
+// row its row and column; the cells after it continue the row.
+func kittyPlaceholders(id uint32, cols, rows int) string {
+	fg := fmt.Sprintf("\x1b[38;2;%d;%d;%dm", id>>16&0xff, id>>8&0xff, id&0xff)
+	lines := make([]string, rows)
+	for row := range lines {
+		lines[row] = previewIndent + fg +
+			string([]rune{kittyPlaceholder, kittyDiacritics[row], kittyDiacritics[0]}) +
+			strings.Repeat(string(kittyPlaceholder), cols-1) + "\x1b[39m"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sixelImage encodes img as sixel graphics, with its colors reduced to a
+// 6x6x6 cube. Mostly transparent pixels are left undrawn.
+func sixelImage(img *image.RGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	index := make([]int, w*h)
+	var used [216]bool
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A < 128 {
+				index[y*w+x] = -1
+				continue
+			}
+			i := int(c.R/43)*36 + int(c.G/43)*6 + int(c.B/43)
+			index[y*w+x] = i
+			used[i] = true
+		}
+	}
+
+	var b strings.Builder
+	// P2=1 keeps undrawn pixels transparent; the raster attributes give the
+	// pixel aspect ratio and size
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", w, h)
+	for i, ok := range used {
+		if ok {
+			fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+		}
+	}
+	row := make([]byte, w)
+	for band := 0; band < h; band += 6 {
+		first := true
+		for i, ok := range used {
+			if !ok {
+				continue
+			}
+			drawn := false
+			for x := 0; x < w; x++ {
+				bits := 0
+				for k := 0; k < 6 && band+k < h; k++ {
+					if index[(band+k)*w+x] == i {
+						bits |= 1 << k
+					}
+				}
+				drawn = drawn || bits != 0
+				row[x] = byte('?' + bits)
+			}
+			if !drawn {
+				continue
+			}
+			if !first {
+				b.WriteByte('$') // back to the start of the band for the next color
+			}
+			first = false
+			fmt.Fprintf(&b, "#%d", i)
+			writeSixelRuns(&b, bytes.TrimRight(row, "?"))
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRuns writes a row of sixels, run-length encoding repeats.
+func writeSixelRuns(b *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		n := 1
+		for i+n < len(row) && row[i+n] == row[i] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(b, "!%d%c", n, row[i])
+		} else {
+			b.Write(row[i : i+n])
+		}
+		i += n
+	}
+}
+
+// drawPreviews adds the previews in view to the rendered viewport. A sixel
+// preview is drawn once fully in view, from its last row, after the blank rows
+// it covers have been painted, and the cursor is put back where it was. A
+// kitty preview sends its image ahead of its first row in view; the
+// terminal draws it in the placeholder cells, and replaces it when a redraw
+// of the row sends it again.
+func (m model) drawPreviews(view string) string {
+	lines := strings.Split(view, "\n")
+	style := m.viewport.Style
+	top := style.GetBorderTopSize() + style.GetPaddingTop()
+	left := style.GetBorderLeftSize() + style.GetPaddingLeft()
+	height := m.viewport.Height - style.GetVerticalFrameSize()
+	for _, spot := range m.previewSpots {
+		first := spot.line - m.viewport.YOffset
+		last := first + spot.p.rows - 1
+		if spot.p.kitty != "" {
+			if row := max(first, 0); row <= last && row < height && top+row < len(lines) {
+				lines[top+row] = spot.p.kitty + lines[top+row]
+			}
+			continue
+		}
+		if first < 0 || last >= height || top+last >= len(lines) {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString("\x1b7\r") // save the cursor
+		if spot.p.rows > 1 {
+			fmt.Fprintf(&b, "\x1b[%dA", spot.p.rows-1)
+		}
+		fmt.Fprintf(&b, "\x1b[%dC", left+spot.indent+len(previewIndent))
+		b.WriteString(spot.p.sixel)
+		b.WriteString("\x1b8") // restore it
+		lines[top+last] += b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// openImage opens the image of the selected message, or the newest one shown,
+// in the system's viewer. Images we have a preview of are written to a
+// temporary file; others are downloaded to one first.
+func (m *model) openImage() {
+	var file *FileInfo
+	if line, ok := m.selectedLine(); ok && line.event.File != nil && isImage(line.event.File) {
+		file = line.event.File
+	} else {
+		lines := m.visibleLines()
+		for i := len(lines) - 1; i >= 0 && file == nil; i-- {
+			if f := lines[i].event.File; f != nil && f.ID != "" && !lines[i].event.Deleted && isImage(f) {
+				file = f
+			}
+		}
+	}
+	if file == nil {
+		return
+	}
+
+	p := m.previews[file.ID]
+	if p == nil {
+		m.fetch(file.ID, downloadRequest{open: true})
+		return
+	}
+	f, err := os.CreateTemp("", "chat-*"+filepath.Ext(file.Name))
+	if err == nil {
+		_, err = f.Write(p.data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Cannot open %s: %v", file.Name, err)}})
+		return
+	}
+	m.openPath(f.Name())
+}
+
+// openPath opens a file in the program the system uses for it.
+func (m *model) openPath(path string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: fmt.Sprintf("Saved %s but could not open it: %v", path, err)}})
+		return
+	}
+	go cmd.Wait()
+	m.appendLine(chatLine{event: Event{Type: eventSystem, Text: "Opened " + path}})
+}
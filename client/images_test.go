@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestIsImage(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cat.png", true},
+		{"CAT.JPG", true},
+		{"anim.gif", true},
+		{"photo.jpeg", true},
+		{"notes.txt", false},
+		{"png", false},
+	}
+	for _, tt := range tests {
+		if got := isImage(&FileInfo{Name: tt.name}); got != tt.want {
+			t.Errorf("isImage(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewPreview(t *testing.T) {
+	encode := func(w, h int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		img.Set(0, 0, color.RGBA{R: 255, A: 255})
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		mode     graphicsMode
+		wantCols int
+		wantRows int
+		wantErr  bool
+	}{
+		{"small image kept at size", encode(8, 8), graphicsBlocks, 8, 4, false},
+		{"wide image fits columns", encode(320, 40), graphicsBlocks, previewCols, 2, false},
+		{"tall image fits rows", encode(10, 240), graphicsBlocks, 1, previewRows, false},
+		{"kitty placeholders", encode(8, 8), graphicsKitty, 8, 4, false},
+		{"sixel", encode(8, 8), graphicsSixel, 8, 4, false},
+		{"not an image", []byte("hello"), graphicsBlocks, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPreview(tt.data, tt.mode, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPreview error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if p.cols != tt.wantCols || p.rows != tt.wantRows {
+				t.Errorf("size = %dx%d, want %dx%d", p.cols, p.rows, tt.wantCols, tt.wantRows)
+			}
+			if lines := strings.Count(p.rendered, "\n") + 1; lines != p.rows {
+				t.Errorf("rendered %d lines, want %d", lines, p.rows)
+			}
+			if (p.kitty != "") != (tt.mode == graphicsKitty) || (p.sixel != "") != (tt.mode == graphicsSixel) {
+				t.Errorf("kitty %d bytes, sixel %d bytes in mode %d", len(p.kitty), len(p.sixel), tt.mode)
+			}
+		})
+	}
+}
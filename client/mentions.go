@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	mentionStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("#1E1E2E")).Background(lipgloss.Color("#FFCB6B"))
+	mentionCounterStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFCB6B")).Bold(true)
+)
+
+// mentionsMe reports whether ev mentions us and was written by someone else.
+func (m *model) mentionsMe(ev Event) bool {
+	return ev.From != m.username && slices.Contains(ev.Mentions, m.username)
+}
+
+// bellDuration is how long the bell stays in the view: long enough for the
+// program to render a frame with it.
+const bellDuration = 100 * time.Millisecond
+
+// bellDoneMsg takes the bell out of the view again.
+type bellDoneMsg struct{}
+
+// notifyMention counts an unread mention and rings the terminal bell. The
+// bell is part of the view, like everything else we write to the terminal,
+// so it never lands in the middle of a frame.
+func (m *model) notifyMention() tea.Cmd {
+	m.unreadMentions++
+	m.bell = true
+	return tea.Tick(bellDuration, func(time.Time) tea.Msg { return bellDoneMsg{} })
+}
+
+// bellString rings the bell while a mention has just arrived. The renderer
+// only redraws lines that changed, so it rings once.
+func (m model) bellString() string {
+	if m.bell {
+		return "\a"
+	}
+	return ""
+}
+
+// mentionCounter is the status line suffix for unread mentions.
+func (m model) mentionCounter() string {
+	switch m.unreadMentions {
+	case 0:
+		return ""
+	case 1:
+		return mentionCounterStyle.Render(" · 1 unread mention")
+	default:
+		return mentionCounterStyle.Render(fmt.Sprintf(" · %d unread mentions", m.unreadMentions))
+	}
+}
+
+// renderMentions draws the inbox returned by /mentions.
+func renderMentions(inbox []Event) string {
+	if len(inbox) == 0 {
+		return serverMsgStyle.Render("[Server] Nobody has mentioned you yet.")
+	}
+	lines := []string{separatorStyle.Render(fmt.Sprintf("── Mentions (%d) ──", len(inbox)))}
+	for _, ev := range inbox {
+		lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Top,
+			senderStyle.Render(fmt.Sprintf("[%s] ", ev.Time.Format("01-02 15:04"))),
+			senderStyle.Render(ev.From+":"),
+			messageStyle.Render(" "+ev.Text),
+			messageIDStyle.Render(" #"+ev.ID),
+		))
+	}
+	return strings.Join(lines, "\n")
+}
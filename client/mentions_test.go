@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestMentionsMe(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   Event
+		want bool
+	}{
+		{"mentioned", Event{From: "bob", Mentions: []string{"alice"}}, true},
+		{"someone else mentioned", Event{From: "bob", Mentions: []string{"carol"}}, false},
+		{"own message", Event{From: "alice", Mentions: []string{"alice"}}, false},
+		{"no mentions", Event{From: "bob", Text: "alice"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.username = "alice"
+			if got := m.mentionsMe(tt.ev); got != tt.want {
+				t.Errorf("mentionsMe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	// connectNickID is the client ID of the /nick sent on connecting, so that
+	// its answer can be told apart from that of a /nick the user typed.
+	connectNickID = "nick"
+	// maxNickAttempts is how many alternate nicknames are tried when the one
+	// we connect with is refused before asking the user to choose one.
+	maxNickAttempts = 3
+	// maxNickLength mirrors the server's limit, in characters.
+	maxNickLength = 20
+)
+
+// applyNick handles the server's answer to /nick. The username only changes
+// once the server confirms it. If the name we connected with is refused,
+// typically because someone took it while we were away, an alternate is
+// requested instead.
+func (m *model) applyNick(ev Event) error {
+	if ev.Error == "" {
+		m.username = ev.Text
+		m.named = true
+		m.nickAttempts = 0
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: "Username set to " + ev.Text}})
+		return nil
+	}
+	if ev.ClientID != connectNickID || m.named {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: ev.Error}})
+		return nil
+	}
+
+	if m.nickAttempts >= maxNickAttempts {
+		m.appendLine(chatLine{event: Event{Type: eventError, Text: ev.Error + " Choose a nickname with /nick <username>."}})
+		return nil
+	}
+	m.nickAttempts++
+	alternate := alternateNick(m.username, m.nickAttempts)
+	m.appendLine(chatLine{event: Event{Type: eventSystem, Text: fmt.Sprintf("%s Trying '%s' instead.", ev.Error, alternate)}})
+	return m.send(connectNickID, "/nick "+alternate)
+}
+
+// alternateNick returns the nickname to try after name was refused for the
+// attempt'th time: name with a number appended, or a random one on the last
+// attempt in case name itself breaks the server's rules.
+func alternateNick(name string, attempt int) string {
+	if attempt >= maxNickAttempts {
+		return fmt.Sprintf("user-%d", rand.Intn(10000))
+	}
+	suffix := fmt.Sprintf("_%d", attempt+1)
+	runes := []rune(name)
+	if keep := maxNickLength - len(suffix); len(runes) > keep {
+		runes = runes[:keep]
+	}
+	return string(runes) + suffix
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAlternateNick(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		want    string
+	}{
+		{"alice", 1, "alice_2"},
+		{"alice", 2, "alice_3"},
+		{"abcdefghijklmnopqrst", 1, "abcdefghijklmnopqr_2"},
+		{"éééééééééééééééééééé", 1, "éééééééééééééééééé_2"},
+	}
+	for _, tt := range tests {
+		if got := alternateNick(tt.name, tt.attempt); got != tt.want {
+			t.Errorf("alternateNick(%q, %d) = %q, want %q", tt.name, tt.attempt, got, tt.want)
+		}
+	}
+
+	last := alternateNick("alice", maxNickAttempts)
+	if !strings.HasPrefix(last, "user-") || utf8.RuneCountInString(last) > maxNickLength {
+		t.Errorf("alternateNick on the last attempt = %q, want a random user- name", last)
+	}
+}
+
+// TestApplyNick covers the answers that don't lead to another /nick; those
+// that do need a connection.
+func TestApplyNick(t *testing.T) {
+	tests := []struct {
+		name         string
+		ev           Event
+		named        bool
+		attempts     int
+		wantUsername string
+		wantNamed    bool
+		wantLine     string
+	}{
+		{"accepted", Event{Type: eventNick, ClientID: connectNickID, Text: "bob"}, false, 2, "bob", true, "Username set to bob"},
+		{"typed and refused", Event{Type: eventNick, ClientID: "c1", Text: "alice", Error: "Username 'bob' is already taken."}, true, 0, "alice", true, "Username 'bob' is already taken."},
+		{"refused after connecting", Event{Type: eventNick, ClientID: connectNickID, Text: "alice", Error: "taken"}, true, 0, "alice", true, "taken"},
+		{"out of attempts", Event{Type: eventNick, ClientID: connectNickID, Error: "taken"}, false, maxNickAttempts, "alice", false, "taken Choose a nickname with /nick <username>."},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.username, m.named, m.nickAttempts = "alice", tt.named, tt.attempts
+		if err := m.applyNick(tt.ev); err != nil {
+			t.Errorf("%s: applyNick: %v", tt.name, err)
+			continue
+		}
+		if m.username != tt.wantUsername || m.named != tt.wantNamed {
+			t.Errorf("%s: username %q named %v, want %q %v", tt.name, m.username, m.named, tt.wantUsername, tt.wantNamed)
+		}
+		if len(m.messages) == 0 || m.messages[len(m.messages)-1].event.Text != tt.wantLine {
+			t.Errorf("%s: last line %v, want %q", tt.name, m.messages, tt.wantLine)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import "time"
+
+// defaultRoom is the room every client talks in.
+const defaultRoom = "lobby"
+
+// Event types written by the server. These mirror server/protocol.go.
+const (
+	eventMessage    = "message"
+	eventPM         = "pm"
+	eventSystem     = "system"
+	eventAck        = "ack"
+	eventReplay     = "replay"
+	eventEdit       = "edit"
+	eventDelete     = "delete"
+	eventReaction   = "reaction"
+	eventThread     = "thread"
+	eventReplyCount = "reply_count"
+	eventMentions   = "mentions"
+	eventSearch     = "search"
+
+	eventConversation = "conversation"
+
+	eventUpload   = "upload"
+	eventDownload = "download"
+
+	eventTyping     = "typing"
+	eventStopTyping = "stop_typing"
+
+	eventKey = "key"
+
+	eventNick = "nick"
+
+	eventRoom = "room"
+
+	// eventError and eventSeparator are never sent by the server; the client
+	// uses them for local notices kept in the same message list.
+	eventError     = "error"
+	eventSeparator = "separator"
+)
+
+// Event is the JSON envelope for every frame received from the server.
+type Event struct {
+	Type     string    `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	Seq      uint64    `json:"seq,omitempty"`
+	Room     string    `json:"room,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Auto     bool      `json:"auto,omitempty"`   // automatic reply, e.g. an away message
+	Action   bool      `json:"action,omitempty"` // sent with /me, shown as "* alice waves"
+	Edited   bool      `json:"edited,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Thread   string    `json:"thread,omitempty"`  // ID of the thread root this message replies to
+	Replies  int       `json:"replies,omitempty"` // number of replies, on thread roots
+	Time     time.Time `json:"time"`
+	History  []Event   `json:"history,omitempty"`
+	Mentions []string  `json:"mentions,omitempty"` // users mentioned as @username
+	Members  []string  `json:"members,omitempty"`  // members of a group conversation
+	Spans    []Span    `json:"spans,omitempty"`    // inline formatting of Text
+	Code     bool      `json:"code,omitempty"`     // Text is a code snippet
+	Lang     string    `json:"lang,omitempty"`     // language of a code snippet, if given
+	File     *FileInfo `json:"file,omitempty"`     // shared file, on file cards and downloads
+	Context  []Event   `json:"context,omitempty"`  // messages around a search result, oldest first
+	Key      string    `json:"key,omitempty"`      // base64 NaCl box public key: the sender's on PMs, the user's on key events
+	Sealed   string    `json:"sealed,omitempty"`   // base64 nonce and NaCl box of an end-to-end encrypted PM
+	Topic    string    `json:"topic,omitempty"`    // room topic, on room events
+	Pins     []Event   `json:"pins,omitempty"`     // pinned messages, on room events
+
+	// Reactions maps each emoji on a message to the users who reacted with it.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+}
+
+// Span applies an inline style to Text[Start:End] of a message. Offsets are
+// byte offsets.
+type Span struct {
+	Style string `json:"style"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// FileInfo describes a shared file. This mirrors server/files.go.
+type FileInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"` // hex SHA-256 of the content
+}
+
+// Command is the JSON envelope for frames sent to the server.
+type Command struct {
+	ClientID string `json:"client_id,omitempty"`
+	Text     string `json:"text"`
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// quickReaction is the emoji alt+r toggles on the selected message.
+const quickReaction = "👍"
+
+var (
+	reactionStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA")).Background(lipgloss.Color("#3A3A4A")).Padding(0, 1)
+	ownReactionStyle = reactionStyle.Background(lipgloss.Color("#5A56E0"))
+)
+
+// applyReactions replaces the reaction set of a message.
+func (m *model) applyReactions(ev Event) {
+	if line := m.findLine(ev.ID); line != nil {
+		line.event.Reactions = ev.Reactions
+		m.refreshViewport()
+	}
+}
+
+// toggleReaction asks the server to add or remove our reaction on a message.
+func (m *model) toggleReaction(id, emoji string) {
+	if m.conn == nil || !m.connected {
+		m.err = fmt.Errorf("not connected to server")
+		return
+	}
+	if err := m.send("", fmt.Sprintf("/react %s %s", id, emoji)); err != nil {
+		log.Printf("Failed to send reaction: %v", err)
+		m.err = fmt.Errorf("failed to send reaction: %v", err)
+	}
+}
+
+// renderReactions draws the badge row shown under a message, with the
+// reactions we took part in highlighted.
+func (m model) renderReactions(reactions map[string][]string) string {
+	emojis := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if len(reactions[emojis[i]]) != len(reactions[emojis[j]]) {
+			return len(reactions[emojis[i]]) > len(reactions[emojis[j]])
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	badges := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		users := reactions[emoji]
+		badge := fmt.Sprintf("%s %d", emoji, len(users))
+		if slices.Contains(users, m.username) {
+			badges = append(badges, ownReactionStyle.Render(badge))
+		} else {
+			badges = append(badges, reactionStyle.Render(badge))
+		}
+	}
+	return "  " + strings.Join(badges, " ")
+}
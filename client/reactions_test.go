@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderReactionsOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions map[string][]string
+		want      []string // emoji in display order
+	}{
+		{"most used first", map[string][]string{"🎉": {"bob"}, "👍": {"alice", "bob"}}, []string{"👍", "🎉"}},
+		{"ties by emoji", map[string][]string{"🚀": {"bob"}, "🎉": {"alice"}}, []string{"🎉", "🚀"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			got := m.renderReactions(tt.reactions)
+			last := -1
+			for _, emoji := range tt.want {
+				i := strings.Index(got, emoji)
+				if i <= last {
+					t.Fatalf("renderReactions = %q, want order %v", got, tt.want)
+				}
+				last = i
+			}
+		})
+	}
+}
+
+func TestApplyReactions(t *testing.T) {
+	m := initialModel()
+	m.messages = []chatLine{{event: Event{Type: eventMessage, ID: "m1"}}, {event: Event{Type: eventMessage, ID: "m2"}}}
+	m.applyReactions(Event{Type: eventReaction, ID: "m2", Reactions: map[string][]string{"👍": {"alice"}}})
+	if len(m.messages[0].event.Reactions) != 0 || len(m.messages[1].event.Reactions["👍"]) != 1 {
+		t.Errorf("reactions = %v, %v", m.messages[0].event.Reactions, m.messages[1].event.Reactions)
+	}
+}
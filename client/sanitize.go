@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sanitizeText removes the characters a terminal acts on instead of printing
+// them, keeping newlines and tabs. The server already strips them, but
+// private messages are sealed end to end and older history may predate that,
+// so nothing received is rendered unchecked. This mirrors
+// server/sanitize.go.
+func sanitizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, "�"))
+}
+
+// sanitizeLine is sanitizeText for text shown on one line, such as names.
+func sanitizeLine(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, "�"))
+}
+
+// sanitizeEvent cleans every piece of text in ev that may end up on screen,
+// including the events nested in it. Spans are dropped if the text changed,
+// since their offsets would no longer fit.
+func sanitizeEvent(ev *Event) {
+	if clean := sanitizeText(ev.Text); clean != ev.Text {
+		ev.Text, ev.Spans = clean, nil
+	}
+	ev.From = sanitizeLine(ev.From)
+	ev.To = sanitizeLine(ev.To)
+	ev.Room = sanitizeLine(ev.Room)
+	ev.Error = sanitizeLine(ev.Error)
+	ev.Lang = sanitizeLine(ev.Lang)
+	ev.Topic = sanitizeLine(ev.Topic)
+	for i := range ev.Mentions {
+		ev.Mentions[i] = sanitizeLine(ev.Mentions[i])
+	}
+	for i := range ev.Members {
+		ev.Members[i] = sanitizeLine(ev.Members[i])
+	}
+	if ev.File != nil {
+		file := *ev.File
+		file.Name = sanitizeLine(file.Name)
+		ev.File = &file
+	}
+	if len(ev.Reactions) > 0 {
+		reactions := make(map[string][]string, len(ev.Reactions))
+		for emoji, users := range ev.Reactions {
+			clean := make([]string, len(users))
+			for i, user := range users {
+				clean[i] = sanitizeLine(user)
+			}
+			reactions[sanitizeLine(emoji)] = clean
+		}
+		ev.Reactions = reactions
+	}
+	for i := range ev.History {
+		sanitizeEvent(&ev.History[i])
+	}
+	for i := range ev.Context {
+		sanitizeEvent(&ev.Context[i])
+	}
+	for i := range ev.Pins {
+		sanitizeEvent(&ev.Pins[i])
+	}
+}
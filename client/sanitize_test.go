@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantText string
+		wantLine string
+	}{
+		{"hello", "hello", "hello"},
+		{"a\nb\tc", "a\nb\tc", "abc"},
+		{"\x1b[31mred", "[31mred", "[31mred"},
+		{"\x1b]52;c;Zm9v\x07", "]52;c;Zm9v", "]52;c;Zm9v"},
+		{"x\ry", "xy", "xy"},
+		{"bad \x9b byte", "bad � byte", "bad � byte"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.in); got != tt.wantText {
+			t.Errorf("sanitizeText(%q) = %q, want %q", tt.in, got, tt.wantText)
+		}
+		if got := sanitizeLine(tt.in); got != tt.wantLine {
+			t.Errorf("sanitizeLine(%q) = %q, want %q", tt.in, got, tt.wantLine)
+		}
+	}
+}
+
+func TestSanitizeEvent(t *testing.T) {
+	bold := []Span{{Style: spanBold, Start: 0, End: 2}}
+	tests := []struct {
+		name      string
+		ev        Event
+		check     func(ev Event) bool
+		wantSpans bool
+	}{
+		{
+			name:      "clean text keeps spans",
+			ev:        Event{Text: "hi there", Spans: bold},
+			check:     func(ev Event) bool { return ev.Text == "hi there" },
+			wantSpans: true,
+		},
+		{
+			name:  "dirty text drops spans",
+			ev:    Event{Text: "hi\x1b there", Spans: bold},
+			check: func(ev Event) bool { return ev.Text == "hi there" },
+		},
+		{
+			name: "names",
+			ev:   Event{From: "a\x1blice", To: "b\nob", Mentions: []string{"c\x07arol"}, Members: []string{"d\rave"}},
+			check: func(ev Event) bool {
+				return ev.From == "alice" && ev.To == "bob" && ev.Mentions[0] == "carol" && ev.Members[0] == "dave"
+			},
+		},
+		{
+			name: "file name and reactions",
+			ev:   Event{File: &FileInfo{Name: "a\x1b.txt"}, Reactions: map[string][]string{"👍\x1b": {"e\x1bve"}}},
+			check: func(ev Event) bool {
+				return ev.File.Name == "a.txt" && len(ev.Reactions["👍"]) == 1 && ev.Reactions["👍"][0] == "eve"
+			},
+		},
+		{
+			name:  "nested history and context",
+			ev:    Event{History: []Event{{Text: "\x1bh"}}, Context: []Event{{Text: "\x1bc"}}},
+			check: func(ev Event) bool { return ev.History[0].Text == "h" && ev.Context[0].Text == "c" },
+		},
+	}
+	for _, tt := range tests {
+		ev := tt.ev
+		sanitizeEvent(&ev)
+		if !tt.check(ev) || (ev.Spans != nil) != tt.wantSpans {
+			t.Errorf("%s: got %+v", tt.name, ev)
+		}
+	}
+}
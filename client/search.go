@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	searchTermStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#1E1E2E")).Background(lipgloss.Color("#82AAFF"))
+	searchRoomStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#82AAFF"))
+)
+
+// searchResults is the overlay opened by the results of /search.
+type searchResults struct {
+	query    string
+	terms    []string // words of the query, highlighted in the results
+	results  []Event
+	selected int
+}
+
+// searchFilters are the query prefixes that narrow a search rather than
+// name words to look for. This mirrors server/search.go.
+var searchFilters = []string{"in", "from", "before", "after"}
+
+// searchTerms returns the lower-case words of a query, leaving out filters.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if key, _, ok := strings.Cut(word, ":"); ok && slices.Contains(searchFilters, key) {
+			continue
+		}
+		terms = append(terms, strings.FieldsFunc(strings.ToLower(word), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})...)
+	}
+	return terms
+}
+
+// applySearch opens the overlay with the results of a search.
+func (m *model) applySearch(ev Event) {
+	if len(ev.History) == 0 {
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Text: fmt.Sprintf("No messages match '%s'.", ev.Text), Time: ev.Time}})
+		return
+	}
+	m.search = &searchResults{query: ev.Text, terms: searchTerms(ev.Text), results: ev.History}
+	m.refreshViewport()
+}
+
+// handleSearchKey lets the arrows pick a result while the overlay is open,
+// Enter jump to it and Esc close the overlay. Enter still sends a typed
+// line, e.g. a refined /search. It reports whether key was consumed.
+func (m *model) handleSearchKey(key tea.KeyMsg) bool {
+	switch key.Type {
+	case tea.KeyEsc:
+		m.closeSearch()
+	case tea.KeyUp:
+		m.moveSearch(-1)
+	case tea.KeyDown:
+		m.moveSearch(1)
+	case tea.KeyEnter:
+		if strings.TrimSpace(m.textarea.Value()) != "" {
+			return false
+		}
+		m.jumpToResult()
+	default:
+		return false
+	}
+	return true
+}
+
+// moveSearch moves the overlay's selection by delta results.
+func (m *model) moveSearch(delta int) {
+	m.search.selected = max(0, min(len(m.search.results)-1, m.search.selected+delta))
+	m.refreshViewport()
+}
+
+// closeSearch goes back to the messages.
+func (m *model) closeSearch() {
+	m.search = nil
+	m.refreshViewport()
+}
+
+// jumpToResult closes the overlay and selects the chosen message in its tab,
+// or in its thread for a reply. Messages we have not seen are merged into the
+// scrollback first, together with their context.
+func (m *model) jumpToResult() {
+	hit := m.search.results[m.search.selected]
+	m.search = nil
+	m.mergeResult(hit)
+
+	m.activeRoom = roomOf(chatLine{event: hit})
+	delete(m.unread, m.activeRoom)
+	if hit.Thread != "" {
+		m.openThread(chatLine{event: hit})
+	} else {
+		m.threadID = ""
+	}
+	m.selectedID = hit.ID
+	m.refreshViewport()
+}
+
+// mergeResult adds a search result and its context to the scrollback, in
+// sequence order among the lines of its room. Lines already there are kept.
+func (m *model) mergeResult(hit Event) {
+	events := append(slices.Clone(hit.Context), hit)
+	slices.SortFunc(events, func(a, b Event) int { return cmp.Compare(a.Seq, b.Seq) })
+	for _, ev := range events {
+		ev.Context = nil
+		if m.findLine(ev.ID) != nil {
+			continue
+		}
+		line := chatLine{event: ev, mine: ev.From == m.username}
+		if ev.Thread != "" {
+			m.addReply(line)
+			continue
+		}
+		at := len(m.messages)
+		for i, l := range m.messages {
+			if l.event.Room == ev.Room && l.event.Seq > ev.Seq {
+				at = i
+				break
+			}
+		}
+		m.messages = slices.Insert(m.messages, at, line)
+	}
+}
+
+// showSearch renders the overlay into the viewport in place of the messages.
+func (m *model) showSearch() {
+	m.previewSpots = m.previewSpots[:0]
+	rendered := make([]string, len(m.search.results))
+	for i, hit := range m.search.results {
+		marker := strings.Repeat(" ", lipgloss.Width(selectedMarker))
+		if i == m.search.selected {
+			marker = selectedMarkerStyle.Render(selectedMarker)
+		}
+		// A blank line keeps the results apart
+		rendered[i] = lipgloss.JoinHorizontal(lipgloss.Top, marker, m.renderResult(hit)) + "\n"
+	}
+	m.viewport.SetContent(strings.Join(rendered, "\n"))
+	m.viewport.GotoTop()
+	m.scrollToLine(rendered, m.search.selected)
+}
+
+// renderResult draws a search result under the name of its room, between the
+// messages around it.
+func (m *model) renderResult(hit Event) string {
+	title := "#" + hit.Room
+	if isConversation(hit.Room) {
+		title = m.conversationTitle(hit.Room, m.conversations[hit.Room])
+	}
+	if hit.Thread != "" {
+		title += " · in a thread"
+	}
+	lines := []string{searchRoomStyle.Render(title)}
+	for _, ev := range hit.Context {
+		if ev.Seq < hit.Seq {
+			lines = append(lines, renderResultLine(ev, pendingStyle, nil))
+		}
+	}
+	lines = append(lines, renderResultLine(hit, messageStyle, m.search.terms))
+	for _, ev := range hit.Context {
+		if ev.Seq > hit.Seq {
+			lines = append(lines, renderResultLine(ev, pendingStyle, nil))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderResultLine draws one message of a search result on a single line,
+// highlighting terms.
+func renderResultLine(ev Event, base lipgloss.Style, terms []string) string {
+	text := strings.Join(strings.Fields(ev.Text), " ")
+	switch {
+	case ev.File != nil:
+		text = "📎 " + ev.File.Name
+	case ev.Code:
+		text, _, _ = strings.Cut(ev.Text, "\n")
+		text += " …"
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		senderStyle.Render(fmt.Sprintf("[%s] ", ev.Time.Format("01-02 15:04"))),
+		senderStyle.Render(ev.From+":"),
+		" "+highlightTerms(text, terms, base),
+	)
+}
+
+// highlightTerms renders text in base with every occurrence of terms, which
+// are lower case, picked out.
+func highlightTerms(text string, terms []string, base lipgloss.Style) string {
+	lower := strings.ToLower(text)
+	if len(terms) == 0 || len(lower) != len(text) {
+		// Offsets in lower would not line up with text
+		return base.Render(text)
+	}
+	var b strings.Builder
+	plain := 0
+	for i := 0; i < len(text); {
+		end := i
+		for _, term := range terms {
+			if strings.HasPrefix(lower[i:], term) {
+				end = max(end, i+len(term))
+			}
+		}
+		if end == i {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+		if plain < i {
+			b.WriteString(base.Render(text[plain:i]))
+		}
+		b.WriteString(searchTermStyle.Render(text[i:end]))
+		i, plain = end, end
+	}
+	if plain < len(text) {
+		b.WriteString(base.Render(text[plain:]))
+	}
+	return b.String()
+}
+
+// searchHeader is the title shown above the viewport while the overlay is
+// open.
+func (m *model) searchHeader() string {
+	count := fmt.Sprintf("%d results", len(m.search.results))
+	if len(m.search.results) == 1 {
+		count = "1 result"
+	}
+	return threadHeaderStyle.Render(fmt.Sprintf("Search · %s · %s (↑/↓ to choose, Enter to jump, Esc to close)", m.search.query, count))
+}
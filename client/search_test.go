@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"Deploy failed", []string{"deploy", "failed"}},
+		{"deploy in:#lobby from:alice", []string{"deploy"}},
+		{"before:2026-01-01 after:2025-12-01", nil},
+		{"re:deploy", []string{"re", "deploy"}},
+		{"it's-done", []string{"it", "s", "done"}},
+	}
+	for _, tt := range tests {
+		if got := searchTerms(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightTermsKeepsText(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+	}{
+		{"Deploy is done", []string{"deploy"}},
+		{"deploy deployment", []string{"deploy", "deployment"}},
+		{"nothing here", []string{"deploy"}},
+		{"Größe matters", []string{"größe"}},
+	}
+	for _, tt := range tests {
+		if got := highlightTerms(tt.text, tt.terms, lipgloss.NewStyle()); got != tt.text {
+			t.Errorf("highlightTerms(%q) = %q", tt.text, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// selectedMarker points at the selected message.
+const selectedMarker = "▶ "
+
+var (
+	selectedMarkerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#82AAFF")).Bold(true)
+	messageIDStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+)
+
+// handleShortcut runs the message shortcuts that work while typing. It
+// reports whether key was consumed.
+func (m *model) handleShortcut(key tea.KeyMsg) bool {
+	switch key.String() {
+	case "shift+left":
+		m.scrollCode(-codeScrollStep)
+	case "shift+right":
+		m.scrollCode(codeScrollStep)
+	case "alt+left":
+		m.switchTab(-1)
+	case "alt+right":
+		m.switchTab(1)
+	case "alt+up":
+		m.moveSelection(-1)
+	case "alt+down":
+		m.moveSelection(1)
+	case "up":
+		// Up in an empty input edits our last message, as in most chat apps;
+		// otherwise it keeps moving the cursor and scrolling
+		if strings.TrimSpace(m.textarea.Value()) != "" {
+			return false
+		}
+		line, ok := m.lastOwnMessage()
+		if !ok {
+			return false
+		}
+		m.prefill(fmt.Sprintf("/edit %s %s", line.event.ID, withMarkup(line.event)))
+	case "alt+e":
+		line, ok := m.selectedLine()
+		if !ok {
+			line, ok = m.lastOwnMessage()
+		}
+		if ok {
+			m.prefill(fmt.Sprintf("/edit %s %s", line.event.ID, withMarkup(line.event)))
+		}
+	case "alt+r":
+		if line, ok := m.selectedLine(); ok {
+			m.toggleReaction(line.event.ID, quickReaction)
+		}
+	case "alt+R":
+		if line, ok := m.selectedLine(); ok {
+			m.prefill(fmt.Sprintf("/react %s ", line.event.ID))
+		}
+	case "alt+t":
+		if line, ok := m.selectedLine(); ok && m.threadID == "" {
+			m.openThread(line)
+		}
+	case "alt+x":
+		if line, ok := m.selectedLine(); ok {
+			m.prefill("/delete " + line.event.ID)
+		}
+	case "alt+o":
+		m.openImage()
+	default:
+		return false
+	}
+	return true
+}
+
+// prefill replaces the input with text, ready to be reviewed and sent.
+func (m *model) prefill(text string) {
+	m.textarea.Reset()
+	m.textarea.CharLimit = charLimitFor(text)
+	m.textarea.InsertString(text)
+}
+
+// selectable reports whether line is a stored room message that shortcuts
+// can act on.
+func selectable(line chatLine) bool {
+	return line.event.Type == eventMessage && line.event.ID != "" && !line.event.Deleted
+}
+
+// moveSelection moves the selection by delta selectable lines. Moving past
+// the newest message clears the selection.
+func (m *model) moveSelection(delta int) {
+	lines := m.visibleLines()
+	var positions []int
+	current := -1
+	for i, line := range lines {
+		if !selectable(line) {
+			continue
+		}
+		if line.event.ID == m.selectedID {
+			current = len(positions)
+		}
+		positions = append(positions, i)
+	}
+	if len(positions) == 0 {
+		return
+	}
+	if current < 0 {
+		current = len(positions)
+	}
+
+	next := current + delta
+	if next < 0 {
+		next = 0
+	}
+	if next >= len(positions) {
+		m.selectedID = ""
+	} else {
+		m.selectedID = lines[positions[next]].event.ID
+	}
+	m.refreshViewport()
+}
+
+// selectedLine returns the currently selected message.
+func (m *model) selectedLine() (chatLine, bool) {
+	if line := m.findLine(m.selectedID); line != nil && selectable(*line) {
+		return *line, true
+	}
+	return chatLine{}, false
+}
+
+// lastOwnMessage returns the newest confirmed message we sent among the
+// visible lines.
+func (m *model) lastOwnMessage() (chatLine, bool) {
+	lines := m.visibleLines()
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := lines[i]
+		if selectable(line) && line.status == statusConfirmed && (line.mine || line.event.From == m.username) {
+			return line, true
+		}
+	}
+	return chatLine{}, false
+}
+
+// decorateSelected marks the rendered selected line and shows its ID.
+func decorateSelected(rendered string, line chatLine) string {
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		selectedMarkerStyle.Render(selectedMarker),
+		rendered,
+		messageIDStyle.Render(" #"+line.event.ID),
+	)
+}
+
+// scrollToLine adjusts the viewport so that the rendered line at index is in
+// view.
+func (m *model) scrollToLine(rendered []string, index int) {
+	top := 0
+	for _, r := range rendered[:index] {
+		top += lipgloss.Height(r)
+	}
+	bottom := top + lipgloss.Height(rendered[index])
+
+	height := m.viewport.Height - m.viewport.Style.GetVerticalFrameSize()
+	switch {
+	case top < m.viewport.YOffset:
+		m.viewport.SetYOffset(top)
+	case bottom > m.viewport.YOffset+height:
+		m.viewport.SetYOffset(bottom - height)
+	}
+}
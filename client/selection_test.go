@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestUpEditsLastOwnMessage(t *testing.T) {
+	own := chatLine{event: Event{Type: eventMessage, ID: "m1", From: "alice", Text: "hi"}, mine: true}
+	other := chatLine{event: Event{Type: eventMessage, ID: "m2", From: "bob", Text: "yo"}}
+	pending := chatLine{event: Event{Type: eventMessage, ClientID: "c1", From: "alice", Text: "wait"}, status: statusPending, mine: true}
+
+	tests := []struct {
+		name         string
+		lines        []chatLine
+		draft        string
+		wantConsumed bool
+		wantInput    string
+	}{
+		{"edits own message", []chatLine{own, other}, "", true, "/edit m1 hi"},
+		{"skips pending message", []chatLine{own, pending}, "", true, "/edit m1 hi"},
+		{"no own message", []chatLine{other}, "", false, ""},
+		{"draft in progress", []chatLine{own}, "typing", false, "typing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.username = "alice"
+			m.messages = tt.lines
+			m.textarea.SetValue(tt.draft)
+			if got := m.handleShortcut(tea.KeyMsg{Type: tea.KeyUp}); got != tt.wantConsumed {
+				t.Errorf("handleShortcut(up) = %v, want %v", got, tt.wantConsumed)
+			}
+			if got := m.textarea.Value(); got != tt.wantInput {
+				t.Errorf("input = %q, want %q", got, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestMoveSelection(t *testing.T) {
+	lines := []chatLine{
+		{event: Event{Type: eventMessage, ID: "m1"}},
+		{event: Event{Type: eventSystem, Text: "notice"}},
+		{event: Event{Type: eventMessage, ID: "m2", Deleted: true}},
+		{event: Event{Type: eventMessage, ID: "m3"}},
+	}
+	tests := []struct {
+		name     string
+		selected string
+		delta    int
+		want     string
+	}{
+		{"up from nothing picks newest", "", -1, "m3"},
+		{"up skips deleted and notices", "m3", -1, "m1"},
+		{"up stops at oldest", "m1", -1, "m1"},
+		{"down past newest clears", "m3", 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.messages = lines
+			m.selectedID = tt.selected
+			m.moveSelection(tt.delta)
+			if m.selectedID != tt.want {
+				t.Errorf("selected = %q, want %q", m.selectedID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// codeFence opens and closes a code snippet. This mirrors
+	// server/snippets.go.
+	codeFence = "```"
+
+	chatCharLimit    = 280
+	snippetCharLimit = 64 * 1024
+	maxSnippetLines  = 1000
+
+	snippetTabWidth = 4
+	// codeScrollStep is how many columns Shift+←/→ scroll code snippets.
+	codeScrollStep = 8
+)
+
+var (
+	snippetHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#82AAFF"))
+	lineNumberStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+
+	tokenStyles = map[tokenKind]lipgloss.Style{
+		tokenPlain:   lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA")),
+		tokenKeyword: lipgloss.NewStyle().Foreground(lipgloss.Color("#C792EA")).Bold(true),
+		tokenType:    lipgloss.NewStyle().Foreground(lipgloss.Color("#FFCB6B")),
+		tokenString:  lipgloss.NewStyle().Foreground(lipgloss.Color("#C3E88D")),
+		tokenNumber:  lipgloss.NewStyle().Foreground(lipgloss.Color("#F78C6C")),
+		tokenComment: lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true),
+	}
+)
+
+// parseSnippet splits a fenced code block into its language tag and code,
+// the way the server does. ok is false if text is not a code block.
+func parseSnippet(text string) (lang, code string, ok bool) {
+	rest, found := strings.CutPrefix(text, codeFence)
+	if !found {
+		return "", "", false
+	}
+	info, code, found := strings.Cut(rest, "\n")
+	if !found {
+		return "", "", false
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = strings.ToLower(fields[0])
+	}
+	code = strings.TrimRight(code, " \t\r\n")
+	code = strings.TrimSuffix(code, codeFence)
+	return lang, strings.TrimRight(code, "\r\n"), true
+}
+
+// openSnippet reports whether draft is a code block still waiting for its
+// closing fence, in which case Enter starts a new line instead of sending.
+func openSnippet(draft string) bool {
+	if !strings.HasPrefix(draft, codeFence) {
+		return false
+	}
+	lines := strings.Split(strings.TrimRight(draft, " \t\n"), "\n")
+	return len(lines) < 2 || strings.TrimSpace(lines[len(lines)-1]) != codeFence
+}
+
+// charLimitFor returns how much the input may hold: chat lines are short,
+// but drafts containing a code block may be as long as a snippet.
+func charLimitFor(draft string) int {
+	if strings.Contains(draft, codeFence) {
+		return snippetCharLimit
+	}
+	return chatCharLimit
+}
+
+// fenced turns a snippet back into the code block it was sent as.
+func fenced(ev Event) string {
+	return codeFence + ev.Lang + "\n" + ev.Text + "\n" + codeFence
+}
+
+// snippetLines returns the lines of a snippet with tabs expanded.
+func snippetLines(code string) []string {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, "\t") {
+			continue
+		}
+		var b strings.Builder
+		col := 0
+		for _, r := range line {
+			if r == '\t' {
+				pad := snippetTabWidth - col%snippetTabWidth
+				b.WriteString(strings.Repeat(" ", pad))
+				col += pad
+				continue
+			}
+			b.WriteRune(r)
+			col++
+		}
+		lines[i] = b.String()
+	}
+	return lines
+}
+
+// snippetHeader describes a snippet on the line with its sender.
+func snippetHeader(ev Event, lines int, wide bool) string {
+	lang := ev.Lang
+	if lang == "" {
+		lang = "code"
+	}
+	header := fmt.Sprintf("%s · %d lines", lang, lines)
+	if lines == 1 {
+		header = lang + " · 1 line"
+	}
+	if wide {
+		header += " · Shift+←/→ to scroll"
+	}
+	return snippetHeaderStyle.Render(header)
+}
+
+// renderSnippet draws the code of a snippet with line numbers and syntax
+// highlighting. Lines are not wrapped: each shows width columns starting at
+// column offset.
+func renderSnippet(lines []string, lang string, width, offset int) string {
+	gutter := len(fmt.Sprint(len(lines)))
+	rows := make([]string, len(lines))
+	for n, toks := range languageFor(lang).highlight(lines) {
+		number := lineNumberStyle.Render(fmt.Sprintf("  %*d │ ", gutter, n+1))
+		rows[n] = number + renderWindow(toks, offset, width)
+	}
+	return strings.Join(rows, "\n")
+}
+
+// renderWindow renders the columns [offset, offset+width) of a line of
+// tokens.
+func renderWindow(toks []token, offset, width int) string {
+	var b strings.Builder
+	col := 0
+	for _, tok := range toks {
+		rs := []rune(tok.text)
+		start, end := col, col+len(rs)
+		col = end
+		if end <= offset {
+			continue
+		}
+		if start >= offset+width {
+			break
+		}
+		from := max(offset-start, 0)
+		to := min(offset+width-start, len(rs))
+		b.WriteString(tokenStyles[tok.kind].Render(string(rs[from:to])))
+	}
+	return b.String()
+}
+
+// codeWidth is how many columns of code fit next to the line numbers of a
+// snippet with the given number of lines.
+func (m *model) codeWidth(lines int) int {
+	gutter := len(fmt.Sprint(lines)) + 5 // "  " before the number, " │ " after
+	return max(m.viewport.Width-m.viewport.Style.GetHorizontalFrameSize()-gutter, 1)
+}
+
+// widestLine returns the width of the longest line of lines.
+func widestLine(lines []string) int {
+	widest := 0
+	for _, line := range lines {
+		widest = max(widest, len([]rune(line)))
+	}
+	return widest
+}
+
+// scrollCode scrolls every code snippet horizontally by delta columns, up to
+// the widest line shown.
+func (m *model) scrollCode(delta int) {
+	widest := 0
+	for _, line := range m.visibleLines() {
+		if line.event.Code {
+			lines := snippetLines(line.event.Text)
+			widest = max(widest, widestLine(lines)-m.codeWidth(len(lines)))
+		}
+	}
+	m.codeOffset = min(max(m.codeOffset+delta, 0), max(widest, 0))
+	m.refreshViewport()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClientParseSnippet(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantLang string
+		wantCode string
+		wantOK   bool
+	}{
+		{"```go\nfmt.Println(1)\n```", "go", "fmt.Println(1)", true},
+		{"```\nplain", "", "plain", true},
+		{"```go", "", "", false},
+		{"plain", "", "", false},
+	}
+	for _, tt := range tests {
+		lang, code, ok := parseSnippet(tt.in)
+		if lang != tt.wantLang || code != tt.wantCode || ok != tt.wantOK {
+			t.Errorf("parseSnippet(%q) = %q, %q, %v; want %q, %q, %v", tt.in, lang, code, ok, tt.wantLang, tt.wantCode, tt.wantOK)
+		}
+	}
+}
+
+func TestOpenSnippet(t *testing.T) {
+	tests := []struct {
+		draft string
+		want  bool
+	}{
+		{"hello", false},
+		{"```go", true},
+		{"```go\nx := 1", true},
+		{"```go\nx := 1\n```", false},
+		{"```go\nx := 1\n```  \n", false},
+	}
+	for _, tt := range tests {
+		if got := openSnippet(tt.draft); got != tt.want {
+			t.Errorf("openSnippet(%q) = %v, want %v", tt.draft, got, tt.want)
+		}
+	}
+}
+
+func TestSnippetLines(t *testing.T) {
+	tests := []struct {
+		code string
+		want []string
+	}{
+		{"a\nb", []string{"a", "b"}},
+		{"\tx", []string{"    x"}},
+		{"ab\tc", []string{"ab  c"}},
+	}
+	for _, tt := range tests {
+		if got := snippetLines(tt.code); !slices.Equal(got, tt.want) {
+			t.Errorf("snippetLines(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		lang  string
+		lines []string
+		line  int
+		text  string
+		want  tokenKind
+	}{
+		{"go", []string{`return "x" // done`}, 0, "return", tokenKeyword},
+		{"go", []string{`return "x" // done`}, 0, `"x"`, tokenString},
+		{"go", []string{`return "x" // done`}, 0, "// done", tokenComment},
+		{"golang", []string{"x := 42"}, 0, "42", tokenNumber},
+		{"go", []string{"/* open", "still */ nil"}, 1, "still */", tokenComment},
+		{"go", []string{"/* open", "still */ nil"}, 1, "nil", tokenType},
+		{"go", []string{"x := `raw", "more` + 1"}, 1, "more`", tokenString},
+		{"unknown", []string{"return 1"}, 0, "return 1", tokenPlain},
+	}
+	for _, tt := range tests {
+		toks := languageFor(tt.lang).highlight(tt.lines)[tt.line]
+		i := slices.IndexFunc(toks, func(tok token) bool { return tok.text == tt.text })
+		if i < 0 || toks[i].kind != tt.want {
+			t.Errorf("highlight(%s, %q): want %q as kind %d, got %+v", tt.lang, tt.lines, tt.text, tt.want, toks)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	replyCountStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#82AAFF"))
+	threadHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA")).Background(lipgloss.Color("#5A56E0")).Padding(0, 1)
+)
+
+// findLine returns the line holding the message with the given server ID,
+// whether it is in the room or in a thread.
+func (m *model) findLine(id string) *chatLine {
+	if id == "" {
+		return nil
+	}
+	for i := range m.messages {
+		if m.messages[i].event.ID == id {
+			return &m.messages[i]
+		}
+	}
+	for root := range m.threads {
+		replies := m.threads[root]
+		for i := range replies {
+			if replies[i].event.ID == id {
+				return &replies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// visibleLines returns the lines currently shown: the open thread, or the
+// active tab otherwise.
+func (m *model) visibleLines() []chatLine {
+	if m.threadID == "" {
+		var lines []chatLine
+		for _, line := range m.messages {
+			if roomOf(line) == m.activeRoom {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+	var lines []chatLine
+	if root := m.findLine(m.threadID); root != nil {
+		lines = append(lines, *root)
+	}
+	return append(lines, m.threads[m.threadID]...)
+}
+
+// addReply files a thread reply under its root, ignoring duplicates.
+func (m *model) addReply(line chatLine) {
+	if m.findLine(line.event.ID) != nil {
+		return
+	}
+	root := line.event.Thread
+	m.threads[root] = append(m.threads[root], line)
+	if m.threadID == root {
+		m.refreshViewport()
+	}
+}
+
+// applyThread replaces what we know of a thread with the server's copy.
+func (m *model) applyThread(ev Event) {
+	if len(ev.History) == 0 {
+		return
+	}
+	var replies []chatLine
+	for _, msg := range ev.History {
+		if msg.ID == ev.ID {
+			if root := m.findLine(ev.ID); root != nil {
+				root.event = msg
+			}
+			continue
+		}
+		replies = append(replies, chatLine{event: msg, mine: msg.From == m.username})
+	}
+	m.threads[ev.ID] = replies
+	m.refreshViewport()
+}
+
+// applyReplyCount updates the "↳ N replies" indicator of a thread root.
+func (m *model) applyReplyCount(ev Event) {
+	if root := m.findLine(ev.ID); root != nil {
+		root.event.Replies = ev.Replies
+		m.refreshViewport()
+	}
+}
+
+// openThread shows the thread of the given message in place of the room and
+// asks the server for its full contents.
+func (m *model) openThread(line chatLine) {
+	root := line.event.ID
+	if line.event.Thread != "" {
+		root = line.event.Thread
+	}
+	m.threadID = root
+	m.selectedID = ""
+	m.refreshViewport()
+
+	if m.conn == nil || !m.connected {
+		return
+	}
+	if err := m.send("", "/thread "+root); err != nil {
+		log.Printf("Failed to request thread %s: %v", root, err)
+	}
+}
+
+// closeThread goes back to the room view.
+func (m *model) closeThread() {
+	m.threadID = ""
+	m.selectedID = ""
+	m.refreshViewport()
+}
+
+// renderReplyCount draws the indicator shown under a thread root in the room.
+func renderReplyCount(replies int) string {
+	if replies == 1 {
+		return replyCountStyle.Render("  ↳ 1 reply")
+	}
+	return replyCountStyle.Render(fmt.Sprintf("  ↳ %d replies", replies))
+}
+
+// threadHeader is the title shown above the viewport while a thread is open.
+func (m *model) threadHeader() string {
+	title := "Thread"
+	if root := m.findLine(m.threadID); root != nil {
+		title = fmt.Sprintf("Thread · %s: %s", root.event.From, root.event.Text)
+	}
+	return threadHeaderStyle.Render(title + " (Esc to close)")
+}
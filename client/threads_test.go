@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVisibleLines(t *testing.T) {
+	root := chatLine{event: Event{Type: eventMessage, ID: "root", Replies: 1}}
+	reply := chatLine{event: Event{Type: eventMessage, ID: "r1", Thread: "root"}}
+	tests := []struct {
+		name     string
+		threadID string
+		want     []string
+	}{
+		{"room", "", []string{"root", "other"}},
+		{"thread", "root", []string{"root", "r1"}},
+		{"thread of unknown root", "gone", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.messages = []chatLine{root, {event: Event{Type: eventMessage, ID: "other"}}}
+			m.addReply(reply)
+			m.threadID = tt.threadID
+			var got []string
+			for _, line := range m.visibleLines() {
+				got = append(got, line.event.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("visible = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("visible = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderReplyCount(t *testing.T) {
+	tests := []struct {
+		replies int
+		want    string
+	}{
+		{1, "↳ 1 reply"},
+		{3, "↳ 3 replies"},
+	}
+	for _, tt := range tests {
+		if got := renderReplyCount(tt.replies); !strings.Contains(got, tt.want) {
+			t.Errorf("renderReplyCount(%d) = %q, want %q", tt.replies, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var topicStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#C3E88D")).Italic(true)
+
+// applyRoom records the topic and pinned messages of a room, sent when we
+// join it and whenever they change.
+func (m *model) applyRoom(ev Event) {
+	m.topics[ev.Room] = ev.Topic
+	m.pins[ev.Room] = ev.Pins
+	if ev.Text != "" {
+		m.appendLine(chatLine{event: Event{Type: eventSystem, Room: ev.Room, Text: ev.Text, Time: ev.Time}})
+		m.countUnread(ev)
+	}
+}
+
+// roomCommand adds the room shown to /topic and /pins, which the server
+// expects to name the room they apply to.
+func (m *model) roomCommand(message string) string {
+	for _, command := range []string{"/topic", "/pins"} {
+		if message == command {
+			return command + " " + m.activeRoom
+		}
+		if args, ok := strings.CutPrefix(message, command+" "); ok {
+			return fmt.Sprintf("%s %s %s", command, m.activeRoom, args)
+		}
+	}
+	return message
+}
+
+// topicLine shows the topic of the room shown and how many messages are
+// pinned in it, or "" if it has neither.
+func (m *model) topicLine() string {
+	topic, pins := m.topics[m.activeRoom], len(m.pins[m.activeRoom])
+	switch {
+	case pins == 0 && topic == "":
+		return ""
+	case pins == 0:
+		return topicStyle.Render(topic)
+	case topic == "":
+		return topicStyle.Render(fmt.Sprintf("%d pinned (/pins)", pins))
+	default:
+		return topicStyle.Render(fmt.Sprintf("%s · %d pinned (/pins)", topic, pins))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoomCommand(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"/topic", "/topic dm-team"},
+		{"/topic Release on Friday", "/topic dm-team Release on Friday"},
+		{"/pins", "/pins dm-team"},
+		{"/topical", "/topical"},
+		{"/pin 3fa9c21b", "/pin 3fa9c21b"},
+		{"hello", "hello"},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.activeRoom = "dm-team"
+		if got := m.roomCommand(tt.message); got != tt.want {
+			t.Errorf("roomCommand(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestTopicLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		pins  int
+		want  string
+	}{
+		{"nothing", "", 0, ""},
+		{"topic only", "Release on Friday", 0, "Release on Friday"},
+		{"pins only", "", 2, "2 pinned (/pins)"},
+		{"both", "Release on Friday", 1, "Release on Friday · 1 pinned (/pins)"},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.applyRoom(Event{Type: eventRoom, Room: m.activeRoom, Topic: tt.topic, Pins: make([]Event, tt.pins)})
+		got := m.topicLine()
+		if (got == "") != (tt.want == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: topicLine() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyRoomAnnouncement(t *testing.T) {
+	tests := []struct {
+		name       string
+		room       string
+		text       string
+		wantLines  int
+		wantUnread int
+	}{
+		{"silent update", defaultRoom, "", 0, 0},
+		{"shown room", defaultRoom, "carol pinned message m1.", 1, 0},
+		{"other room", "dm-team", "carol set the topic to: hi", 1, 1},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.applyRoom(Event{Type: eventRoom, Room: tt.room, Text: tt.text})
+		if len(m.messages) != tt.wantLines || m.unread[tt.room] != tt.wantUnread {
+			t.Errorf("%s: %d lines, %d unread, want %d and %d", tt.name, len(m.messages), m.unread[tt.room], tt.wantLines, tt.wantUnread)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// typingRefresh is how often we repeat "typing" while the input keeps
+	// changing, so the indicator on the other side doesn't expire.
+	typingRefresh = 3 * time.Second
+	// typingTimeout is how long we show someone as typing without hearing
+	// from them again.
+	typingTimeout = 6 * time.Second
+)
+
+var typingStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true)
+
+// typingExpiredMsg asks the model to drop typing indicators that timed out.
+type typingExpiredMsg struct{}
+
+// typingTargetFor returns where a draft would be sent: room for a chat line
+// or /me action, "@username" for a /pm, or false for other commands and empty
+// input.
+func typingTargetFor(draft, room string) (string, bool) {
+	draft = strings.TrimSpace(draft)
+	if draft == "" {
+		return "", false
+	}
+	if !strings.HasPrefix(draft, "/") || strings.HasPrefix(draft, "/me ") {
+		return room, true
+	}
+	parts := strings.SplitN(draft, " ", 3)
+	if len(parts) == 3 && parts[0] == "/pm" && parts[1] != "" {
+		return "@" + parts[1], true
+	}
+	return "", false
+}
+
+// updateTyping tells the server whether we are typing, based on the current
+// draft. "on" is throttled to once per typingRefresh for the same target.
+func (m *model) updateTyping() {
+	if m.conn == nil || !m.connected {
+		m.typingTarget = ""
+		return
+	}
+
+	target, typing := typingTargetFor(m.textarea.Value(), m.activeRoom)
+	if m.typingTarget != "" && (!typing || target != m.typingTarget) {
+		m.sendTyping("off", m.typingTarget)
+		m.typingTarget = ""
+	}
+	if typing && (target != m.typingTarget || time.Since(m.typingSentAt) >= typingRefresh) {
+		m.sendTyping("on", target)
+		m.typingTarget = target
+		m.typingSentAt = time.Now()
+	}
+}
+
+func (m *model) sendTyping(state, target string) {
+	if err := m.send("", fmt.Sprintf("/typing %s %s", state, target)); err != nil {
+		log.Printf("Failed to send typing %s for %s: %v", state, target, err)
+	}
+}
+
+// applyTyping updates who we show as typing and schedules the expiry check.
+func (m *model) applyTyping(ev Event) tea.Cmd {
+	if ev.Type == eventStopTyping {
+		delete(m.typers, ev.From)
+		return nil
+	}
+	if ev.Room != "" && ev.Room != m.activeRoom {
+		// Typing in a tab we are not looking at
+		return nil
+	}
+	m.typers[ev.From] = time.Now().Add(typingTimeout)
+	return tea.Tick(typingTimeout, func(time.Time) tea.Msg { return typingExpiredMsg{} })
+}
+
+// expireTypers drops everyone we haven't heard from within typingTimeout.
+func (m *model) expireTypers() {
+	now := time.Now()
+	for name, until := range m.typers {
+		if now.After(until) {
+			delete(m.typers, name)
+		}
+	}
+}
+
+// typingLine renders the "alice is typing…" line shown above the input.
+func (m model) typingLine() string {
+	names := make([]string, 0, len(m.typers))
+	for name := range m.typers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return typingStyle.Render(names[0] + " is typing…")
+	case 2:
+		return typingStyle.Render(names[0] + " and " + names[1] + " are typing…")
+	default:
+		return typingStyle.Render("Several people are typing…")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypingTargetFor(t *testing.T) {
+	tests := []struct {
+		draft      string
+		room       string
+		wantTarget string
+		wantTyping bool
+	}{
+		{"", defaultRoom, "", false},
+		{"   ", defaultRoom, "", false},
+		{"hello", defaultRoom, defaultRoom, true},
+		{"hello", "dm-1", "dm-1", true},
+		{"/pm bob hi", "dm-1", "@bob", true},
+		{"/pm bob", defaultRoom, "", false},
+		{"/pm  hi there", defaultRoom, "", false},
+		{"/list", defaultRoom, "", false},
+	}
+	for _, tt := range tests {
+		target, typing := typingTargetFor(tt.draft, tt.room)
+		if target != tt.wantTarget || typing != tt.wantTyping {
+			t.Errorf("typingTargetFor(%q, %q) = %q, %v; want %q, %v", tt.draft, tt.room, target, typing, tt.wantTarget, tt.wantTyping)
+		}
+	}
+}
+
+func TestTypingLine(t *testing.T) {
+	tests := []struct {
+		typers []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"alice"}, "alice is typing…"},
+		{[]string{"bob", "alice"}, "alice and bob are typing…"},
+		{[]string{"alice", "bob", "carol"}, "Several people are typing…"},
+	}
+	for _, tt := range tests {
+		m := initialModel()
+		m.typers = make(map[string]time.Time)
+		for _, name := range tt.typers {
+			m.typers[name] = time.Now().Add(typingTimeout)
+		}
+		if got := m.typingLine(); !strings.Contains(got, tt.want) || (tt.want == "" && got != "") {
+			t.Errorf("typingLine() with %v = %q, want %q", tt.typers, got, tt.want)
+		}
+	}
+}
@@ -48,7 +48,7 @@ fi
 
 # Run the client
 echo -e "${GREEN}Starting client...${NC}"
-go run client.go
+go run .
 
 # Handle exit
 echo -e "${RED}Client stopped.${NC}" 
\ No newline at end of file
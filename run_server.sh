@@ -35,9 +35,24 @@ else
     echo -e "${GREEN}All dependencies already installed.${NC}"
 fi
 
+# The server refuses to start without a storage key. Unless one is given in
+# $CHAT_STORAGE_KEY, create a key file for local use on first run.
+KEY_FILE=${CHAT_KEY_FILE:-storage.key}
+KEY_ARGS=()
+if [ -z "$CHAT_STORAGE_KEY" ]; then
+    if [ ! -f "$KEY_FILE" ]; then
+        echo -e "${YELLOW}Generating a storage key in ${KEY_FILE}. Keep it safe: without it the chat data cannot be read.${NC}"
+        (umask 077 && go run . keygen > "$KEY_FILE") || {
+            echo -e "${RED}Error: could not generate a storage key${NC}"
+            exit 1
+        }
+    fi
+    KEY_ARGS=(-key-file "$KEY_FILE")
+fi
+
 # Run the server
 echo -e "${GREEN}Starting server...${NC}"
-go run server.go
+go run . "${KEY_ARGS[@]}"
 
 # Handle exit
 echo -e "${RED}Server stopped.${NC}" 
\ No newline at end of file
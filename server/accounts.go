@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accountsFile      = "accounts.json"
+	minPasswordLength = 6
+)
+
+// Account is a registered username. Registered users can identify with their
+// password and receive private messages while offline.
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"password_hash"`
+	Registered   time.Time `json:"registered"`
+	PublicKey    string    `json:"public_key,omitempty"` // last key published, for PMs sent while offline
+	Ignores      []string  `json:"ignores,omitempty"`    // users whose room messages are hidden, see /ignore
+	Blocks       []string  `json:"blocks,omitempty"`     // users who cannot send PMs, typing or mentions, see /block
+}
+
+// loadAccounts reads the registered accounts from the store.
+func (s *Server) loadAccounts() error {
+	s.accountsMux.Lock()
+	defer s.accountsMux.Unlock()
+	return s.store.load(accountsFile, &s.accounts)
+}
+
+// isRegistered reports whether username belongs to an account.
+func (s *Server) isRegistered(username string) bool {
+	s.accountsMux.RLock()
+	defer s.accountsMux.RUnlock()
+	_, ok := s.accounts[username]
+	return ok
+}
+
+// isIdentified reports whether the client proved it owns its username.
+func (c *Client) isIdentified() bool {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.identified
+}
+
+func (c *Client) setIdentified(identified bool) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	c.identified = identified
+}
+
+// register creates an account for the client's current username.
+func (s *Server) register(client *Client, password string) {
+	if client.name() == "" {
+		client.sendSystem("Please set a username first using /nick <username>")
+		return
+	}
+	if len(password) < minPasswordLength {
+		client.sendSystem(fmt.Sprintf("Passwords must be at least %d characters long.", minPasswordLength))
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing password for %s: %v", client.name(), err)
+		client.sendSystem("Registration failed, please try again.")
+		return
+	}
+
+	s.accountsMux.Lock()
+	if _, exists := s.accounts[client.name()]; exists {
+		s.accountsMux.Unlock()
+		client.sendSystem(fmt.Sprintf("Username '%s' is already registered. Use /identify <password>.", client.name()))
+		return
+	}
+	s.accounts[client.name()] = &Account{Username: client.name(), PasswordHash: hash, Registered: time.Now()}
+	err = s.store.save(accountsFile, s.accounts)
+	s.accountsMux.Unlock()
+	if err != nil {
+		log.Printf("Error saving accounts: %v", err)
+	}
+
+	client.setIdentified(true)
+	s.rememberKey(client)
+	s.saveIgnores(client)
+	log.Printf("Client %s (%s) registered", client.name(), client.ip)
+	client.sendSystem(fmt.Sprintf("Username '%s' is now registered to you.", client.name()))
+}
+
+// identify checks password against the account of the client's username and,
+// on success, delivers any private messages that arrived while it was away.
+func (s *Server) identify(client *Client, password string) {
+	s.accountsMux.RLock()
+	account, ok := s.accounts[client.name()]
+	s.accountsMux.RUnlock()
+	if !ok {
+		client.sendSystem(fmt.Sprintf("Username '%s' is not registered. Use /register <password>.", client.name()))
+		return
+	}
+	if bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		log.Printf("Failed identify for %s from %s", client.name(), client.ip)
+		client.sendSystem("Incorrect password.")
+		return
+	}
+
+	client.setIdentified(true)
+	s.rememberKey(client)
+	s.restoreIgnores(client)
+	log.Printf("Client %s (%s) identified", client.name(), client.ip)
+	client.sendSystem("You are now identified as " + client.name() + ".")
+	s.deliverMailbox(client)
+	s.sendConversations(client)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterIdentify(t *testing.T) {
+	tests := []struct {
+		name           string
+		register       string
+		identify       string
+		wantRegistered bool
+		wantIdentified bool
+		wantNotice     string
+	}{
+		{"correct password", "hunter22", "hunter22", true, true, "You are now identified as alice."},
+		{"wrong password", "hunter22", "hunter23", true, false, "Incorrect password."},
+		{"password too short", "abc", "abc", false, false, "is not registered"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			first, firstPeer := testClient(t, "alice")
+			s.register(first, tt.register)
+			readEvent(t, firstPeer)
+			if got := s.isRegistered("alice"); got != tt.wantRegistered {
+				t.Fatalf("isRegistered = %v, want %v", got, tt.wantRegistered)
+			}
+
+			second, peer := testClient(t, "alice")
+			s.identify(second, tt.identify)
+			if ev := readEvent(t, peer); !strings.Contains(ev.Text, tt.wantNotice) {
+				t.Errorf("identify notice = %q, want %q", ev.Text, tt.wantNotice)
+			}
+			if got := second.isIdentified(); got != tt.wantIdentified {
+				t.Errorf("isIdentified = %v, want %v", got, tt.wantIdentified)
+			}
+		})
+	}
+}
+
+func TestSealedMessageQueues(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered bool
+		online     bool
+		identified bool
+		wantQueued bool
+	}{
+		{"registered and offline", true, false, false, true},
+		{"registered, name held by someone unidentified", true, true, false, true},
+		{"registered and identified", true, true, true, false},
+		{"unregistered and online", false, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			if tt.registered {
+				s.accounts["bob"] = &Account{Username: "bob"}
+			}
+			if tt.online {
+				bob, _ := testClient(t, "bob")
+				bob.identified = tt.identified
+				s.clients[bob] = true
+			}
+			alice, peer := testClient(t, "alice")
+			alice.pubKey = testPublicKey
+			s.sendSealedMessage(alice, "c1", "bob", sealedBody(2))
+
+			if ack := readEvent(t, peer); ack.Type != eventAck || ack.Error != "" {
+				t.Fatalf("ack = %+v", ack)
+			}
+			if got := len(s.mailboxes["bob"]) == 1; got != tt.wantQueued {
+				t.Errorf("queued = %v, want %v", got, tt.wantQueued)
+			}
+		})
+	}
+}
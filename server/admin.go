@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"strings"
+)
+
+// redactSecrets hides the password of /admin, /register and /identify
+// commands, and the text of private messages, so they never reach the log.
+func redactSecrets(message string) string {
+	for _, command := range []string{"/admin ", "/register ", "/identify ", "/pm ", "/epm "} {
+		if strings.HasPrefix(message, command) {
+			return command + "****"
+		}
+	}
+	return message
+}
+
+// isAdmin reports whether the client has authenticated with /admin.
+func (c *Client) isAdmin() bool {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.admin
+}
+
+// authenticateAdmin grants client admin rights if password matches the
+// configured admin password.
+func (s *Server) authenticateAdmin(client *Client, password string) {
+	if s.config.AdminPassword == "" {
+		client.sendSystem("Admin access is disabled on this server.")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(s.config.AdminPassword)) != 1 {
+		log.Printf("Failed admin login from %s (%s)", client.name(), client.ip)
+		client.sendSystem("Incorrect admin password.")
+		return
+	}
+
+	client.stateMux.Lock()
+	client.admin = true
+	client.stateMux.Unlock()
+	log.Printf("Client %s (%s) is now an admin", client.name(), client.ip)
+	client.sendSystem("You are now an admin.")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+)
+
+// Config holds the server settings that can be changed from the command line.
+type Config struct {
+	// IdleTimeout is how long a client may be inactive before it is
+	// automatically marked away. Zero disables idle detection.
+	IdleTimeout time.Duration
+	// AdminPassword unlocks admin rights via /admin and guards /metrics.
+	// Empty disables both.
+	AdminPassword string
+	// DataDir is where accounts and other persistent state are kept.
+	DataDir string
+	// KeyFile holds the keys that encrypt the data directory. When empty,
+	// they are read from $CHAT_STORAGE_KEY.
+	KeyFile string
+	// MailboxQuota is how many private messages a registered user can have
+	// waiting while offline.
+	MailboxQuota int
+	// MaxMessageBytes limits the text of chat lines and private messages.
+	MaxMessageBytes int
+	// MaxSnippetBytes limits the code of a fenced code snippet.
+	MaxSnippetBytes int
+	// MaxFileBytes limits the size of a shared file.
+	MaxFileBytes int64
+	// FileQuota is how many bytes of shared files each user may store.
+	FileQuota int64
+	// RetainAge, RetainCount and RetainBytes are the global retention
+	// limits on room history; rooms may set their own. Zero keeps all.
+	RetainAge   time.Duration
+	RetainCount int
+	RetainBytes int64
+	// CompactInterval is how often history is compacted to enforce the
+	// retention limits. Zero disables background compaction.
+	CompactInterval time.Duration
+}
+
+// loadConfig parses the command-line flags into a Config.
+func loadConfig() Config {
+	var cfg Config
+	flag.DurationVar(&cfg.IdleTimeout, "idle", 10*time.Minute, "mark users away after this much inactivity (0 disables)")
+	flag.StringVar(&cfg.AdminPassword, "admin-password", os.Getenv("CHAT_ADMIN_PASSWORD"), "password for /admin and /metrics (default $CHAT_ADMIN_PASSWORD)")
+	flag.StringVar(&cfg.DataDir, "data", "data", "directory for persistent state")
+	flag.StringVar(&cfg.KeyFile, "key-file", "", "file with the storage keys, current key first (default $CHAT_STORAGE_KEY)")
+	flag.IntVar(&cfg.MailboxQuota, "mailbox-quota", 50, "max private messages kept for an offline registered user")
+	flag.IntVar(&cfg.MaxMessageBytes, "max-message", 2000, "max bytes of text in a chat message")
+	flag.IntVar(&cfg.MaxSnippetBytes, "max-snippet", 64*1024, "max bytes of code in a code snippet")
+	flag.Int64Var(&cfg.MaxFileBytes, "max-file", 10<<20, "max bytes of a shared file")
+	flag.Int64Var(&cfg.FileQuota, "file-quota", 100<<20, "max bytes of shared files stored per user")
+	flag.DurationVar(&cfg.RetainAge, "retain-age", 0, "purge room messages older than this (0 keeps all)")
+	flag.IntVar(&cfg.RetainCount, "retain-count", 0, "max messages kept per room (0 keeps all)")
+	flag.Int64Var(&cfg.RetainBytes, "retain-bytes", 0, "max bytes of history kept per room (0 keeps all)")
+	flag.DurationVar(&cfg.CompactInterval, "compact-interval", time.Hour, "how often to compact history and apply retention (0 disables)")
+	flag.Parse()
+	return cfg
+}
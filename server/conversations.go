@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	conversationsFile = "conversations.json"
+	// conversationPrefix starts the ID of every group conversation. The ID
+	// is also the name of the conversation's room.
+	conversationPrefix = "dm-"
+)
+
+// Conversation is a private room whose messages only its members receive.
+// Its ID stays the same when members join or leave. Members are registered
+// users, and a client only counts as one once it has identified: anyone can
+// take a nickname, so the name alone would hand a departed member's
+// conversations to whoever uses it next.
+type Conversation struct {
+	ID      string
+	Members []string // sorted usernames
+	Created time.Time
+}
+
+// isConversation reports whether room is a group conversation rather than a
+// public room.
+func isConversation(room string) bool {
+	return strings.HasPrefix(room, conversationPrefix)
+}
+
+// loadConversations reads the group conversations from the store and opens
+// their rooms.
+func (s *Server) loadConversations() error {
+	s.conversationsMux.Lock()
+	err := s.store.load(conversationsFile, &s.conversations)
+	ids := make([]string, 0, len(s.conversations))
+	for id := range s.conversations {
+		ids = append(ids, id)
+	}
+	s.conversationsMux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.roomsMux.Lock()
+	defer s.roomsMux.Unlock()
+	for _, id := range ids {
+		s.rooms[id] = newRoom(id, s.store)
+	}
+	return nil
+}
+
+// saveConversations writes the group conversations to the store. The caller
+// must hold conversationsMux.
+func (s *Server) saveConversations() {
+	if err := s.store.save(conversationsFile, s.conversations); err != nil {
+		log.Printf("Error saving conversations: %v", err)
+	}
+}
+
+// conversationMembers returns a copy of the members of conversation id, or
+// nil if there is no such conversation.
+func (s *Server) conversationMembers(id string) []string {
+	s.conversationsMux.RLock()
+	defer s.conversationsMux.RUnlock()
+	if conv, ok := s.conversations[id]; ok {
+		return slices.Clone(conv.Members)
+	}
+	return nil
+}
+
+// canAccess reports whether client may read and post in room. Public rooms
+// are open to everyone; conversations only to their members.
+func (s *Server) canAccess(client *Client, room string) bool {
+	if !isConversation(room) {
+		return true
+	}
+	return client.isMemberOf(s.conversationMembers(room))
+}
+
+// isMemberOf reports whether client is one of members: it uses one of their
+// usernames and has identified as its owner.
+func (c *Client) isMemberOf(members []string) bool {
+	return c.name() != "" && slices.Contains(members, c.name()) && c.isIdentified()
+}
+
+// onlyMembers filters names down to the users who can read room.
+func (s *Server) onlyMembers(room string, names []string) []string {
+	if !isConversation(room) {
+		return names
+	}
+	members := s.conversationMembers(room)
+	return slices.DeleteFunc(names, func(name string) bool {
+		return !slices.Contains(members, name)
+	})
+}
+
+// conversationFor returns the conversation with exactly the given members,
+// creating it if there is none yet. created is true for a new conversation.
+func (s *Server) conversationFor(members []string) (conv Conversation, created bool, err error) {
+	s.conversationsMux.Lock()
+	for _, c := range s.conversations {
+		if slices.Equal(c.Members, members) {
+			conv = Conversation{ID: c.ID, Members: slices.Clone(c.Members), Created: c.Created}
+			s.conversationsMux.Unlock()
+			return conv, false, nil
+		}
+	}
+	id, err := newMessageID()
+	if err != nil {
+		s.conversationsMux.Unlock()
+		return conv, false, err
+	}
+	c := &Conversation{ID: conversationPrefix + id, Members: members, Created: time.Now()}
+	s.conversations[c.ID] = c
+	s.saveConversations()
+	conv = Conversation{ID: c.ID, Members: slices.Clone(c.Members), Created: c.Created}
+	s.conversationsMux.Unlock()
+
+	// roomsMux is never taken while holding conversationsMux: broadcasts run
+	// under a room lock and look up conversation members.
+	s.roomsMux.Lock()
+	s.rooms[conv.ID] = newRoom(conv.ID, s.store)
+	s.roomsMux.Unlock()
+	return conv, true, nil
+}
+
+// sendDirectMessage posts text to a group conversation. target is either the
+// ID of a conversation the sender belongs to, or a comma-separated list of
+// usernames, in which case the conversation between exactly those users and
+// the sender is used or created.
+func (s *Server) sendDirectMessage(sender *Client, clientID, target, text string) {
+	if sender.name() == "" {
+		sender.sendAckError(clientID, "Please set a username first using /nick <username>")
+		return
+	}
+	if !sender.isIdentified() {
+		sender.sendAckError(clientID, "Conversations are only open to registered users. Use /register or /identify first.")
+		return
+	}
+
+	id := target
+	if !isConversation(target) {
+		members := []string{sender.name()}
+		for _, name := range strings.Split(target, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || slices.Contains(members, name) {
+				continue
+			}
+			if !s.isRegistered(name) {
+				sender.sendAckError(clientID, fmt.Sprintf("User '%s' is not registered; only registered users can join conversations.", name))
+				return
+			}
+			if s.blocks(name, sender.name()) {
+				sender.sendAckError(clientID, fmt.Sprintf("%s is not accepting private messages from you.", name))
+				return
+			}
+			members = append(members, name)
+		}
+		if len(members) < 2 {
+			sender.sendAckError(clientID, "Usage: /dm <user1,user2,...|conversation> <message>")
+			return
+		}
+		slices.Sort(members)
+
+		conv, created, err := s.conversationFor(members)
+		if err != nil {
+			log.Printf("Error starting conversation: %v", err)
+			sender.sendAckError(clientID, "Could not start the conversation.")
+			return
+		}
+		if created {
+			log.Printf("%s started conversation %s with %s", sender.name(), conv.ID, strings.Join(members, ", "))
+			others := slices.DeleteFunc(slices.Clone(members), func(name string) bool { return name == sender.name() })
+			s.notifyConversation(conv, fmt.Sprintf("%s started a conversation with %s.", sender.name(), strings.Join(others, ", ")))
+		}
+		id = conv.ID
+	}
+
+	room := s.room(id)
+	if room == nil || !s.canAccess(sender, id) {
+		sender.sendAckError(clientID, fmt.Sprintf("Conversation '%s' not found.", id))
+		return
+	}
+
+	if err := s.publishMessage(room, sender, clientID, chatEvent(sender, text)); err != nil {
+		log.Printf("Error publishing to %s: %v", id, err)
+	}
+}
+
+// inviteToConversation adds username to a conversation client belongs to.
+func (s *Server) inviteToConversation(client *Client, id, username string) {
+	if !s.canAccess(client, id) {
+		client.sendSystem(fmt.Sprintf("Conversation '%s' not found.", id))
+		return
+	}
+	if !s.isRegistered(username) {
+		client.sendSystem(fmt.Sprintf("User '%s' is not registered; only registered users can join conversations.", username))
+		return
+	}
+	if s.blocks(username, client.name()) {
+		client.sendSystem(fmt.Sprintf("%s is not accepting private messages from you.", username))
+		return
+	}
+
+	s.conversationsMux.Lock()
+	conv, ok := s.conversations[id]
+	if !ok || slices.Contains(conv.Members, username) {
+		s.conversationsMux.Unlock()
+		client.sendSystem(fmt.Sprintf("%s is already in %s.", username, id))
+		return
+	}
+	conv.Members = append(conv.Members, username)
+	slices.Sort(conv.Members)
+	s.saveConversations()
+	snapshot := Conversation{ID: conv.ID, Members: slices.Clone(conv.Members), Created: conv.Created}
+	s.conversationsMux.Unlock()
+
+	log.Printf("%s added %s to conversation %s", client.name(), username, id)
+	s.notifyConversation(snapshot, fmt.Sprintf("%s added %s to the conversation.", client.name(), username))
+	if invitee := s.findClient(username); invitee != nil && invitee.isIdentified() {
+		s.sendRoomState(invitee, id)
+	}
+}
+
+// leaveConversation removes client from a conversation. The conversation
+// and its history are dropped once its last member has left.
+func (s *Server) leaveConversation(client *Client, id string) {
+	if !s.canAccess(client, id) {
+		client.sendSystem(fmt.Sprintf("Conversation '%s' not found.", id))
+		return
+	}
+
+	s.conversationsMux.Lock()
+	conv, ok := s.conversations[id]
+	if !ok {
+		s.conversationsMux.Unlock()
+		client.sendSystem(fmt.Sprintf("Conversation '%s' not found.", id))
+		return
+	}
+	conv.Members = slices.DeleteFunc(conv.Members, func(name string) bool { return name == client.name() })
+	snapshot := Conversation{ID: conv.ID, Members: slices.Clone(conv.Members), Created: conv.Created}
+	empty := len(conv.Members) == 0
+	if empty {
+		delete(s.conversations, id)
+	}
+	s.saveConversations()
+	s.conversationsMux.Unlock()
+	if empty {
+		s.roomsMux.Lock()
+		delete(s.rooms, id)
+		s.roomsMux.Unlock()
+		s.index.removeRoom(id)
+		s.forgetRoom(id)
+		// A conversation under legal hold keeps its log even once abandoned.
+		if s.isHeld(id) {
+			log.Printf("Keeping history of %s under legal hold", id)
+		} else if err := s.store.remove(historyFile(id)); err != nil {
+			log.Printf("Error removing history of %s: %v", id, err)
+		}
+	}
+
+	log.Printf("%s left conversation %s", client.name(), id)
+	s.notifyConversation(snapshot, fmt.Sprintf("%s left the conversation.", client.name()))
+	// The member list no longer includes client, which tells it to close
+	// the conversation.
+	if err := client.send(Event{Type: eventConversation, Room: id, Members: snapshot.Members, Time: time.Now()}); err != nil {
+		log.Printf("Error confirming leave of %s to %s: %v", id, client.ip, err)
+	}
+}
+
+// notifyConversation tells every connected member of conv its current member
+// list, with text describing what changed.
+func (s *Server) notifyConversation(conv Conversation, text string) {
+	ev := Event{Type: eventConversation, Room: conv.ID, Members: conv.Members, Text: text, Time: time.Now()}
+	for _, name := range conv.Members {
+		if member := s.findClient(name); member != nil && member.isIdentified() {
+			if err := member.send(ev); err != nil {
+				log.Printf("Error sending conversation %s to %s: %v", conv.ID, member.ip, err)
+			}
+		}
+	}
+}
+
+// conversationsOf returns the conversations username belongs to, oldest
+// first.
+func (s *Server) conversationsOf(username string) []Conversation {
+	s.conversationsMux.RLock()
+	defer s.conversationsMux.RUnlock()
+	var convs []Conversation
+	for _, conv := range s.conversations {
+		if slices.Contains(conv.Members, username) {
+			convs = append(convs, Conversation{ID: conv.ID, Members: slices.Clone(conv.Members), Created: conv.Created})
+		}
+	}
+	slices.SortFunc(convs, func(a, b Conversation) int { return a.Created.Compare(b.Created) })
+	return convs
+}
+
+// sendConversations tells client about every conversation it belongs to,
+// with its topic and pins, so it can reopen them once it has identified.
+func (s *Server) sendConversations(client *Client) {
+	if !client.isIdentified() {
+		return
+	}
+	for _, conv := range s.conversationsOf(client.name()) {
+		if err := client.send(Event{Type: eventConversation, Room: conv.ID, Members: conv.Members, Time: time.Now()}); err != nil {
+			log.Printf("Error sending conversation %s to %s: %v", conv.ID, client.ip, err)
+		}
+		s.sendRoomState(client, conv.ID)
+	}
+}
+
+// listConversations shows client the conversations it belongs to.
+func (s *Server) listConversations(client *Client) {
+	if !client.isIdentified() {
+		client.sendSystem("Conversations are only open to registered users. Use /register or /identify first.")
+		return
+	}
+	convs := s.conversationsOf(client.name())
+	if len(convs) == 0 {
+		client.sendSystem("You are not in any conversations. Start one with /dm <user1,user2,...> <message>.")
+		return
+	}
+	lines := make([]string, len(convs))
+	for i, conv := range convs {
+		lines[i] = fmt.Sprintf("%s (%s)", conv.ID, strings.Join(conv.Members, ", "))
+	}
+	client.sendSystem("Your conversations: " + strings.Join(lines, "; "))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *Client
+		room   string
+		want   bool
+	}{
+		{"public room", &Client{username: "eve"}, defaultRoom, true},
+		{"identified member", &Client{username: "alice", identified: true}, "dm-1", true},
+		{"member's name, not identified", &Client{username: "alice"}, "dm-1", false},
+		{"identified non-member", &Client{username: "eve", identified: true}, "dm-1", false},
+		{"unknown conversation", &Client{username: "alice", identified: true}, "dm-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			s.conversations["dm-1"] = &Conversation{ID: "dm-1", Members: []string{"alice", "bob"}}
+			if got := s.canAccess(tt.client, tt.room); got != tt.want {
+				t.Errorf("canAccess = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlyMembers(t *testing.T) {
+	tests := []struct {
+		room  string
+		names []string
+		want  []string
+	}{
+		{defaultRoom, []string{"alice", "eve"}, []string{"alice", "eve"}},
+		{"dm-1", []string{"alice", "eve", "bob"}, []string{"alice", "bob"}},
+		{"dm-1", nil, nil},
+	}
+	for _, tt := range tests {
+		s := testServer(t)
+		s.conversations["dm-1"] = &Conversation{ID: "dm-1", Members: []string{"alice", "bob"}}
+		if got := s.onlyMembers(tt.room, slices.Clone(tt.names)); !slices.Equal(got, tt.want) {
+			t.Errorf("onlyMembers(%s, %v) = %v, want %v", tt.room, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestConversationFor(t *testing.T) {
+	s := testServer(t)
+	first, created, err := s.conversationFor([]string{"alice", "bob"})
+	if err != nil || !created || !isConversation(first.ID) || s.room(first.ID) == nil {
+		t.Fatalf("conversationFor = %+v, %v, %v", first, created, err)
+	}
+
+	tests := []struct {
+		members     []string
+		wantCreated bool
+	}{
+		{[]string{"alice", "bob"}, false},
+		{[]string{"alice", "bob", "carol"}, true},
+	}
+	for _, tt := range tests {
+		conv, created, err := s.conversationFor(tt.members)
+		if err != nil || created != tt.wantCreated || (conv.ID == first.ID) == tt.wantCreated {
+			t.Errorf("conversationFor(%v) = %s, %v, %v; want created %v", tt.members, conv.ID, created, err, tt.wantCreated)
+		}
+	}
+}
+
+func TestPublishMessageRecordsSeen(t *testing.T) {
+	tests := []struct {
+		room     string
+		wantSeen bool
+	}{
+		{defaultRoom, true},
+		{"dm-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.room, func(t *testing.T) {
+			s := testServer(t)
+			s.conversations["dm-1"] = &Conversation{ID: "dm-1", Members: []string{"alice"}}
+			s.rooms["dm-1"] = newRoom("dm-1", s.store)
+			alice, peer := testClient(t, "alice")
+			alice.identified = true
+
+			if err := s.publishMessage(s.room(tt.room), alice, "c1", Event{Type: eventMessage, From: "alice", Text: "secret"}); err != nil {
+				t.Fatalf("publishMessage: %v", err)
+			}
+			readEvent(t, peer)
+			if got := alice.lastMessage == "secret"; got != tt.wantSeen {
+				t.Errorf("recorded for /seen: %v, want %v", got, tt.wantSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// publicKeySize is the size of a NaCl box public key.
+	publicKeySize = 32
+	// sealedOverhead is what a sealed PM adds to its text: the nonce it is
+	// prefixed with and the box's authenticator.
+	sealedOverhead = 24 + box.Overhead
+)
+
+// Private messages are end-to-end encrypted: every client publishes the
+// public half of its NaCl box keypair with /pubkey, fetches the key of the
+// user it writes to with /key and sends the sealed message with /epm. The
+// server only relays the sealed body, with the sender's key attached so the
+// recipient can open it. Files sent to a user are not sealed this way: the
+// server stores their content, and logs their names.
+
+// publicKey returns the key the client published, or "" if it has none.
+func (c *Client) publicKey() string {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.pubKey
+}
+
+// publishKey handles /pubkey: it records the client's public key, and keeps it
+// with its account if the client is identified, so that messages can be
+// sealed for it while it is offline.
+func (s *Server) publishKey(client *Client, encoded string) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != publicKeySize {
+		client.sendSystem("Invalid public key.")
+		return
+	}
+	client.stateMux.Lock()
+	client.pubKey = encoded
+	client.stateMux.Unlock()
+	log.Printf("Client %s (%s) published a public key", client.name(), client.ip)
+	s.rememberKey(client)
+}
+
+// rememberKey stores the public key of an identified client in its account.
+func (s *Server) rememberKey(client *Client) {
+	key := client.publicKey()
+	if key == "" || !client.isIdentified() {
+		return
+	}
+	s.accountsMux.Lock()
+	defer s.accountsMux.Unlock()
+	account, ok := s.accounts[client.name()]
+	if !ok || account.PublicKey == key {
+		return
+	}
+	account.PublicKey = key
+	if err := s.store.save(accountsFile, s.accounts); err != nil {
+		log.Printf("Error saving accounts: %v", err)
+	}
+}
+
+// keyOf returns the public key private messages to username are sealed with:
+// that of its connected client or, for a registered username, the one kept
+// with the account. A client using a registered name without identifying
+// cannot stand in for its owner.
+func (s *Server) keyOf(username string) string {
+	registered := s.isRegistered(username)
+	if target := s.findClient(username); target != nil && (!registered || target.isIdentified()) {
+		if key := target.publicKey(); key != "" {
+			return key
+		}
+	}
+	if !registered {
+		return ""
+	}
+	s.accountsMux.RLock()
+	defer s.accountsMux.RUnlock()
+	return s.accounts[username].PublicKey
+}
+
+// sendKey handles /key: it tells client the public key of username, or why
+// there is none.
+func (s *Server) sendKey(client *Client, username string) {
+	// From stays as typed: the client matches it to its pending messages
+	target := s.resolveNick(username)
+	ev := Event{Type: eventKey, From: username, Key: s.keyOf(target), Time: time.Now()}
+	if ev.Key == "" {
+		if s.findClient(target) == nil && !s.isRegistered(target) {
+			ev.Error = fmt.Sprintf("User '%s' not found.", username)
+		} else {
+			ev.Error = fmt.Sprintf("%s has no encryption key; their client cannot receive private messages.", username)
+		}
+	}
+	if err := client.send(ev); err != nil {
+		log.Printf("Error sending key of %s to %s: %v", username, client.ip, err)
+	}
+}
+
+// sendSealedMessage handles /epm: it relays a private message sealed by
+// sender's client for targetUsername. The server cannot read it, so only the
+// size of the sealed body is checked.
+func (s *Server) sendSealedMessage(sender *Client, clientID, targetUsername, sealed string) {
+	if sender.name() == "" {
+		sender.sendAckError(clientID, "Please set a username first using /nick <username>")
+		return
+	}
+	key := sender.publicKey()
+	if key == "" {
+		sender.sendAckError(clientID, "Publish your public key with /pubkey before sending private messages.")
+		return
+	}
+	body, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(body) <= sealedOverhead {
+		sender.sendAckError(clientID, "Invalid encrypted message.")
+		return
+	}
+	// The server cannot tell a sealed snippet from sealed text, so both get
+	// the larger limit
+	limit := max(s.config.MaxMessageBytes, s.config.MaxSnippetBytes)
+	if len(body)-sealedOverhead > limit {
+		sender.sendAckError(clientID, fmt.Sprintf("encrypted messages are limited to %d bytes", limit))
+		return
+	}
+	ev := Event{
+		Type:   eventPM,
+		From:   sender.name(),
+		To:     targetUsername,
+		Key:    key,
+		Sealed: sealed,
+		Time:   time.Now(),
+	}
+	s.deliverPrivateMessage(sender, clientID, ev)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testPublicKey is a well-formed public key; the server never uses it to
+// open anything.
+var testPublicKey = base64.StdEncoding.EncodeToString(make([]byte, publicKeySize))
+
+// sealedBody returns a sealed message whose text would be n bytes long.
+func sealedBody(n int) string {
+	return base64.StdEncoding.EncodeToString(make([]byte, sealedOverhead+n))
+}
+
+func TestSendSealedMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		sealed    string
+		wantError string
+	}{
+		{"relayed", testPublicKey, sealedBody(5), ""},
+		{"no public key", "", sealedBody(5), "Publish your public key with /pubkey before sending private messages."},
+		{"not base64", testPublicKey, "!!!", "Invalid encrypted message."},
+		{"empty box", testPublicKey, sealedBody(0), "Invalid encrypted message."},
+		{"snippet-sized", testPublicKey, sealedBody(64 * 1024), ""},
+		{"too long", testPublicKey, sealedBody(64*1024 + 1), "encrypted messages are limited to 65536 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			bob, bobPeer := testClient(t, "bob")
+			s.clients[bob] = true
+			alice, peer := testClient(t, "alice")
+			alice.pubKey = tt.key
+			s.sendSealedMessage(alice, "c1", "bob", tt.sealed)
+
+			ack := readEvent(t, peer)
+			if ack.Error != tt.wantError {
+				t.Fatalf("error = %q, want %q", ack.Error, tt.wantError)
+			}
+			if tt.wantError != "" {
+				return
+			}
+			pm := readEvent(t, bobPeer)
+			if pm.Type != eventPM || pm.Sealed != tt.sealed || pm.Key != tt.key || pm.Text != "" || pm.ID == "" {
+				t.Errorf("bob got %+v", pm)
+			}
+		})
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	stored := strings.Replace(testPublicKey, "A", "B", 1)
+	tests := []struct {
+		name       string
+		registered bool
+		online     bool
+		identified bool
+		want       string
+	}{
+		{"online, unregistered", false, true, false, testPublicKey},
+		{"unknown", false, false, false, ""},
+		{"registered and offline", true, false, false, stored},
+		{"registered, name held by someone unidentified", true, true, false, stored},
+		{"registered and identified", true, true, true, testPublicKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			if tt.registered {
+				s.accounts["bob"] = &Account{Username: "bob", PublicKey: stored}
+			}
+			if tt.online {
+				bob, _ := testClient(t, "bob")
+				bob.pubKey, bob.identified = testPublicKey, tt.identified
+				s.clients[bob] = true
+			}
+			if got := s.keyOf("bob"); got != tt.want {
+				t.Errorf("keyOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	errNotAllowed     = errors.New("not allowed")
+	errMessageDeleted = errors.New("message deleted")
+)
+
+// roomWithMessage returns the room whose history holds the message id, or
+// nil if there is none that client can access.
+func (s *Server) roomWithMessage(client *Client, id string) *Room {
+	s.roomsMux.RLock()
+	var found *Room
+	for _, room := range s.rooms {
+		if room.has(id) {
+			found = room
+			break
+		}
+	}
+	s.roomsMux.RUnlock()
+	if found == nil || !s.canAccess(client, found.name) {
+		return nil
+	}
+	return found
+}
+
+// canModify reports whether client may edit or delete msg: the connection
+// that sent it can, and so can admins acting as moderators. A registered
+// author can also change it from a later connection once identified; any
+// other name match proves nothing, as the nickname may have been taken over.
+func (s *Server) canModify(client *Client, msg *Event) bool {
+	if msg.author == client || client.isAdmin() {
+		return true
+	}
+	return msg.From == client.name() && s.isRegistered(msg.From) && client.isIdentified()
+}
+
+// editMessage replaces the text of a stored room message and tells every
+// client to update it in place.
+func (s *Server) editMessage(client *Client, id, text string) {
+	var body Event
+	setBody(&body, text)
+	if err := s.checkLength(body); err != nil {
+		client.sendSystem(fmt.Sprintf("Edit rejected: %v.", err))
+		return
+	}
+
+	s.modifyMessage(client, id, func(msg *Event) Event {
+		msg.Text, msg.Spans, msg.Code, msg.Lang = body.Text, body.Spans, body.Code, body.Lang
+		msg.Edited = true
+		return Event{Type: eventEdit, ID: msg.ID, Room: msg.Room, Seq: msg.Seq, From: msg.From, Text: msg.Text, Spans: msg.Spans, Code: msg.Code, Lang: msg.Lang, Edited: true, Time: time.Now()}
+	})
+}
+
+// deleteMessage blanks a stored room message and tells every client to remove
+// it. The entry stays in history as a tombstone so replays carry the deletion.
+// A deleted file card takes its file with it.
+func (s *Server) deleteMessage(client *Client, id string) {
+	var file *FileInfo
+	defer func() {
+		if file != nil {
+			s.removeFile(file.ID)
+		}
+	}()
+	s.modifyMessage(client, id, func(msg *Event) Event {
+		file = msg.File
+		msg.Text = ""
+		msg.Spans = nil
+		msg.File = nil
+		msg.Deleted = true
+		return Event{Type: eventDelete, ID: msg.ID, Room: msg.Room, Seq: msg.Seq, From: msg.From, Deleted: true, Time: time.Now()}
+	})
+}
+
+// modifyMessage checks that client may change message id, applies change to
+// the stored copy and broadcasts the event change returns.
+func (s *Server) modifyMessage(client *Client, id string, change func(msg *Event) Event) {
+	room := s.roomWithMessage(client, id)
+	if room == nil {
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+		return
+	}
+
+	err := room.update(id, func(msg *Event) error {
+		if !s.canModify(client, msg) {
+			return errNotAllowed
+		}
+		if msg.Deleted {
+			return errMessageDeleted
+		}
+		ev := change(msg)
+		s.index.update(*msg)
+		log.Printf("%s %s message %s in %s", client.name(), ev.Type, id, room.name)
+		s.broadcast(ev, nil)
+		s.refreshMentions(*msg)
+		s.refreshPin(*msg)
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+	case errors.Is(err, errNotAllowed):
+		client.sendSystem("You can only change your own messages.")
+	case errors.Is(err, errMessageDeleted):
+		client.sendSystem(fmt.Sprintf("Message '%s' was deleted.", id))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanModify(t *testing.T) {
+	author := &Client{username: "alice"}
+	impostor := &Client{username: "alice"}
+	owner := &Client{username: "dave", identified: true}
+	unidentified := &Client{username: "dave"}
+	other := &Client{username: "bob"}
+	admin := &Client{username: "carol", admin: true}
+
+	tests := []struct {
+		name   string
+		client *Client
+		msg    Event
+		want   bool
+	}{
+		{"author", author, Event{From: "alice", author: author}, true},
+		{"same nickname on another connection", impostor, Event{From: "alice", author: author}, false},
+		{"someone else", other, Event{From: "alice", author: author}, false},
+		{"admin", admin, Event{From: "alice", author: author}, true},
+		{"unknown author", author, Event{From: "alice"}, false},
+		{"unknown author, admin", admin, Event{From: "alice"}, true},
+		{"registered author, identified", owner, Event{From: "dave"}, true},
+		{"registered author, not identified", unidentified, Event{From: "dave"}, false},
+		{"registered author, other user identified", owner, Event{From: "erin"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			s.accounts["dave"] = &Account{Username: "dave"}
+			if got := s.canModify(tt.client, &tt.msg); got != tt.want {
+				t.Errorf("canModify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomUpdate(t *testing.T) {
+	errRefused := errors.New("refused")
+	tests := []struct {
+		name     string
+		id       string
+		change   func(msg *Event) error
+		wantErr  error
+		wantText string
+	}{
+		{"changes message", "m1", func(msg *Event) error { msg.Text = "edited"; return nil }, nil, "edited"},
+		{"refused change is dropped", "m1", func(msg *Event) error { msg.Text = "edited"; return errRefused }, errRefused, "hello"},
+		{"unknown message", "m2", func(msg *Event) error { return nil }, errMessageNotFound, "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRoom(defaultRoom, testStore(t))
+			r.publish(Event{Type: eventMessage, ID: "m1", Text: "hello"}, func(Event) {})
+			if err := r.update(tt.id, tt.change); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("update = %v, want %v", err, tt.wantErr)
+			}
+			if got := r.history[0].Text; got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
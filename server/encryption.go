@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// keyEnv holds the storage keys when no key file is given.
+	keyEnv = "CHAT_STORAGE_KEY"
+	// keySize is the size of a storage key: AES-256.
+	keySize = 32
+	// recordPrefix starts every encrypted record, followed by the ID of its
+	// key, a colon and the base64 of nonce and ciphertext. Records without
+	// it were written before encryption was enabled.
+	recordPrefix = "enc1:"
+	// blobMagic starts every encrypted file, followed by the ID of its key.
+	// The content follows in sealed segments, each preceded by its length,
+	// and ends with an empty final segment so a truncated file is noticed.
+	blobMagic = "CHATENC1"
+	// finalSegment is set in the length of the final segment of a file.
+	finalSegment = 1 << 31
+	// keyIDSize is the length of a key ID: hex of the start of its SHA-256.
+	keyIDSize = 8
+)
+
+// storeKey is one storage key and the ID records sealed with it carry.
+type storeKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// keyring holds the keys of the store. The current key seals everything
+// written; older keys only open what has not been re-encrypted yet.
+type keyring struct {
+	current *storeKey
+	keys    map[string]*storeKey // by ID
+	// plaintext lets data written before encryption was enabled be read. Only
+	// "server encrypt" sets it, to encrypt such data once; otherwise it is
+	// refused, so unencrypted files slipped into the store are never trusted.
+	plaintext bool
+}
+
+// errPlaintext is returned for data that is not encrypted.
+var errPlaintext = errors.New(`not encrypted: run "server encrypt" once to encrypt data written before encryption was enabled`)
+
+// loadKeyring reads the storage keys from keyFile or, when that is empty,
+// from $CHAT_STORAGE_KEY. Keys are base64 and separated by whitespace or
+// commas; the first is current. It fails when there is no key, so the server
+// never writes plaintext by accident.
+func loadKeyring(keyFile string) (*keyring, error) {
+	text := os.Getenv(keyEnv)
+	source := "$" + keyEnv
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		text, source = string(data), keyFile
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no storage key: use -key-file or $%s (generate one with \"server keygen\")", keyEnv)
+	}
+
+	k := &keyring{keys: make(map[string]*storeKey)}
+	for i, field := range fields {
+		raw, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(raw) != keySize {
+			return nil, fmt.Errorf("key %d in %s is not %d base64-encoded bytes", i+1, source, keySize)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		key := &storeKey{id: hex.EncodeToString(sum[:])[:keyIDSize], aead: aead}
+		k.keys[key.id] = key
+		if i == 0 {
+			k.current = key
+		}
+	}
+	return k, nil
+}
+
+// newKey returns a random storage key in the form loadKeyring reads.
+func newKey() (string, error) {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// seal encrypts a record of the named file with the current key. The name is
+// authenticated too, so a record cannot be passed off as part of another file.
+func (k *keyring) seal(name string, plain []byte) ([]byte, error) {
+	nonce := make([]byte, k.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("sealing %s: %w", name, err)
+	}
+	sealed := k.current.aead.Seal(nonce, nonce, plain, []byte(name))
+	record := make([]byte, 0, len(recordPrefix)+keyIDSize+1+base64.StdEncoding.EncodedLen(len(sealed)))
+	record = append(record, recordPrefix+k.current.id+":"...)
+	return base64.StdEncoding.AppendEncode(record, sealed), nil
+}
+
+// sealLines seals each of lines as a record of the named file, one per line.
+func (k *keyring) sealLines(name string, lines [][]byte) ([]byte, error) {
+	var data []byte
+	for _, line := range lines {
+		record, err := k.seal(name, line)
+		if err != nil {
+			return nil, err
+		}
+		data = append(append(data, record...), '\n')
+	}
+	return data, nil
+}
+
+// open decrypts a record of the named file. Records written before encryption
+// was enabled are returned as they are while migrating, and refused otherwise.
+func (k *keyring) open(name string, record []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(record, []byte(recordPrefix))
+	if !ok {
+		if !k.plaintext {
+			return nil, errPlaintext
+		}
+		return record, nil
+	}
+	id, encoded, ok := bytes.Cut(rest, []byte(":"))
+	if !ok {
+		return nil, errors.New("malformed encrypted record")
+	}
+	key, ok := k.keys[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("encrypted with unknown key %s", id)
+	}
+	sealed, err := base64.StdEncoding.AppendDecode(nil, encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return nil, errors.New("malformed encrypted record")
+	}
+	n := key.aead.NonceSize()
+	plain, err := key.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with key %s: %w", id, err)
+	}
+	return plain, nil
+}
+
+// isCurrent reports whether record is sealed with the current key.
+func (k *keyring) isCurrent(record []byte) bool {
+	return bytes.HasPrefix(record, []byte(recordPrefix+k.current.id+":"))
+}
+
+// segmentData authenticates the position of segment i of the named file, and
+// whether it is the final one, so segments cannot be reordered, moved between
+// files or dropped from the end.
+func segmentData(name string, i uint64, final bool) []byte {
+	data := binary.BigEndian.AppendUint64([]byte(name+"\x00"), i)
+	if final {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// blobWriter encrypts a file as it is written: every Write becomes one sealed
+// segment, so a file is never on disk in plaintext, not even while it is
+// being uploaded. Close ends the file.
+type blobWriter struct {
+	w    io.Writer
+	key  *storeKey
+	name string
+	n    uint64 // segments written
+}
+
+// newBlobWriter starts an encrypted file with the given name in the store on
+// w, sealed with the current key.
+func (k *keyring) newBlobWriter(w io.Writer, name string) (*blobWriter, error) {
+	if _, err := io.WriteString(w, blobMagic+k.current.id); err != nil {
+		return nil, err
+	}
+	return &blobWriter{w: w, key: k.current, name: name}, nil
+}
+
+func (bw *blobWriter) Write(p []byte) (int, error) {
+	if err := bw.writeSegment(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the final segment. It does not close the underlying writer.
+func (bw *blobWriter) Close() error {
+	return bw.writeSegment(nil, true)
+}
+
+func (bw *blobWriter) writeSegment(p []byte, final bool) error {
+	nonce := make([]byte, bw.key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := bw.key.aead.Seal(nonce, nonce, p, segmentData(bw.name, bw.n, final))
+	bw.n++
+	size := uint32(len(sealed))
+	if final {
+		size |= finalSegment
+	}
+	if _, err := bw.w.Write(binary.BigEndian.AppendUint32(nil, size)); err != nil {
+		return err
+	}
+	_, err := bw.w.Write(sealed)
+	return err
+}
+
+// blobReader decrypts a file written by blobWriter one segment at a time.
+// Files stored before encryption was enabled are read as they are while
+// migrating.
+type blobReader struct {
+	r     *bufio.Reader
+	key   *storeKey // nil for a plaintext file
+	keyID string
+	name  string
+	n     uint64 // segments read
+	done  bool   // the final segment was read
+}
+
+// newBlobReader reads the named file of the store from r.
+func (k *keyring) newBlobReader(r io.Reader, name string) (*blobReader, error) {
+	br := &blobReader{r: bufio.NewReaderSize(r, fileChunkSize), name: name}
+	header, err := br.r.Peek(len(blobMagic) + keyIDSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte(blobMagic)) {
+		if !k.plaintext {
+			return nil, fmt.Errorf("%s: %w", name, errPlaintext)
+		}
+		return br, nil
+	}
+	br.keyID = string(header[len(blobMagic):])
+	key, ok := k.keys[br.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%s is encrypted with unknown key %s", name, br.keyID)
+	}
+	br.key = key
+	br.r.Discard(len(header))
+	return br, nil
+}
+
+// next returns the next piece of the file's content, or io.EOF at its end.
+func (br *blobReader) next() ([]byte, error) {
+	if br.key == nil {
+		buf := make([]byte, fileChunkSize)
+		n, err := io.ReadFull(br.r, buf)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+		if n == 0 && err == nil {
+			err = io.EOF
+		}
+		return buf[:n], err
+	}
+
+	if br.done {
+		return nil, io.EOF
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(br.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%s is truncated after segment %d", br.name, br.n)
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	final := size&finalSegment != 0
+	sealed := make([]byte, size&^finalSegment)
+	n := br.key.aead.NonceSize()
+	if _, err := io.ReadFull(br.r, sealed); err != nil || len(sealed) < n {
+		return nil, fmt.Errorf("truncated segment %d of %s", br.n, br.name)
+	}
+	plain, err := br.key.aead.Open(nil, sealed[:n], sealed[n:], segmentData(br.name, br.n, final))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting segment %d of %s: %w", br.n, br.name, err)
+	}
+	br.n++
+	if final {
+		if _, err := br.r.Peek(1); !errors.Is(err, io.EOF) || len(plain) > 0 {
+			return nil, fmt.Errorf("%s has data after its final segment", br.name)
+		}
+		br.done = true
+		return nil, io.EOF
+	}
+	return plain, nil
+}
+
+// reencrypt brings everything in the store under the current key: records
+// and files sealed with an older key. It runs in the background at startup;
+// once it reports that it is done, older keys can be dropped from the key
+// file.
+func (s *Server) reencrypt() {
+	start := time.Now()
+	count, err := s.store.reseal()
+	if err != nil {
+		log.Printf("Error re-encrypting the data store after %d files: %v", count, err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Re-encrypted %d files with key %s in %s", count, s.store.keys.current.id, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// reseal rewrites every file of the store not entirely sealed with the
+// current key and returns how many it rewrote.
+func (st *Store) reseal() (int, error) {
+	count := 0
+	err := filepath.WalkDir(st.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(st.dir, path)
+		if err != nil {
+			return err
+		}
+		var changed bool
+		switch {
+		case strings.HasSuffix(name, ".tmp"), strings.HasPrefix(d.Name(), strings.TrimSuffix(uploadPattern, "*")):
+			return nil // being written
+		case filepath.Dir(name) == filesDir:
+			changed, err = st.resealBlob(name)
+		case filepath.Ext(name) == historyExt:
+			changed, err = st.resealLines(name)
+		case filepath.Ext(name) == ".json":
+			changed, err = st.resealFile(name)
+		default:
+			return nil
+		}
+		if changed {
+			count++
+		}
+		return err
+	})
+	return count, err
+}
+
+// resealFile re-encrypts a JSON file of the store if needed.
+func (st *Store) resealFile(name string) (bool, error) {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	path := filepath.Join(st.dir, name)
+	record, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", name, err)
+	}
+	record = bytes.TrimSpace(record)
+	if st.keys.isCurrent(record) {
+		return false, nil
+	}
+	plain, err := st.keys.open(name, record)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	sealed, err := st.keys.seal(name, plain)
+	if err != nil {
+		return false, err
+	}
+	return true, st.write(name, append(sealed, '\n'))
+}
+
+// resealLines re-encrypts the lines of a JSON Lines file of the store if
+// needed. It holds st.mux throughout, so no line is appended meanwhile.
+func (st *Store) resealLines(name string) (bool, error) {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	stale := false
+	var lines [][]byte
+	err := st.scanLines(name, func(record []byte) error {
+		stale = stale || !st.keys.isCurrent(record)
+		plain, err := st.keys.open(name, record)
+		lines = append(lines, plain)
+		return err
+	})
+	if err != nil || !stale {
+		return false, err
+	}
+	data, err := st.keys.sealLines(name, lines)
+	if err != nil {
+		return false, err
+	}
+	return true, st.write(name, data)
+}
+
+// resealBlob re-encrypts a shared file if needed. Shared files are named by
+// their content and never change, so it needs no lock.
+func (st *Store) resealBlob(name string) (changed bool, err error) {
+	path := filepath.Join(st.dir, name)
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	br, err := st.keys.newBlobReader(in, filepath.ToSlash(name))
+	if err != nil {
+		return false, err
+	}
+	if br.key == st.keys.current {
+		return false, nil
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), uploadPattern)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	bw, err := st.keys.newBlobWriter(out, filepath.ToSlash(name))
+	if err != nil {
+		return false, err
+	}
+	for {
+		chunk, err := br.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := bw.Write(chunk); err != nil {
+			return false, err
+		}
+	}
+	if err := bw.Close(); err != nil {
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(out.Name(), path)
+}
+
+// runEncrypt implements "server encrypt": it encrypts data written before
+// encryption was enabled, once, and re-encrypts everything else with the
+// current key. The server must not be running.
+func runEncrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	dataDir := flags.String("data", "data", "directory for persistent state")
+	keyFile := flags.String("key-file", "", "file with the storage keys, current key first (default $CHAT_STORAGE_KEY)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	keys, err := loadKeyring(*keyFile)
+	if err != nil {
+		return err
+	}
+	keys.plaintext = true
+	if _, err := os.Stat(*dataDir); err != nil {
+		return err
+	}
+	store := &Store{dir: *dataDir, keys: keys}
+	count, err := store.reseal()
+	if err != nil {
+		return fmt.Errorf("after %d files: %w", count, err)
+	}
+	fmt.Printf("Encrypted %d files with key %s\n", count, keys.current.id)
+	return nil
+}
+
+// runKeygen implements "server keygen": it prints a new storage key.
+func runKeygen() error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyring returns a keyring of n new keys, the first current.
+func testKeyring(t *testing.T, n int) *keyring {
+	t.Helper()
+	var keys []string
+	for range n {
+		key, err := newKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	path := filepath.Join(t.TempDir(), "storage.key")
+	if err := os.WriteFile(path, []byte(strings.Join(keys, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := testKeyring(t, 1)
+	record, err := k.seal("accounts.json", []byte(`{"alice":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(record, []byte(recordPrefix+k.current.id+":")) || !k.isCurrent(record) {
+		t.Fatalf("record %q does not name the current key", record)
+	}
+	plain, err := k.open("accounts.json", record)
+	if err != nil || string(plain) != `{"alice":{}}` {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+
+	if _, err := k.open("files.json", record); err == nil {
+		t.Error("a record opened as part of another file")
+	}
+	tampered := bytes.Clone(record)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := k.open("accounts.json", tampered); err == nil {
+		t.Error("a tampered record opened")
+	}
+	if _, err := testKeyring(t, 1).open("accounts.json", record); err == nil {
+		t.Error("a record opened without its key")
+	}
+}
+
+func TestOpenOlderKey(t *testing.T) {
+	old := testKeyring(t, 1)
+	record, err := old.seal("rooms.json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old key moves to the second line when a new one is added
+	k := testKeyring(t, 1)
+	k.keys[old.current.id] = old.current
+	if k.isCurrent(record) {
+		t.Error("a record under the old key counts as current")
+	}
+	if plain, err := k.open("rooms.json", record); err != nil || string(plain) != "{}" {
+		t.Errorf("open = %q, %v", plain, err)
+	}
+}
+
+func TestPlaintextOnlyWhileMigrating(t *testing.T) {
+	k := testKeyring(t, 1)
+	if _, err := k.open("accounts.json", []byte("{}")); !errors.Is(err, errPlaintext) {
+		t.Errorf("open of a plaintext record: %v, want errPlaintext", err)
+	}
+	if _, err := k.newBlobReader(strings.NewReader("hello"), "files/x"); !errors.Is(err, errPlaintext) {
+		t.Errorf("reading a plaintext file: %v, want errPlaintext", err)
+	}
+
+	k.plaintext = true
+	if plain, err := k.open("accounts.json", []byte("{}")); err != nil || string(plain) != "{}" {
+		t.Errorf("open while migrating = %q, %v", plain, err)
+	}
+	br, err := k.newBlobReader(strings.NewReader("hello"), "files/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readBlob(br); err != nil || got != "hello" {
+		t.Errorf("reading a plaintext file while migrating = %q, %v", got, err)
+	}
+}
+
+// writeBlob encrypts segments as one file and returns it with the offsets
+// at which each segment ends.
+func writeBlob(t *testing.T, k *keyring, name string, segments ...string) ([]byte, []int) {
+	t.Helper()
+	var buf bytes.Buffer
+	bw, err := k.newBlobWriter(&buf, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ends := []int{buf.Len()}
+	for _, segment := range segments {
+		if _, err := bw.Write([]byte(segment)); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), ends
+}
+
+// readBlob returns all of a file's content.
+func readBlob(br *blobReader) (string, error) {
+	var content []byte
+	for {
+		chunk, err := br.next()
+		content = append(content, chunk...)
+		if errors.Is(err, io.EOF) {
+			return string(content), nil
+		}
+		if err != nil {
+			return string(content), err
+		}
+	}
+}
+
+func TestBlob(t *testing.T) {
+	k := testKeyring(t, 1)
+	blob, ends := writeBlob(t, k, "files/x", "hello ", "world")
+	header, first, second := ends[0], ends[1], ends[2]
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "whole", file: "files/x", data: blob, want: "hello world"},
+		{name: "under another name", file: "files/y", data: blob, wantErr: true},
+		{name: "without final segment", file: "files/x", data: blob[:second], wantErr: true},
+		{name: "without last segments", file: "files/x", data: blob[:first], wantErr: true},
+		{name: "cut inside a segment", file: "files/x", data: blob[:second-3], wantErr: true},
+		{name: "segment dropped", file: "files/x", data: append(bytes.Clone(blob[:header]), blob[first:]...), wantErr: true},
+		{name: "segments swapped", file: "files/x", data: concat(blob[:header], blob[first:second], blob[header:first], blob[second:]), wantErr: true},
+		{name: "data after the end", file: "files/x", data: append(bytes.Clone(blob), 0, 0, 0, 0), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br, err := k.newBlobReader(bytes.NewReader(tt.data), tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readBlob(br)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("read %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyBlob(t *testing.T) {
+	k := testKeyring(t, 1)
+	blob, _ := writeBlob(t, k, "files/empty")
+	br, err := k.newBlobReader(bytes.NewReader(blob), "files/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readBlob(br); err != nil || got != "" {
+		t.Errorf("read %q, %v; want nothing", got, err)
+	}
+}
+
+func TestBlobUnknownKey(t *testing.T) {
+	blob, _ := writeBlob(t, testKeyring(t, 1), "files/x", "hello")
+	if _, err := testKeyring(t, 1).newBlobReader(bytes.NewReader(blob), "files/x"); err == nil {
+		t.Error("a file opened without its key")
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// exportDateLayout is the format of the -from and -to days of an export.
+const exportDateLayout = "2006-01-02"
+
+// exportOptions says what "server export" writes.
+type exportOptions struct {
+	room      string
+	format    string
+	from, to  time.Time // zero for an open range
+	anonymize bool
+	omitIDs   bool
+}
+
+// runExport implements "server export": it writes the stored history of a
+// room or conversation as JSON Lines, Markdown or a standalone HTML page. It
+// only reads the data directory, so it can run next to a live server.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	dataDir := flags.String("data", "data", "directory for persistent state")
+	keyFile := flags.String("key-file", "", "file with the storage keys (default $CHAT_STORAGE_KEY)")
+	room := flags.String("room", defaultRoom, "room or conversation ID to export")
+	format := flags.String("format", "md", "output format: jsonl, md or html")
+	from := flags.String("from", "", "only messages sent on or after this day (YYYY-MM-DD)")
+	to := flags.String("to", "", "only messages sent on or before this day (YYYY-MM-DD)")
+	anonymize := flags.Bool("anonymize", false, "replace usernames with user1, user2, … (also in @mentions and reactions)")
+	omitIDs := flags.Bool("omit-ids", false, "leave out message and file IDs")
+	output := flags.String("o", "", "write to this file instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := exportOptions{room: strings.TrimPrefix(*room, "#"), format: *format, anonymize: *anonymize, omitIDs: *omitIDs}
+	switch opts.format {
+	case "jsonl", "md", "html":
+	default:
+		return fmt.Errorf("unknown format %q: use jsonl, md or html", opts.format)
+	}
+	var err error
+	if opts.from, err = parseExportDay(*from); err != nil {
+		return err
+	}
+	if opts.to, err = parseExportDay(*to); err != nil {
+		return err
+	}
+	if !opts.to.IsZero() {
+		opts.to = opts.to.AddDate(0, 0, 1)
+	}
+
+	keys, err := loadKeyring(*keyFile)
+	if err != nil {
+		return err
+	}
+	// Open the store directly: NewStore would create a mistyped directory
+	store := &Store{dir: *dataDir, keys: keys}
+	if _, err := os.Stat(store.path(historyFile(opts.room))); err != nil {
+		return fmt.Errorf("no history for %s in %s", opts.room, *dataDir)
+	}
+	events, err := readHistory(store, opts.room)
+	if err != nil {
+		return err
+	}
+	var members []string
+	if isConversation(opts.room) {
+		conversations := make(map[string]*Conversation)
+		if err := store.load(conversationsFile, &conversations); err != nil {
+			return err
+		}
+		if conv, ok := conversations[opts.room]; ok {
+			members = conv.Members
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeExport(bw, opts, events, members); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// parseExportDay parses a -from or -to day in the local time zone. An empty
+// day leaves the range open.
+func parseExportDay(day string) (time.Time, error) {
+	if day == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(exportDateLayout, day, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid day %q: use YYYY-MM-DD", day)
+	}
+	return t, nil
+}
+
+// writeExport writes the messages of events that opts selects to w.
+func writeExport(w io.Writer, opts exportOptions, events []Event, members []string) error {
+	var selected []Event
+	for _, ev := range events {
+		if ev.Deleted || (!opts.from.IsZero() && ev.Time.Before(opts.from)) || (!opts.to.IsZero() && !ev.Time.Before(opts.to)) {
+			continue
+		}
+		selected = append(selected, ev)
+	}
+
+	// Thread before the IDs go
+	order, depth := threaded(selected)
+	a := &anonymizer{aliases: make(map[string]string)}
+	members = slices.Clone(members)
+	for i := range selected {
+		if opts.anonymize {
+			a.event(&selected[i])
+		}
+		if opts.omitIDs {
+			selected[i].ID, selected[i].Thread = "", ""
+			if f := selected[i].File; f != nil {
+				info := *f
+				info.ID = ""
+				selected[i].File = &info
+			}
+		}
+	}
+	if opts.anonymize {
+		for i, name := range members {
+			members[i] = a.alias(name)
+		}
+		slices.Sort(members)
+	}
+
+	title := "#" + opts.room
+	if len(members) > 0 {
+		title = "Conversation with " + strings.Join(members, ", ")
+	}
+	switch opts.format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, ev := range selected {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	lines := make([]exportLine, len(order))
+	for i, n := range order {
+		lines[i] = exportLine{Event: selected[n], reply: depth[i] > 0}
+	}
+	if opts.format == "html" {
+		return exportHTML(w, title, lines)
+	}
+	return exportMarkdown(w, title, lines)
+}
+
+// exportLine is a message in the order it is exported, below its thread root
+// if it is a reply.
+type exportLine struct {
+	Event
+	reply bool
+}
+
+// threaded returns the positions of events in an order where each thread's
+// replies follow their root. depth is 1 for those replies; replies whose root
+// is not among events stay where they are.
+func threaded(events []Event) (order []int, depth []int) {
+	replies := make(map[string][]int)
+	roots := make(map[string]bool)
+	for _, ev := range events {
+		roots[ev.ID] = ev.Thread == ""
+	}
+	for i, ev := range events {
+		if ev.Thread != "" && roots[ev.Thread] {
+			replies[ev.Thread] = append(replies[ev.Thread], i)
+		}
+	}
+	for i, ev := range events {
+		if ev.Thread != "" && roots[ev.Thread] {
+			continue
+		}
+		order, depth = append(order, i), append(depth, 0)
+		for _, reply := range replies[ev.ID] {
+			order, depth = append(order, reply), append(depth, 1)
+		}
+	}
+	return order, depth
+}
+
+// exportMarkdown writes lines as a Markdown document with a heading per day.
+func exportMarkdown(w io.Writer, title string, lines []exportLine) error {
+	fmt.Fprintf(w, "# %s\n", escapeMarkdown(title))
+	day := ""
+	for _, line := range lines {
+		ev := line.Event
+		if d := ev.Time.Local().Format(exportDateLayout); d != day && !line.reply {
+			day = d
+			fmt.Fprintf(w, "\n## %s\n\n", day)
+		}
+		indent := ""
+		if line.reply {
+			indent = "  "
+		}
+		fmt.Fprintf(w, "%s- %s\n", indent, markdownLine(line))
+		if ev.Code {
+			fence := "```"
+			for strings.Contains(ev.Text, fence) {
+				fence += "`"
+			}
+			fmt.Fprintf(w, "\n%s  %s%s\n", indent, fence, ev.Lang)
+			for _, code := range strings.Split(ev.Text, "\n") {
+				fmt.Fprintf(w, "%s  %s\n", indent, code)
+			}
+			fmt.Fprintf(w, "%s  %s\n\n", indent, fence)
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(w, "\n_No messages._")
+	}
+	return nil
+}
+
+// markdownLine renders the first line of a message in a Markdown list.
+func markdownLine(line exportLine) string {
+	ev := line.Event
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s **%s**", ev.Time.Local().Format("15:04"), escapeMarkdown(ev.From))
+	if ev.Thread != "" && !line.reply {
+		// A reply whose thread started before the export
+		b.WriteString(" ↳")
+	}
+	switch {
+	case ev.File != nil:
+		fmt.Fprintf(&b, ": 📎 %s (%s)", escapeMarkdown(ev.File.Name), formatSize(ev.File.Size))
+	case ev.Code:
+		b.WriteString(": code snippet")
+	case ev.Action:
+		fmt.Fprintf(&b, " _%s_", spansMarkdown(ev.Text, ev.Spans))
+	default:
+		fmt.Fprintf(&b, ": %s", spansMarkdown(ev.Text, ev.Spans))
+	}
+	if ev.Edited {
+		b.WriteString(" _(edited)_")
+	}
+	for _, emoji := range sortedKeys(ev.Reactions) {
+		fmt.Fprintf(&b, " · %s %d", emoji, len(ev.Reactions[emoji]))
+	}
+	if ev.ID != "" {
+		fmt.Fprintf(&b, " <sub>%s</sub>", ev.ID)
+	}
+	return b.String()
+}
+
+// spansMarkdown renders text with its formatting spans as Markdown.
+func spansMarkdown(text string, spans []Span) string {
+	marks := map[string][2]string{spanBold: {"**", "**"}, spanItalic: {"_", "_"}, spanCode: {"`", "`"}}
+	var b strings.Builder
+	pos := 0
+	for _, span := range validSpans(text, spans) {
+		b.WriteString(escapeMarkdown(text[pos:span.Start]))
+		inner := text[span.Start:span.End]
+		if span.Style != spanCode {
+			inner = escapeMarkdown(inner)
+		}
+		b.WriteString(marks[span.Style][0] + inner + marks[span.Style][1])
+		pos = span.End
+	}
+	b.WriteString(escapeMarkdown(text[pos:]))
+	return b.String()
+}
+
+// escapeMarkdown keeps characters of plain text from being read as Markdown.
+func escapeMarkdown(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ReplaceAll(text, "\n", " ") {
+		if strings.ContainsRune("\\`*_[]<>|~", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// validSpans returns the spans that fit text and do not overlap, in order.
+func validSpans(text string, spans []Span) []Span {
+	var valid []Span
+	pos := 0
+	for _, span := range spans {
+		if span.Start < pos || span.End > len(text) || span.Start >= span.End {
+			continue
+		}
+		valid = append(valid, span)
+		pos = span.End
+	}
+	return valid
+}
+
+var exportPage = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #1e1e2e; }
+h2 { font-size: 1rem; color: #5a56e0; border-bottom: 1px solid #ddd; margin-top: 2rem; }
+.msg { margin: .4rem 0; }
+.reply { margin-left: 2rem; border-left: 2px solid #82aaff; padding-left: .6rem; }
+.time, .id, .meta { color: #888; font-size: .85em; }
+.from { font-weight: 600; }
+.action { font-style: italic; }
+pre { background: #f4f4f8; padding: .6rem; overflow-x: auto; }
+code { background: #f4f4f8; padding: 0 .2rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Messages}}{{if .Day}}<h2>{{.Day}}</h2>
+{{end}}<div class="msg{{if .Reply}} reply{{end}}{{if .Event.Action}} action{{end}}" {{- with .Event.ID}} id="{{.}}"{{end}}>
+<span class="time">{{.Event.Time.Local.Format "15:04"}}</span> <span class="from">{{.Event.From}}</span>{{if not .Event.Action}}:{{end}} {{.Body}}
+{{- if .Event.Edited}} <span class="meta">(edited)</span>{{end}}
+{{- range .Reactions}} <span class="meta">{{.}}</span>{{end}}
+{{- with .Event.ID}} <span class="id">{{.}}</span>{{end}}
+</div>
+{{else}}<p>No messages.</p>
+{{end}}</body>
+</html>
+`))
+
+// exportHTML writes lines as a standalone HTML page.
+func exportHTML(w io.Writer, title string, lines []exportLine) error {
+	type message struct {
+		Event     Event
+		Day       string // set on the first message of a day
+		Reply     bool
+		Body      template.HTML
+		Reactions []string
+	}
+	messages := make([]message, len(lines))
+	day := ""
+	for i, line := range lines {
+		ev := line.Event
+		msg := message{Event: ev, Reply: line.reply, Body: htmlBody(ev)}
+		if d := ev.Time.Local().Format(exportDateLayout); d != day && !line.reply {
+			day, msg.Day = d, d
+		}
+		for _, emoji := range sortedKeys(ev.Reactions) {
+			msg.Reactions = append(msg.Reactions, fmt.Sprintf("%s %d", emoji, len(ev.Reactions[emoji])))
+		}
+		messages[i] = msg
+	}
+	return exportPage.Execute(w, struct {
+		Title    string
+		Messages []message
+	}{title, messages})
+}
+
+// htmlBody renders the text, snippet or file card of a message as HTML.
+func htmlBody(ev Event) template.HTML {
+	esc := template.HTMLEscapeString
+	switch {
+	case ev.File != nil:
+		return template.HTML(fmt.Sprintf("📎 %s <span class=\"meta\">(%s)</span>", esc(ev.File.Name), formatSize(ev.File.Size)))
+	case ev.Code:
+		class := ""
+		if ev.Lang != "" {
+			class = fmt.Sprintf(" class=\"language-%s\"", esc(ev.Lang))
+		}
+		return template.HTML(fmt.Sprintf("<pre><code%s>%s</code></pre>", class, esc(ev.Text)))
+	}
+	tags := map[string]string{spanBold: "strong", spanItalic: "em", spanCode: "code"}
+	var b strings.Builder
+	pos := 0
+	for _, span := range validSpans(ev.Text, ev.Spans) {
+		b.WriteString(esc(ev.Text[pos:span.Start]))
+		fmt.Fprintf(&b, "<%s>%s</%[1]s>", tags[span.Style], esc(ev.Text[span.Start:span.End]))
+		pos = span.End
+	}
+	b.WriteString(esc(ev.Text[pos:]))
+	return template.HTML(b.String())
+}
+
+// sortedKeys returns the emoji of a reaction set in a stable order.
+func sortedKeys(reactions map[string][]string) []string {
+	keys := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		keys = append(keys, emoji)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// anonymizer replaces usernames with stable aliases, user1, user2 and so on,
+// in the order they first appear.
+type anonymizer struct {
+	aliases map[string]string
+}
+
+func (a *anonymizer) alias(name string) string {
+	if name == "" {
+		return ""
+	}
+	alias, ok := a.aliases[name]
+	if !ok {
+		alias = fmt.Sprintf("user%d", len(a.aliases)+1)
+		a.aliases[name] = alias
+	}
+	return alias
+}
+
+// event anonymizes the author, recipient, mentions and reactions of ev. Names
+// written without an @ are left as they are.
+func (a *anonymizer) event(ev *Event) {
+	ev.From = a.alias(ev.From)
+	ev.To = a.alias(ev.To)
+	if !ev.Code {
+		for _, name := range ev.Mentions {
+			a.replaceMention(ev, name)
+		}
+	}
+	for i, name := range ev.Mentions {
+		ev.Mentions[i] = a.alias(name)
+	}
+	if len(ev.Reactions) > 0 {
+		reactions := make(map[string][]string, len(ev.Reactions))
+		for emoji, users := range ev.Reactions {
+			for _, user := range users {
+				reactions[emoji] = append(reactions[emoji], a.alias(user))
+			}
+		}
+		ev.Reactions = reactions
+	}
+}
+
+// replaceMention rewrites every @name in ev's text to the alias of name,
+// moving the formatting spans along.
+func (a *anonymizer) replaceMention(ev *Event, name string) {
+	old, repl := "@"+name, "@"+a.alias(name)
+	for i := 0; ; {
+		j := strings.Index(ev.Text[i:], old)
+		if j < 0 {
+			return
+		}
+		at, end := i+j, i+j+len(old)
+		if next, _ := utf8.DecodeRuneInString(ev.Text[end:]); unicode.IsLetter(next) || unicode.IsNumber(next) || next == '-' || next == '_' {
+			// Part of a longer name
+			i = end
+			continue
+		}
+		ev.Text = ev.Text[:at] + repl + ev.Text[end:]
+		delta := len(repl) - len(old)
+		for k := range ev.Spans {
+			if ev.Spans[k].Start > at {
+				ev.Spans[k].Start += delta
+			}
+			if ev.Spans[k].End > at {
+				ev.Spans[k].End += delta
+			}
+		}
+		i = at + len(repl)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThreaded(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []Event
+		wantOrder []int
+		wantDepth []int
+	}{
+		{"no threads", []Event{{ID: "a"}, {ID: "b"}}, []int{0, 1}, []int{0, 0}},
+		{"reply follows root", []Event{{ID: "a"}, {ID: "b"}, {ID: "c", Thread: "a"}}, []int{0, 2, 1}, []int{0, 1, 0}},
+		{"root not exported", []Event{{ID: "b"}, {ID: "c", Thread: "a"}}, []int{0, 1}, []int{0, 0}},
+	}
+	for _, tt := range tests {
+		order, depth := threaded(tt.events)
+		if !slices.Equal(order, tt.wantOrder) || !slices.Equal(depth, tt.wantDepth) {
+			t.Errorf("%s: threaded = %v, %v; want %v, %v", tt.name, order, depth, tt.wantOrder, tt.wantDepth)
+		}
+	}
+}
+
+func TestAnonymizer(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   Event
+		want Event
+	}{
+		{
+			name: "author and mention",
+			ev:   Event{From: "alice", Text: "hi @bob and @bobby", Mentions: []string{"bob"}},
+			want: Event{From: "user1", Text: "hi @user2 and @bobby", Mentions: []string{"user2"}},
+		},
+		{
+			name: "spans move with the text",
+			ev:   Event{From: "alice", Text: "@bob **yes**", Mentions: []string{"bob"}, Spans: []Span{{Start: 5, End: 8, Style: spanBold}}},
+			want: Event{From: "user1", Text: "@user2 **yes**", Mentions: []string{"user2"}, Spans: []Span{{Start: 7, End: 10, Style: spanBold}}},
+		},
+		{
+			name: "code is left alone",
+			ev:   Event{From: "bob", Text: "@alice", Code: true, Mentions: []string{"alice"}},
+			want: Event{From: "user2", Text: "@alice", Code: true, Mentions: []string{"user1"}},
+		},
+	}
+	a := &anonymizer{aliases: map[string]string{"alice": "user1", "bob": "user2"}}
+	for _, tt := range tests {
+		ev := tt.ev
+		ev.Mentions = slices.Clone(ev.Mentions)
+		a.event(&ev)
+		if ev.From != tt.want.From || ev.Text != tt.want.Text || !slices.Equal(ev.Mentions, tt.want.Mentions) || !slices.Equal(ev.Spans, tt.want.Spans) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, ev, tt.want)
+		}
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	day := time.Date(2026, 1, 2, 12, 0, 0, 0, time.Local)
+	events := []Event{
+		{ID: "a1", From: "alice", Text: "first", Time: day.AddDate(0, 0, -1)},
+		{ID: "b2", From: "bob", Text: "second *star*", Time: day},
+		{ID: "c3", From: "bob", Text: "gone", Deleted: true, Time: day},
+		{ID: "d4", From: "alice", Text: "last", Time: day.AddDate(0, 0, 1)},
+	}
+	tests := []struct {
+		name    string
+		opts    exportOptions
+		want    []string
+		wantNot []string
+	}{
+		{"markdown", exportOptions{room: "lobby", format: "md"}, []string{"# #lobby", "first", `second \*star\*`, "last"}, []string{"gone"}},
+		{"day range", exportOptions{room: "lobby", format: "md", from: day.Truncate(24 * time.Hour), to: day.AddDate(0, 0, 1).Truncate(24 * time.Hour)}, []string{"second"}, []string{"first", "last"}},
+		{"jsonl without ids", exportOptions{room: "lobby", format: "jsonl", omitIDs: true}, []string{`"text":"first"`}, []string{"a1", "b2"}},
+		{"anonymized html", exportOptions{room: "lobby", format: "html", anonymize: true}, []string{"<html", "user1", "user2"}, []string{"alice", "bob"}},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := writeExport(&b, tt.opts, slices.Clone(events), nil); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s: output lacks %q:\n%s", tt.name, s, b.String())
+			}
+		}
+		for _, s := range tt.wantNot {
+			if strings.Contains(b.String(), s) {
+				t.Errorf("%s: output has %q:\n%s", tt.name, s, b.String())
+			}
+		}
+	}
+}
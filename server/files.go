@@ -0,0 +1,393 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	filesFile = "files.json"
+	// filesDir holds the content of shared files, named by their SHA-256, so
+	// a file sent twice is only stored once.
+	filesDir = "files"
+	// fileChunkSize is the most file data carried by one binary frame.
+	fileChunkSize = 32 * 1024
+	// uploadPattern names the temporary files of uploads in progress.
+	uploadPattern = "upload-*"
+)
+
+// FileInfo describes a shared file on the card shown to its recipients.
+type FileInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"` // hex SHA-256 of the content
+}
+
+// StoredFile is a shared file and who may download it.
+type StoredFile struct {
+	FileInfo
+	Owner    string    `json:"owner"`
+	Room     string    `json:"room,omitempty"` // set for files sent to a room
+	To       string    `json:"to,omitempty"`   // set for files sent to a user
+	Uploaded time.Time `json:"uploaded"`
+
+	// The connections that sent and received the file, which may download it
+	// without a registered name; neither is kept across restarts.
+	sender    *Client
+	recipient *Client
+}
+
+// upload is a file being received from a client. A connection sends one file
+// at a time, so every binary frame it sends belongs to its current upload.
+type upload struct {
+	clientID string
+	target   string
+	info     FileInfo
+	tmp      *os.File
+	content  *blobWriter // encrypts what is written to tmp
+	hash     hash.Hash
+	received int64
+}
+
+// loadFiles reads the shared files' metadata from the store, and removes
+// uploads left unfinished when the server last stopped.
+func (s *Server) loadFiles() error {
+	s.filesMux.Lock()
+	defer s.filesMux.Unlock()
+	if err := os.MkdirAll(s.store.path(filesDir), 0o700); err != nil {
+		return fmt.Errorf("creating files directory: %w", err)
+	}
+	stale, err := filepath.Glob(s.store.path(filesDir, uploadPattern))
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		os.Remove(name)
+	}
+	return s.store.load(filesFile, &s.files)
+}
+
+// removeFile forgets the shared file id, so it can no longer be downloaded,
+// and deletes its content unless another file has the same content or the
+// room it was sent to is under legal hold.
+func (s *Server) removeFile(id string) {
+	s.filesMux.Lock()
+	defer s.filesMux.Unlock()
+	file, ok := s.files[id]
+	if !ok {
+		return
+	}
+	delete(s.files, id)
+	if err := s.store.save(filesFile, s.files); err != nil {
+		log.Printf("Error saving files: %v", err)
+	}
+	log.Printf("Removed %s (%s) of %s", file.Name, file.ID, file.Owner)
+
+	shared := false
+	for _, f := range s.files {
+		shared = shared || f.Hash == file.Hash
+	}
+	if shared || (file.Room != "" && s.isHeld(file.Room)) {
+		return
+	}
+	if err := os.Remove(s.store.path(filesDir, file.Hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing content of %s: %v", file.ID, err)
+	}
+}
+
+// usedBytes is how much of its file quota username has used.
+func (s *Server) usedBytes(username string) int64 {
+	s.filesMux.Lock()
+	defer s.filesMux.Unlock()
+	var used int64
+	for _, f := range s.files {
+		if f.Owner == username {
+			used += f.Size
+		}
+	}
+	return used
+}
+
+// startUpload handles "/upload <room|username> <size> <sha256> <name>": it
+// checks the file may be sent and then tells the client to start sending its
+// content as binary frames.
+func (s *Server) startUpload(client *Client, clientID, args string) {
+	if args == "cancel" {
+		s.abortUpload(client, "Upload cancelled.")
+		return
+	}
+	parts := strings.SplitN(args, " ", 4)
+	if len(parts) != 4 || strings.TrimSpace(parts[3]) == "" {
+		client.sendAckError(clientID, "Usage: /upload <room|username> <size> <sha256> <name>")
+		return
+	}
+	target, name := parts[0], filepath.Base(strings.TrimSpace(parts[3]))
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size <= 0 {
+		client.sendAckError(clientID, "Invalid file size.")
+		return
+	}
+	// The hash names the stored content and is compared with the one
+	// computed as hex, which is lowercase
+	parts[2] = strings.ToLower(parts[2])
+	sum, err := hex.DecodeString(parts[2])
+	if err != nil || len(sum) != sha256.Size {
+		client.sendAckError(clientID, "Invalid file hash.")
+		return
+	}
+
+	switch {
+	case client.name() == "":
+		client.sendAckError(clientID, "Please set a username first using /nick <username>")
+		return
+	case client.upload != nil:
+		client.sendAckError(clientID, "You are already sending a file.")
+		return
+	case size > s.config.MaxFileBytes:
+		client.sendAckError(clientID, fmt.Sprintf("Files are limited to %s.", formatSize(s.config.MaxFileBytes)))
+		return
+	case s.usedBytes(client.name())+size > s.config.FileQuota:
+		client.sendAckError(clientID, fmt.Sprintf("Sending this file would exceed your quota of %s.", formatSize(s.config.FileQuota)))
+		return
+	}
+	if room := s.room(target); room != nil {
+		if !s.canAccess(client, target) {
+			client.sendAckError(clientID, fmt.Sprintf("Conversation '%s' not found.", target))
+			return
+		}
+	} else if s.findClient(target) == nil && !s.isRegistered(target) {
+		client.sendAckError(clientID, fmt.Sprintf("User '%s' not found.", target))
+		return
+	} else if s.blocks(target, client.name()) {
+		// Refused before the upload rather than once all of it has arrived
+		client.sendAckError(clientID, fmt.Sprintf("%s is not accepting private messages from you.", target))
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.store.path(filesDir), uploadPattern)
+	if err != nil {
+		log.Printf("Error creating upload file: %v", err)
+		client.sendAckError(clientID, "Could not store the file.")
+		return
+	}
+	id, err := newMessageID()
+	if err != nil {
+		log.Printf("Error creating upload file: %v", err)
+		tmp.Close()
+		os.Remove(tmp.Name())
+		client.sendAckError(clientID, "Could not store the file.")
+		return
+	}
+	info := FileInfo{ID: id, Name: name, Size: size, Hash: parts[2]}
+	content, err := s.store.keys.newBlobWriter(tmp, blobName(info.Hash))
+	if err != nil {
+		log.Printf("Error creating upload file: %v", err)
+		tmp.Close()
+		os.Remove(tmp.Name())
+		client.sendAckError(clientID, "Could not store the file.")
+		return
+	}
+	client.upload = &upload{clientID: clientID, target: target, info: info, tmp: tmp, content: content, hash: sha256.New()}
+	log.Printf("%s is sending %s (%d bytes) to %s", client.name(), name, size, target)
+	client.send(Event{Type: eventUpload, ID: info.ID, ClientID: clientID})
+}
+
+// receiveChunk adds a binary frame to client's current upload, and finishes
+// the upload once all of the file has arrived.
+func (s *Server) receiveChunk(client *Client, data []byte) {
+	up := client.upload
+	if up == nil {
+		log.Printf("Ignoring %d bytes of file data from %s: no upload in progress", len(data), client.ip)
+		return
+	}
+	if up.received+int64(len(data)) > up.info.Size {
+		s.abortUpload(client, "The file is larger than announced.")
+		return
+	}
+	if _, err := up.content.Write(data); err != nil {
+		log.Printf("Error writing upload from %s: %v", client.ip, err)
+		s.abortUpload(client, "Could not store the file.")
+		return
+	}
+	up.hash.Write(data)
+	up.received += int64(len(data))
+	if up.received == up.info.Size {
+		s.finishUpload(client)
+	}
+}
+
+// finishUpload checks a complete upload against its hash, moves it into
+// place and shows its card to the recipients.
+func (s *Server) finishUpload(client *Client) {
+	up := client.upload
+	client.upload = nil
+	tmpName := up.tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if err := errors.Join(up.content.Close(), up.tmp.Close()); err != nil {
+		log.Printf("Error closing upload from %s: %v", client.ip, err)
+		client.sendAckError(up.clientID, "Could not store the file.")
+		return
+	}
+	if hex.EncodeToString(up.hash.Sum(nil)) != up.info.Hash {
+		client.sendAckError(up.clientID, "The file was damaged in transit. Please send it again.")
+		return
+	}
+
+	file := &StoredFile{FileInfo: up.info, Owner: client.name(), Uploaded: time.Now(), sender: client}
+	room := s.room(up.target)
+	if room != nil {
+		file.Room = room.name
+	} else {
+		file.To = up.target
+		// A registered recipient is only given the file once identified,
+		// whoever holds the name right now
+		if !s.isRegistered(file.To) {
+			file.recipient = s.findClient(file.To)
+		}
+	}
+	// The content is moved into place under filesMux, so removeFile never
+	// deletes content with the same hash before the file is listed.
+	s.filesMux.Lock()
+	if err := os.Rename(tmpName, s.store.path(filesDir, up.info.Hash)); err != nil {
+		s.filesMux.Unlock()
+		log.Printf("Error storing upload from %s: %v", client.ip, err)
+		client.sendAckError(up.clientID, "Could not store the file.")
+		return
+	}
+	s.files[file.ID] = file
+	err := s.store.save(filesFile, s.files)
+	s.filesMux.Unlock()
+	if err != nil {
+		log.Printf("Error saving files: %v", err)
+	}
+	log.Printf("Stored %s (%s) from %s", file.Name, file.Hash, client.name())
+
+	info := file.FileInfo
+	ev := Event{From: client.name(), Text: info.Name, File: &info, Time: file.Uploaded}
+	if room != nil {
+		ev.Type = eventMessage
+		if err := s.publishMessage(room, client, up.clientID, ev); err != nil {
+			log.Printf("Error publishing file card: %v", err)
+		}
+		return
+	}
+	ev.Type, ev.To = eventPM, file.To
+	s.deliverPrivateMessage(client, up.clientID, ev)
+}
+
+// abortUpload discards client's current upload, if any, and reports reason
+// to it unless reason is empty.
+func (s *Server) abortUpload(client *Client, reason string) {
+	up := client.upload
+	if up == nil {
+		return
+	}
+	client.upload = nil
+	up.tmp.Close()
+	os.Remove(up.tmp.Name())
+	log.Printf("Upload of %s from %s aborted after %d bytes", up.info.Name, client.ip, up.received)
+	if reason != "" {
+		client.sendAckError(up.clientID, reason)
+	}
+}
+
+// sendFile handles /download: it announces the file to client and then
+// writes its content as binary frames.
+func (s *Server) sendFile(client *Client, id string) {
+	s.filesMux.Lock()
+	file, ok := s.files[id]
+	s.filesMux.Unlock()
+	if !ok || !s.canDownload(client, file) {
+		client.sendSystem(fmt.Sprintf("File '%s' not found.", id))
+		return
+	}
+
+	f, err := os.Open(s.store.path(filesDir, file.Hash))
+	if err != nil {
+		log.Printf("Error opening file %s: %v", file.ID, err)
+		client.sendSystem(fmt.Sprintf("File '%s' is no longer available.", id))
+		return
+	}
+	defer f.Close()
+	content, err := s.store.keys.newBlobReader(f, blobName(file.Hash))
+	if err != nil {
+		log.Printf("Error opening file %s: %v", file.ID, err)
+		client.sendSystem(fmt.Sprintf("File '%s' is no longer available.", id))
+		return
+	}
+
+	info := file.FileInfo
+	if err := client.send(Event{Type: eventDownload, ID: info.ID, File: &info}); err != nil {
+		log.Printf("Error announcing download to %s: %v", client.ip, err)
+		return
+	}
+	for {
+		chunk, err := content.next()
+		if len(chunk) > 0 {
+			if err := client.sendBinary(chunk); err != nil {
+				log.Printf("Error sending file %s to %s: %v", file.ID, client.ip, err)
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// The client will notice the file is short and discard it.
+			log.Printf("Error reading file %s: %v", file.ID, err)
+			return
+		}
+	}
+	log.Printf("Sent %s to %s", file.Name, client.name())
+}
+
+// blobName names the content of a shared file in the store.
+func blobName(hash string) string {
+	return filesDir + "/" + hash
+}
+
+// canDownload reports whether client may fetch file: the connections that
+// sent and received it, its owner and recipient once identified under their
+// registered names, and anyone who can read the room it was sent to. A bare
+// name match proves nothing, as the nickname may have been taken over.
+func (s *Server) canDownload(client *Client, file *StoredFile) bool {
+	if client.name() == "" {
+		return false
+	}
+	if file.sender == client || file.recipient == client {
+		return true
+	}
+	switch client.name() {
+	case file.Owner, file.To:
+		if s.isRegistered(client.name()) && client.isIdentified() {
+			return true
+		}
+	}
+	return file.Room != "" && s.canAccess(client, file.Room)
+}
+
+// formatSize renders a number of bytes for people, e.g. "1.5 MB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStartUploadValidation(t *testing.T) {
+	hash := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		args      string
+		wantError string
+	}{
+		{"lobby 5", "Usage: /upload <room|username> <size> <sha256> <name>"},
+		{"lobby x " + hash + " a.txt", "Invalid file size."},
+		{"lobby 0 " + hash + " a.txt", "Invalid file size."},
+		{"lobby 5 abc a.txt", "Invalid file hash."},
+		{"lobby 999999 " + hash + " a.txt", "Files are limited to 1000 B."},
+		{"nobody 5 " + hash + " a.txt", "User 'nobody' not found."},
+		{"lobby 5 " + strings.ToUpper(hash) + " a.txt", ""},
+	}
+	for _, tt := range tests {
+		s := testServer(t)
+		if err := s.loadFiles(); err != nil {
+			t.Fatal(err)
+		}
+		alice, peer := testClient(t, "alice")
+		s.startUpload(alice, "c1", tt.args)
+		ev := readEvent(t, peer)
+		if ev.Error != tt.wantError {
+			t.Errorf("/upload %s: error %q, want %q", tt.args, ev.Error, tt.wantError)
+		}
+		if tt.wantError == "" && (ev.Type != eventUpload || alice.upload.info.Hash != hash) {
+			t.Errorf("/upload %s: got %+v", tt.args, ev)
+		}
+		s.abortUpload(alice, "")
+	}
+}
+
+func TestUploadToRoom(t *testing.T) {
+	s := testServer(t)
+	if err := s.loadFiles(); err != nil {
+		t.Fatal(err)
+	}
+	alice, peer := testClient(t, "alice")
+	s.addClient(alice)
+
+	content := []byte("hello, world")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	s.startUpload(alice, "c1", "lobby 12 "+hash+" hello.txt")
+	if ev := readEvent(t, peer); ev.Type != eventUpload {
+		t.Fatalf("got %+v, want upload go-ahead", ev)
+	}
+	s.receiveChunk(alice, content[:5])
+	s.receiveChunk(alice, content[5:])
+
+	ack := readEvent(t, peer)
+	if ack.File == nil || ack.File.Hash != hash || ack.Error != "" {
+		t.Fatalf("got %+v, want file card ack", ack)
+	}
+	stored, err := os.ReadFile(s.store.path(filesDir, hash))
+	if err != nil || bytes.Contains(stored, content) {
+		t.Fatalf("stored %q, %v", stored, err)
+	}
+	r, err := s.store.keys.newBlobReader(bytes.NewReader(stored), blobName(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain []byte
+	for {
+		piece, err := r.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain = append(plain, piece...)
+	}
+	if !bytes.Equal(plain, content) {
+		t.Fatalf("decrypted %q", plain)
+	}
+
+	s.deleteMessage(alice, ack.ID)
+	if _, err := os.Stat(s.store.path(filesDir, hash)); !os.IsNotExist(err) {
+		t.Errorf("content kept after its card was deleted: %v", err)
+	}
+	if s.usedBytes("alice") != 0 {
+		t.Errorf("file still counts against the quota")
+	}
+}
+
+func TestCanDownload(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     string
+		registered bool
+		identified bool
+		sender     bool
+		want       bool
+	}{
+		{"sending connection", "alice", false, false, true, true},
+		{"owner name from another connection", "alice", false, false, false, false},
+		{"registered owner not identified", "alice", true, false, false, false},
+		{"registered owner identified", "alice", true, true, false, true},
+		{"recipient name from another connection", "bob", false, false, false, false},
+		{"registered recipient identified", "bob", true, true, false, true},
+		{"anyone else", "carol", true, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			client, _ := testClient(t, tt.client)
+			if tt.registered {
+				s.accounts[tt.client] = &Account{}
+			}
+			client.identified = tt.identified
+			file := &StoredFile{Owner: "alice", To: "bob"}
+			if tt.sender {
+				file.sender = client
+			}
+			if got := s.canDownload(client, file); got != tt.want {
+				t.Errorf("canDownload = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
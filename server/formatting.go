@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Inline formatting styles carried in Span.Style.
+const (
+	spanBold   = "bold"   // *text*
+	spanItalic = "italic" // _text_
+	spanCode   = "code"   // `text`
+)
+
+// actionPrefix marks a chat line as an action, e.g. "/me waves".
+const actionPrefix = "/me "
+
+// Span applies an inline style to Text[Start:End] of a message. Offsets are
+// byte offsets into the text with the formatting markers removed.
+type Span struct {
+	Style string `json:"style"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+var spanMarkers = map[byte]string{'*': spanBold, '_': spanItalic, '`': spanCode}
+
+// chatEvent builds the message event for a chat line from sender. A line
+// starting with "/me " becomes an action, a fenced code block becomes a
+// snippet, and inline markup is turned into spans. The ID is assigned when
+// the message is published.
+func chatEvent(sender *Client, text string) Event {
+	ev := Event{
+		Type: eventMessage,
+		From: sender.name(),
+		Time: time.Now(),
+	}
+	if action, ok := strings.CutPrefix(text, actionPrefix); ok {
+		ev.Action = true
+		text = strings.TrimSpace(action)
+	}
+	setBody(&ev, text)
+	return ev
+}
+
+// parseFormatting strips *bold*, _italic_ and `code` markup from text and
+// returns the plain text with the spans it described. A marker only opens at
+// the start of a word and only closes at the end of one, so snake_case and
+// 2*3*4 are left alone; unmatched markers are kept as they are. Spans do not
+// nest, so the markup inside one, e.g. in code, is kept literally.
+func parseFormatting(text string) (string, []Span) {
+	var (
+		out   strings.Builder
+		spans []Span
+	)
+	for i := 0; i < len(text); {
+		style, isMarker := spanMarkers[text[i]]
+		if !isMarker || !opensSpan(text, i) {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		end := closingMarker(text, i, style == spanCode)
+		if end < 0 {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		start := out.Len()
+		out.WriteString(text[i+1 : end])
+		spans = append(spans, Span{Style: style, Start: start, End: out.Len()})
+		i = end + 1
+	}
+	return out.String(), spans
+}
+
+// opensSpan reports whether the marker at i can start a span: it begins a
+// word and is followed by something other than space.
+func opensSpan(text string, i int) bool {
+	if i+1 >= len(text) || text[i+1] == text[i] {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(text[i+1:])
+	if unicode.IsSpace(next) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsSpace(prev) || unicode.IsPunct(prev)
+}
+
+// closingMarker returns the index of the marker closing the span opened at
+// start, or -1. Outside code, the closing marker must end a word.
+func closingMarker(text string, start int, code bool) int {
+	marker := text[start]
+	for j := start + 2; j < len(text); j++ {
+		if text[j] != marker {
+			continue
+		}
+		if code {
+			return j
+		}
+		prev, _ := utf8.DecodeLastRuneInString(text[:j])
+		if unicode.IsSpace(prev) {
+			continue
+		}
+		if j+1 == len(text) {
+			return j
+		}
+		next, _ := utf8.DecodeRuneInString(text[j+1:])
+		if unicode.IsSpace(next) || unicode.IsPunct(next) {
+			return j
+		}
+	}
+	return -1
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFormatting(t *testing.T) {
+	tests := []struct {
+		in    string
+		text  string
+		spans []Span
+	}{
+		{"plain text", "plain text", nil},
+		{"*bold*", "bold", []Span{{spanBold, 0, 4}}},
+		{"a _quiet_ word", "a quiet word", []Span{{spanItalic, 2, 7}}},
+		{"run `go test` now", "run go test now", []Span{{spanCode, 4, 11}}},
+		{"*one* and _two_", "one and two", []Span{{spanBold, 0, 3}, {spanItalic, 8, 11}}},
+		{"(*aside*)", "(aside)", []Span{{spanBold, 1, 6}}},
+		{"*done*.", "done.", []Span{{spanBold, 0, 4}}},
+		{"*ünïcödé*", "ünïcödé", []Span{{spanBold, 0, 11}}},
+		// Markers inside words are left alone
+		{"snake_case_name", "snake_case_name", nil},
+		{"2*3*4", "2*3*4", nil},
+		// Unmatched and empty markers are kept
+		{"*open", "*open", nil},
+		{"**", "**", nil},
+		{"* not bold*", "* not bold*", nil},
+		{"*not bold *", "*not bold *", nil},
+		{"*a * b*", "a * b", []Span{{spanBold, 0, 5}}},
+		// Code keeps markup literally and may end after a space
+		{"`*x* _y_`", "*x* _y_", []Span{{spanCode, 0, 7}}},
+		{"`a `", "a ", []Span{{spanCode, 0, 2}}},
+		// Spans do not nest
+		{"*bold _and_ more*", "bold _and_ more", []Span{{spanBold, 0, 15}}},
+	}
+	for _, tt := range tests {
+		text, spans := parseFormatting(tt.in)
+		if text != tt.text || !slices.Equal(spans, tt.spans) {
+			t.Errorf("parseFormatting(%q) = %q, %v; want %q, %v", tt.in, text, spans, tt.text, tt.spans)
+		}
+	}
+}
+
+func TestChatEvent(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantText   string
+		wantAction bool
+	}{
+		{"hello", "hello", false},
+		{"/me waves", "waves", true},
+		{"/me  *waves*  ", "waves", true},
+		{"/meow", "/meow", false},
+	}
+	for _, tt := range tests {
+		ev := chatEvent(&Client{username: "alice"}, tt.in)
+		if ev.Text != tt.wantText || ev.Action != tt.wantAction || ev.From != "alice" {
+			t.Errorf("chatEvent(%q) = %q from %s, action %v; want %q, action %v", tt.in, ev.Text, ev.From, ev.Action, tt.wantText, tt.wantAction)
+		}
+	}
+}
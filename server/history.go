@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// historyDir holds one JSON Lines log per room. A message is appended
+	// when it is published and again whenever it changes, so the newest line
+	// for an ID is its current state.
+	historyDir = "history"
+	historyExt = ".jsonl"
+)
+
+// historyFile names the log of room in the store.
+func historyFile(room string) string {
+	return filepath.Join(historyDir, room+historyExt)
+}
+
+// readHistory returns the stored messages of room in sequence order, each in
+// its latest state. Deleted messages are kept as tombstones.
+func readHistory(st *Store, room string) ([]Event, error) {
+	var events []Event
+	positions := make(map[string]int)
+	err := st.readLines(historyFile(room), func(line []byte) error {
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return err
+		}
+		if i, ok := positions[ev.ID]; ok {
+			events[i] = ev
+			return nil
+		}
+		positions[ev.ID] = len(events)
+		events = append(events, ev)
+		return nil
+	})
+	return events, err
+}
+
+// loadHistory restores the rooms kept in the store with their recent
+// messages, and indexes every stored message for /search. It must run after
+// loadConversations: the logs of conversations everyone has left are
+// ignored.
+func (s *Server) loadHistory() error {
+	if err := os.MkdirAll(s.store.path(historyDir), 0o700); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	logs, err := filepath.Glob(s.store.path(historyDir, "*"+historyExt))
+	if err != nil {
+		return err
+	}
+	for _, name := range logs {
+		roomName := strings.TrimSuffix(filepath.Base(name), historyExt)
+		if isConversation(roomName) && s.conversationMembers(roomName) == nil {
+			continue
+		}
+		events, err := readHistory(s.store, roomName)
+		if err != nil {
+			return err
+		}
+
+		s.roomsMux.Lock()
+		room, ok := s.rooms[roomName]
+		if !ok {
+			room = newRoom(roomName, s.store)
+			s.rooms[roomName] = room
+		}
+		s.roomsMux.Unlock()
+		room.restore(events)
+		for _, ev := range events {
+			if !ev.Deleted {
+				s.index.add(ev)
+			}
+		}
+	}
+	return nil
+}
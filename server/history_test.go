@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHistorySurvivesRestart(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(s *Server, alice *Client, id string)
+		wantText  string
+		wantFound bool // by /search after the restart
+	}{
+		{"published", func(*Server, *Client, string) {}, "deploy today", true},
+		{"edited", func(s *Server, alice *Client, id string) { s.editMessage(alice, id, "deploy tomorrow") }, "deploy tomorrow", true},
+		{"deleted", func(s *Server, alice *Client, id string) { s.deleteMessage(alice, id) }, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			if err := s.loadState(); err != nil {
+				t.Fatal(err)
+			}
+			alice, _ := testClient(t, "alice")
+			s.addClient(alice)
+			lobby := s.room(defaultRoom)
+			for _, text := range []string{"hello", "deploy today"} {
+				if err := s.publishMessage(lobby, alice, "", chatEvent(alice, text)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.change(s, alice, lobby.history[1].ID)
+
+			restarted := NewServer(s.config, s.store)
+			if err := restarted.loadState(); err != nil {
+				t.Fatal(err)
+			}
+			room := restarted.room(defaultRoom)
+			if room.seq != 2 || len(room.history) != 2 {
+				t.Fatalf("restored seq %d with %d messages, want 2 and 2", room.seq, len(room.history))
+			}
+			if got := room.history[1].Text; got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+			hits := restarted.index.search(searchQuery{terms: []string{"deploy"}}, []string{defaultRoom})
+			if (len(hits) == 1) != tt.wantFound {
+				t.Errorf("search found %d messages", len(hits))
+			}
+		})
+	}
+}
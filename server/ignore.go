@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Every client keeps two lists of usernames. Messages from users it /ignores
+// are not sent to it, whether posted in a public room or a conversation; users
+// it /blocks cannot send it private messages, typing notifications or
+// mentions, start a conversation with it or add it to one, and their messages
+// in conversations it already shares with them are dropped like PMs. The
+// lists are enforced here, when events fan out, and kept with the account of
+// a registered user.
+
+// isIgnoring reports whether the client hides room messages from username.
+func (c *Client) isIgnoring(username string) bool {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.ignored[username]
+}
+
+// isBlocking reports whether the client rejects PMs, typing and mentions from
+// username.
+func (c *Client) isBlocking(username string) bool {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.blocked[username]
+}
+
+// filterEvent returns ev as the client should receive it, and false if it
+// should not receive it at all: messages from users it ignores, in public
+// rooms and conversations alike, conversation messages and typing from users
+// it blocks are dropped, and a blocked user's mention of it is not flagged as
+// one. PMs have no room; blocks are checked when they are sent.
+func (c *Client) filterEvent(ev Event) (Event, bool) {
+	switch ev.Type {
+	case eventMessage:
+		if ev.Room != "" && c.isIgnoring(ev.From) {
+			return ev, false
+		}
+		if isConversation(ev.Room) && c.isBlocking(ev.From) {
+			return ev, false
+		}
+	case eventTyping, eventStopTyping:
+		if c.isBlocking(ev.From) {
+			return ev, false
+		}
+	}
+	if c.name() != "" && slices.Contains(ev.Mentions, c.name()) && c.isBlocking(ev.From) {
+		ev.Mentions = slices.DeleteFunc(slices.Clone(ev.Mentions), func(name string) bool { return name == c.name() })
+	}
+	return ev, true
+}
+
+// filterEvents applies filterEvent to a batch of messages, such as a replay.
+func (c *Client) filterEvents(events []Event) []Event {
+	filtered := make([]Event, 0, len(events))
+	for _, ev := range events {
+		if ev, ok := c.filterEvent(ev); ok {
+			filtered = append(filtered, ev)
+		}
+	}
+	return filtered
+}
+
+// blocks reports whether username blocks from: as set by its connected
+// client or, for a registered username, kept with the account. As with keys,
+// a client using a registered name without identifying does not speak for
+// its owner.
+func (s *Server) blocks(username, from string) bool {
+	registered := s.isRegistered(username)
+	if target := s.findClient(username); target != nil && (!registered || target.isIdentified()) && target.isBlocking(from) {
+		return true
+	}
+	if !registered {
+		return false
+	}
+	s.accountsMux.RLock()
+	defer s.accountsMux.RUnlock()
+	return slices.Contains(s.accounts[username].Blocks, from)
+}
+
+// isIgnoreCommand reports whether message changes an ignore or block list.
+func isIgnoreCommand(message string) bool {
+	command, _, _ := strings.Cut(message, " ")
+	return command == "/ignore" || command == "/unignore" || command == "/block" || command == "/unblock"
+}
+
+// updateIgnores handles /ignore, /unignore, /block and /unblock.
+func (s *Server) updateIgnores(client *Client, command, username string) {
+	block := command == "/block" || command == "/unblock"
+	add := command == "/ignore" || command == "/block"
+	verb := "ignore"
+	if block {
+		verb = "block"
+	}
+	if username == "" {
+		client.sendSystem(fmt.Sprintf("Usage: %s <username>", command))
+		return
+	}
+	if username == client.name() {
+		client.sendSystem(fmt.Sprintf("You cannot %s yourself.", verb))
+		return
+	}
+	if add && s.findClient(username) == nil && !s.isRegistered(username) && len(s.knownUsers([]string{username})) == 0 {
+		client.sendSystem(fmt.Sprintf("User '%s' not found.", username))
+		return
+	}
+
+	client.stateMux.Lock()
+	list := &client.ignored
+	if block {
+		list = &client.blocked
+	}
+	if *list == nil {
+		*list = make(map[string]bool)
+	}
+	changed := (*list)[username] != add
+	if add {
+		(*list)[username] = true
+	} else {
+		delete(*list, username)
+	}
+	client.stateMux.Unlock()
+
+	switch {
+	case !changed && add:
+		client.sendSystem(fmt.Sprintf("You already %s %s.", verb, username))
+		return
+	case !changed:
+		client.sendSystem(fmt.Sprintf("You do not %s %s.", verb, username))
+		return
+	case add && block:
+		client.sendSystem(fmt.Sprintf("Blocked %s: they can no longer send you private messages, files, conversation messages, typing notifications or mentions. Use /unblock %s to undo.", username, username))
+	case add:
+		client.sendSystem(fmt.Sprintf("Ignoring %s: their messages in rooms are hidden from you. Use /unignore %s to undo.", username, username))
+	case block:
+		client.sendSystem(fmt.Sprintf("Unblocked %s.", username))
+	default:
+		client.sendSystem(fmt.Sprintf("No longer ignoring %s.", username))
+	}
+	log.Printf("Client %s (%s): %s %s", client.name(), client.ip, command, username)
+	s.saveIgnores(client)
+}
+
+// sendIgnores handles /ignores: it lists whom the client ignores and blocks.
+func (s *Server) sendIgnores(client *Client) {
+	client.stateMux.Lock()
+	ignored := slices.Sorted(maps.Keys(client.ignored))
+	blocked := slices.Sorted(maps.Keys(client.blocked))
+	client.stateMux.Unlock()
+
+	if len(ignored) == 0 && len(blocked) == 0 {
+		client.sendSystem("You are not ignoring or blocking anyone.")
+		return
+	}
+	var parts []string
+	if len(ignored) > 0 {
+		parts = append(parts, "Ignoring: "+strings.Join(ignored, ", "))
+	}
+	if len(blocked) > 0 {
+		parts = append(parts, "Blocking: "+strings.Join(blocked, ", "))
+	}
+	client.sendSystem(strings.Join(parts, " · "))
+}
+
+// saveIgnores keeps the lists of an identified client with its account.
+func (s *Server) saveIgnores(client *Client) {
+	if !client.isIdentified() {
+		return
+	}
+	client.stateMux.Lock()
+	ignored := slices.Sorted(maps.Keys(client.ignored))
+	blocked := slices.Sorted(maps.Keys(client.blocked))
+	client.stateMux.Unlock()
+
+	s.accountsMux.Lock()
+	defer s.accountsMux.Unlock()
+	account, ok := s.accounts[client.name()]
+	if !ok || (slices.Equal(account.Ignores, ignored) && slices.Equal(account.Blocks, blocked)) {
+		return
+	}
+	account.Ignores, account.Blocks = ignored, blocked
+	if err := s.store.save(accountsFile, s.accounts); err != nil {
+		log.Printf("Error saving accounts: %v", err)
+	}
+}
+
+// restoreIgnores adds the lists kept with the account of a client that just
+// identified to those it built up before, and saves the result.
+func (s *Server) restoreIgnores(client *Client) {
+	s.accountsMux.RLock()
+	account := s.accounts[client.name()]
+	ignored, blocked := account.Ignores, account.Blocks
+	s.accountsMux.RUnlock()
+
+	client.stateMux.Lock()
+	if client.ignored == nil {
+		client.ignored = make(map[string]bool)
+	}
+	if client.blocked == nil {
+		client.blocked = make(map[string]bool)
+	}
+	for _, username := range ignored {
+		client.ignored[username] = true
+	}
+	for _, username := range blocked {
+		client.blocked[username] = true
+	}
+	client.stateMux.Unlock()
+	s.saveIgnores(client)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFilterEvent(t *testing.T) {
+	alice := &Client{
+		username: "alice",
+		ignored:  map[string]bool{"mallory": true},
+		blocked:  map[string]bool{"trudy": true},
+	}
+	tests := []struct {
+		name         string
+		ev           Event
+		wantOK       bool
+		wantMentions []string
+	}{
+		{"ignored in a room", Event{Type: eventMessage, Room: defaultRoom, From: "mallory"}, false, nil},
+		{"ignored in a conversation", Event{Type: eventMessage, Room: conversationPrefix + "x", From: "mallory"}, false, nil},
+		{"blocked in a room", Event{Type: eventMessage, Room: defaultRoom, From: "trudy"}, true, nil},
+		{"blocked in a conversation", Event{Type: eventMessage, Room: conversationPrefix + "x", From: "trudy"}, false, nil},
+		{"blocked typing", Event{Type: eventTyping, Room: defaultRoom, From: "trudy"}, false, nil},
+		{"blocked mention", Event{Type: eventMessage, Room: defaultRoom, From: "trudy", Mentions: []string{"alice", "bob"}}, true, []string{"bob"}},
+		{"mention", Event{Type: eventMessage, Room: defaultRoom, From: "bob", Mentions: []string{"alice"}}, true, []string{"alice"}},
+		{"PM from ignored user", Event{Type: eventPM, From: "mallory"}, true, nil},
+	}
+	for _, tt := range tests {
+		got, ok := alice.filterEvent(tt.ev)
+		if ok != tt.wantOK {
+			t.Errorf("%s: delivered = %v, want %v", tt.name, ok, tt.wantOK)
+		}
+		if ok && !slices.Equal(got.Mentions, tt.wantMentions) {
+			t.Errorf("%s: mentions = %v, want %v", tt.name, got.Mentions, tt.wantMentions)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	s := NewServer(Config{}, nil)
+	s.accounts["ian"] = &Account{Blocks: []string{"trudy"}}
+	bob := &Client{username: "bob", blocked: map[string]bool{"trudy": true}}
+	impostor := &Client{username: "ian", blocked: map[string]bool{"alice": true}}
+	s.clients[bob] = true
+	s.clients[impostor] = true
+
+	tests := []struct {
+		username, from string
+		want           bool
+	}{
+		{"bob", "trudy", true},
+		{"bob", "alice", false},
+		{"ian", "trudy", true},  // kept with the account
+		{"ian", "alice", false}, // set by a client that has not identified
+		{"carol", "trudy", false},
+	}
+	for _, tt := range tests {
+		if got := s.blocks(tt.username, tt.from); got != tt.want {
+			t.Errorf("blocks(%q, %q) = %v, want %v", tt.username, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMessagesFiltersIgnored(t *testing.T) {
+	s := testServer(t)
+	now := time.Now()
+	for i, from := range []string{"bob", "mallory", "trudy"} {
+		s.index.add(Event{ID: from, Type: eventMessage, Room: defaultRoom, Seq: uint64(i + 1), From: from, Text: "deploy", Mentions: []string{"alice"}, Time: now})
+	}
+	alice, peer := testClient(t, "alice")
+	alice.ignored = map[string]bool{"mallory": true}
+	alice.blocked = map[string]bool{"trudy": true}
+
+	s.searchMessages(alice, "deploy")
+	ev := readEvent(t, peer)
+	mentioned := make(map[string]bool)
+	for _, result := range ev.History {
+		mentioned[result.ID] = slices.Contains(result.Mentions, "alice")
+	}
+	want := map[string]bool{"bob": true, "trudy": false}
+	if ev.Type != eventSearch || len(mentioned) != len(want) || mentioned["bob"] != want["bob"] || mentioned["trudy"] != want["trudy"] {
+		t.Errorf("search results %v (mentions alice), want %v", mentioned, want)
+	}
+}
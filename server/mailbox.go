@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const mailboxesFile = "mailboxes.json"
+
+// loadMailboxes reads the undelivered private messages from the store.
+func (s *Server) loadMailboxes() error {
+	s.mailboxMux.Lock()
+	defer s.mailboxMux.Unlock()
+	return s.store.load(mailboxesFile, &s.mailboxes)
+}
+
+// queueOffline stores the private message ev for a registered user who is
+// not connected, or not identified, subject to the per-user mailbox quota.
+func (s *Server) queueOffline(sender *Client, clientID string, ev Event) {
+	targetUsername := ev.To
+	s.mailboxMux.Lock()
+	if len(s.mailboxes[targetUsername]) >= s.config.MailboxQuota {
+		s.mailboxMux.Unlock()
+		sender.sendAckError(clientID, fmt.Sprintf("%s is offline and their mailbox is full.", targetUsername))
+		return
+	}
+	s.mailboxes[targetUsername] = append(s.mailboxes[targetUsername], ev)
+	err := s.store.save(mailboxesFile, s.mailboxes)
+	s.mailboxMux.Unlock()
+	if err != nil {
+		log.Printf("Error saving mailboxes: %v", err)
+	}
+
+	ack := ev
+	ack.Type = eventAck
+	ack.ClientID = clientID
+	if err := sender.send(ack); err != nil {
+		log.Printf("Error sending PM confirmation to sender %s: %v", sender.ip, err)
+	}
+	sender.sendSystem(fmt.Sprintf("%s is offline. Your message will be delivered later.", targetUsername))
+	log.Printf("PM %s from %s to %s queued for later delivery.", ev.ID, sender.name(), targetUsername)
+}
+
+// deliverMailbox sends an identified client the private messages queued for
+// it and empties its mailbox.
+func (s *Server) deliverMailbox(client *Client) {
+	s.mailboxMux.Lock()
+	queued := s.mailboxes[client.name()]
+	if len(queued) == 0 {
+		s.mailboxMux.Unlock()
+		return
+	}
+	delete(s.mailboxes, client.name())
+	err := s.store.save(mailboxesFile, s.mailboxes)
+	s.mailboxMux.Unlock()
+	if err != nil {
+		log.Printf("Error saving mailboxes: %v", err)
+	}
+
+	client.sendSystem(fmt.Sprintf("You have %d private messages received while you were offline:", len(queued)))
+	for _, ev := range queued {
+		if err := client.send(ev); err != nil {
+			log.Printf("Error delivering queued PM %s to %s: %v", ev.ID, client.ip, err)
+		}
+	}
+	log.Printf("Delivered %d queued PMs to %s", len(queued), client.name())
+}
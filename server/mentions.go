@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// mentionInboxLimit bounds how many mentions are kept per user.
+const mentionInboxLimit = 100
+
+// parseMentions returns the distinct usernames mentioned as @username in
+// text, in order of appearance.
+func parseMentions(text string) []string {
+	var mentions []string
+	for _, word := range strings.Fields(text) {
+		name, ok := strings.CutPrefix(word, "@")
+		if !ok {
+			continue
+		}
+		name = strings.TrimRightFunc(name, func(r rune) bool {
+			return unicode.IsPunct(r) && r != '-' && r != '_'
+		})
+		if name != "" && !slices.Contains(mentions, name) {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// knownUsers filters names down to users that are connected or have been
+// seen before, so that stray @words don't fill anyone's inbox.
+func (s *Server) knownUsers(names []string) []string {
+	var known []string
+	for _, name := range names {
+		if s.findClient(name) != nil {
+			known = append(known, name)
+			continue
+		}
+		s.seenMux.RLock()
+		_, seen := s.seen[name]
+		s.seenMux.RUnlock()
+		if seen {
+			known = append(known, name)
+		}
+	}
+	return known
+}
+
+// recordMentions files ev in the inbox of every user it mentions, unless
+// they block its author.
+func (s *Server) recordMentions(ev Event) {
+	var names []string
+	for _, name := range ev.Mentions {
+		if name != ev.From && !s.blocks(name, ev.From) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	s.mentionsMux.Lock()
+	defer s.mentionsMux.Unlock()
+	for _, name := range names {
+		inbox := append(s.mentions[name], ev)
+		if len(inbox) > mentionInboxLimit {
+			inbox = inbox[len(inbox)-mentionInboxLimit:]
+		}
+		s.mentions[name] = inbox
+	}
+}
+
+// refreshMentions updates the inbox copies of msg after it was edited, or
+// drops them once it was deleted.
+func (s *Server) refreshMentions(msg Event) {
+	s.mentionsMux.Lock()
+	defer s.mentionsMux.Unlock()
+	for _, name := range msg.Mentions {
+		inbox := s.mentions[name]
+		i := slices.IndexFunc(inbox, func(ev Event) bool { return ev.ID == msg.ID })
+		switch {
+		case i < 0:
+		case msg.Deleted:
+			s.mentions[name] = slices.Delete(inbox, i, i+1)
+		default:
+			inbox[i] = msg
+		}
+	}
+}
+
+// purgeMentions drops the inbox copies of messages purged from room by
+// retention.
+func (s *Server) purgeMentions(room string, events []Event) {
+	purged := make(map[string]bool, len(events))
+	for _, ev := range events {
+		purged[ev.ID] = true
+	}
+	s.mentionsMux.Lock()
+	defer s.mentionsMux.Unlock()
+	for name, inbox := range s.mentions {
+		s.mentions[name] = slices.DeleteFunc(inbox, func(ev Event) bool { return ev.Room == room && purged[ev.ID] })
+	}
+}
+
+// sendMentions sends client the messages that mentioned it, oldest first.
+// The inbox of a registered username is only shown once its owner has
+// identified.
+func (s *Server) sendMentions(client *Client) {
+	if client.name() == "" {
+		client.sendSystem("Please set a username first using /nick <username>")
+		return
+	}
+	if s.isRegistered(client.name()) && !client.isIdentified() {
+		client.sendSystem("This username is registered. Use /identify <password> to see your mentions.")
+		return
+	}
+	s.mentionsMux.Lock()
+	inbox := slices.Clone(s.mentions[client.name()])
+	s.mentionsMux.Unlock()
+
+	if err := client.send(Event{Type: eventMentions, To: client.name(), History: inbox}); err != nil {
+		log.Printf("Error sending mentions to %s: %v", client.ip, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello", nil},
+		{"@alice hi", []string{"alice"}},
+		{"hi @alice, @bob!", []string{"alice", "bob"}},
+		{"@alice @alice", []string{"alice"}},
+		{"@first_last-name.", []string{"first_last-name"}},
+		{"mail me at bob@example.com", nil},
+		{"@ alone", nil},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(msg *Event)
+		wantLen  int
+		wantText string
+	}{
+		{"edited", func(msg *Event) { msg.Text = "@bob edited"; msg.Edited = true }, 1, "@bob edited"},
+		{"deleted", func(msg *Event) { msg.Text = ""; msg.Deleted = true }, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			msg := Event{Type: eventMessage, ID: "m1", From: "alice", Text: "@bob hi", Mentions: []string{"bob", "alice"}}
+			s.recordMentions(msg)
+			if len(s.mentions["alice"]) != 0 {
+				t.Fatal("author's own inbox got the message")
+			}
+
+			tt.change(&msg)
+			s.refreshMentions(msg)
+			inbox := s.mentions["bob"]
+			if len(inbox) != tt.wantLen {
+				t.Fatalf("inbox has %d messages, want %d", len(inbox), tt.wantLen)
+			}
+			if tt.wantLen > 0 && inbox[0].Text != tt.wantText {
+				t.Errorf("inbox text = %q, want %q", inbox[0].Text, tt.wantText)
+			}
+		})
+	}
+}
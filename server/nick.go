@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// maxNickLength is the most characters, counted as people see them
+	// (grapheme clusters), a nickname may have.
+	maxNickLength = 20
+	// maxNickMarks is the most combining marks one character of a nickname
+	// may carry, enough for any script but not for stacked "zalgo" text.
+	maxNickMarks = 2
+)
+
+// reservedNicks cannot be taken, nor anything that looks like them: they
+// would pass for the server, staff or a room.
+var reservedNicks = []string{
+	"server", "system", "admin", "administrator", "root", "moderator", "mod",
+	"everyone", "here", "nobody", defaultRoom,
+}
+
+// nickPunctuation are the characters other than letters, marks and digits a
+// nickname may contain, though not start with.
+const nickPunctuation = "-_."
+
+// nickFolder case-folds nicknames for comparison.
+var nickFolder = cases.Fold()
+
+// normalizeNick returns name in the form it is stored and shown in: NFKC
+// normalised, so that e.g. fullwidth letters and ligatures become the plain
+// ones they stand for. It fails if the result breaks the nickname policy.
+func normalizeNick(name string) (string, error) {
+	name = norm.NFKC.String(strings.TrimSpace(name))
+	if name == "" {
+		return "", errors.New("Usage: /nick <username>")
+	}
+
+	marks := 0
+	for i, r := range name {
+		switch {
+		case unicode.Is(unicode.M, r):
+			if i == 0 {
+				return "", errors.New("Nicknames must start with a letter or digit.")
+			}
+			if marks++; marks > maxNickMarks {
+				return "", errors.New("Nicknames cannot stack that many accents on one letter.")
+			}
+			continue
+		case unicode.IsLetter(r), unicode.Is(unicode.Nd, r):
+		case strings.ContainsRune(nickPunctuation, r):
+			if i == 0 {
+				return "", errors.New("Nicknames must start with a letter or digit.")
+			}
+		default:
+			return "", fmt.Errorf("Nicknames may only contain letters, digits, '-', '_' and '.', not %q.", r)
+		}
+		marks = 0
+	}
+	if uniseg.GraphemeClusterCount(name) > maxNickLength {
+		return "", fmt.Errorf("Nicknames are limited to %d characters.", maxNickLength)
+	}
+	if mixesScripts(name) {
+		return "", errors.New("Nicknames cannot mix Latin, Cyrillic and Greek letters.")
+	}
+	// Names like conversation IDs would make /send and /dm ambiguous
+	for _, skeleton := range nickSkeletons(name) {
+		if strings.HasPrefix(skeleton, nickSkeleton(conversationPrefix)) {
+			return "", fmt.Errorf("'%s' is reserved.", name)
+		}
+	}
+	for _, reserved := range reservedNicks {
+		if nicksLookAlike(name, reserved) {
+			return "", fmt.Errorf("'%s' is reserved.", name)
+		}
+	}
+	return name, nil
+}
+
+// mixesScripts reports whether name has letters of more than one of the
+// Latin, Cyrillic and Greek scripts, whose lookalike letters are the usual
+// way to impersonate someone.
+func mixesScripts(name string) bool {
+	scripts := []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek}
+	used := 0
+	for _, script := range scripts {
+		if strings.IndexFunc(name, func(r rune) bool { return unicode.Is(script, r) }) >= 0 {
+			used++
+		}
+	}
+	return used > 1
+}
+
+// confusables maps characters to the one they are easily mistaken for, after
+// the pattern of the Unicode confusables data (UTS #39) but limited to the
+// letters and digits nicknames allow.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ӏ': "l", 'ј': "j", 'к': "k",
+	'м': "m", 'н': "h", 'о': "o", 'р': "p", 'с': "c", 'т': "t", 'у': "y", 'х': "x",
+	'ѕ': "s", 'ԁ': "d", 'ԛ': "q", 'ԝ': "w", 'ո': "n", 'ɡ': "g",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o",
+	'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w",
+	// Latin and digits
+	'0': "o", '1': "l", 'ı': "i", '5': "s", '8': "b",
+}
+
+// capitalConfusables are capital letters that read as a lowercase "l" in many
+// fonts. Case folding turns them into an "i", so a name with one of them
+// reads two ways.
+const capitalConfusables = "IІΙ"
+
+// confusableRuns are sequences of letters that read as another letter. Runs
+// that ordinary names often contain, such as "cl" for "d" in "clara", are left
+// out: they would keep too many people from their own names.
+var confusableRuns = strings.NewReplacer("rn", "m", "vv", "w")
+
+// nicksLookAlike reports whether nicknames a and b are likely to be mistaken
+// for each other.
+func nicksLookAlike(a, b string) bool {
+	for _, x := range nickSkeletons(a) {
+		for _, y := range nickSkeletons(b) {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nickSkeletons returns the ways name can be read, as skeletons: one, or two
+// if it has capital letters that also read as an "l".
+func nickSkeletons(name string) []string {
+	name = norm.NFKC.String(name)
+	skeletons := []string{nickSkeleton(name)}
+	if strings.ContainsAny(name, capitalConfusables) {
+		asL := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(capitalConfusables, r) {
+				return 'l'
+			}
+			return r
+		}, name)
+		skeletons = append(skeletons, nickSkeleton(asL))
+	}
+	return skeletons
+}
+
+// nickSkeleton reduces name to a form in which nicknames that are likely to be
+// mistaken for each other are equal: case-folded, with confusable characters
+// and letter runs replaced.
+func nickSkeleton(name string) string {
+	folded := nickFolder.String(norm.NFKC.String(name))
+	var b strings.Builder
+	for _, r := range folded {
+		if s, ok := confusables[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return confusableRuns.Replace(b.String())
+}
+
+// sameNick reports whether a and b are the same nickname written in
+// different case.
+func sameNick(a, b string) bool {
+	return nickFolder.String(a) == nickFolder.String(b)
+}
+
+// claimNick gives client the nickname name, unless it could be confused with
+// that of another connected user or a registered account, in which case it
+// returns that nickname. The check and the change happen under one lock, so
+// two clients cannot take lookalike names at the same time.
+func (s *Server) claimNick(client *Client, name string) (taken string) {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+	if taken := s.nickConflict(name, client); taken != "" {
+		return taken
+	}
+	client.setName(name)
+	return ""
+}
+
+// nickConflict returns the nickname of a connected user or registered account
+// that name could be confused with, or "". The account name itself is not a
+// conflict: whoever takes it is asked to /identify. The caller must hold
+// clientsMux.
+func (s *Server) nickConflict(name string, requestingClient *Client) string {
+	for c := range s.clients {
+		if username := c.name(); c != requestingClient && username != "" && nicksLookAlike(username, name) {
+			return username
+		}
+	}
+
+	s.accountsMux.RLock()
+	defer s.accountsMux.RUnlock()
+	for username := range s.accounts {
+		if username != name && nicksLookAlike(username, name) {
+			return username
+		}
+	}
+	return ""
+}
+
+// resolveNick returns the nickname, as its owner wrote it, of the connected
+// user or registered account that name refers to in any case, or name itself
+// if there is none.
+func (s *Server) resolveNick(name string) string {
+	if c := s.findClient(name); c != nil {
+		return c.name()
+	}
+	s.accountsMux.RLock()
+	defer s.accountsMux.RUnlock()
+	if _, ok := s.accounts[name]; ok {
+		return name
+	}
+	for username := range s.accounts {
+		if sameNick(username, name) {
+			return username
+		}
+	}
+	return name
+}
+
+// changeNick handles /nick. Its answer is an eventNick carrying the request's
+// clientID: the name the client now has, or why it was refused, in which case
+// the client keeps its old one.
+func (s *Server) changeNick(client *Client, clientID, requested string) {
+	newUsername, err := normalizeNick(sanitizeName(requested))
+	oldUsername := client.name()
+	if err == nil {
+		if taken := s.claimNick(client, newUsername); taken != "" && sameNick(taken, newUsername) {
+			err = fmt.Errorf("Username '%s' is already taken.", newUsername)
+		} else if taken != "" {
+			err = fmt.Errorf("Username '%s' is too similar to '%s'.", newUsername, taken)
+		}
+	}
+	if err != nil {
+		client.sendNick(clientID, oldUsername, err.Error())
+		return
+	}
+
+	if oldUsername != newUsername {
+		client.setIdentified(false)
+	}
+
+	if oldUsername == "" {
+		joinMsg := fmt.Sprintf("%s has joined the chat.", newUsername)
+		log.Println(joinMsg)
+		s.broadcastSystem(joinMsg)
+	} else if oldUsername != newUsername {
+		s.recordSeen(client, oldUsername)
+		changeMsg := fmt.Sprintf("%s changed nickname to %s.", oldUsername, newUsername)
+		log.Println(changeMsg)
+		s.broadcastSystem(changeMsg)
+	}
+
+	client.sendNick(clientID, newUsername, "")
+	s.sendRoomState(client, defaultRoom)
+	s.sendConversations(client)
+	if oldUsername != newUsername && s.isRegistered(newUsername) {
+		client.sendSystem("This username is registered. Use /identify <password> to receive your messages.")
+	}
+}
+
+// sendNick answers a /nick request with the client's nickname and, if the
+// request was refused, the reason.
+func (c *Client) sendNick(clientID, username, reason string) {
+	ev := Event{Type: eventNick, ClientID: clientID, Text: username, Error: reason, Time: time.Now()}
+	if err := c.send(ev); err != nil {
+		log.Printf("Error answering /nick from %s: %v", c.ip, err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeNick(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", in: "alice", want: "alice"},
+		{name: "trimmed", in: "  alice ", want: "alice"},
+		{name: "punctuation inside", in: "a.b-c_d", want: "a.b-c_d"},
+		{name: "fullwidth letters", in: "ａｌｉｃｅ", want: "alice"},
+		{name: "ligature", in: "ﬁona", want: "fiona"},
+		{name: "composed accent", in: "zoé", want: "zoé"},
+		{name: "non-Latin script", in: "наташа", want: "наташа"},
+		{name: "empty", in: "  ", wantErr: "Usage"},
+		{name: "leading punctuation", in: "-alice", wantErr: "start with a letter"},
+		{name: "leading mark", in: "\u0301alice", wantErr: "start with a letter"},
+		{name: "space", in: "al ice", wantErr: "may only contain"},
+		{name: "symbol", in: "alice!", wantErr: "may only contain"},
+		{name: "two marks", in: "a\u0323\u0302", want: "\u1ead"},
+		{name: "stacked marks", in: "a\u0300\u0301\u0302\u0303", wantErr: "stack"},
+		{name: "too long", in: strings.Repeat("a", maxNickLength+1), wantErr: "limited"},
+		{name: "longest", in: strings.Repeat("a", maxNickLength), want: strings.Repeat("a", maxNickLength)},
+		// An old Hangul syllable written with jamo has no precomposed form
+		// but is one character to readers
+		{name: "jamo syllables count once", in: strings.Repeat("\u1100\u1176", maxNickLength), want: strings.Repeat("\u1100\u1176", maxNickLength)},
+		{name: "mixed scripts", in: "аlice", wantErr: "mix"},
+		{name: "reserved", in: "Admin", wantErr: "reserved"},
+		{name: "reserved lookalike", in: "5erver", wantErr: "reserved"},
+		{name: "reserved room", in: defaultRoom, wantErr: "reserved"},
+		{name: "conversation prefix", in: "dm-alice", wantErr: "reserved"},
+		{name: "conversation prefix lookalike", in: "DM-alice", wantErr: "reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeNick(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("normalizeNick(%q) = %q, %v; want error containing %q", tt.in, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("normalizeNick(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNicksLookAlike(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"alice", "alice", true},
+		{"alice", "ALICE", true},
+		{"alice", "аlice", true}, // Cyrillic а
+		{"paypal", "раураl", true},
+		{"olga", "0lga", true},
+		{"bill", "bi11", true},
+		{"Ian", "lan", true}, // capital I reads as l
+		{"Ian", "ian", true},
+		{"modern", "modem", true},
+		{"vvendy", "wendy", true},
+		{"straße", "strasse", true},
+		{"ian", "lan", false},
+		{"clara", "dara", false},
+		{"alice", "alicia", false},
+		{"bob", "rob", false},
+	}
+	for _, tt := range tests {
+		if got := nicksLookAlike(tt.a, tt.b); got != tt.want {
+			t.Errorf("nicksLookAlike(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := nicksLookAlike(tt.b, tt.a); got != tt.want {
+			t.Errorf("nicksLookAlike(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestClaimNick(t *testing.T) {
+	s := NewServer(Config{}, nil)
+	s.accounts["Ian"] = &Account{}
+	alice := &Client{username: "alice"}
+	s.clients[alice] = true
+
+	tests := []struct {
+		name      string
+		requester *Client
+		want      string
+	}{
+		{"аlice", &Client{}, "alice"},
+		{"ALICE", alice, ""}, // its own name
+		{"lan", &Client{}, "Ian"},
+		{"Ian", &Client{}, ""}, // the account itself: whoever takes it must /identify
+		{"ian", &Client{}, "Ian"},
+		{"clara", &Client{}, ""},
+	}
+	for _, tt := range tests {
+		old := tt.requester.name()
+		got := s.claimNick(tt.requester, tt.name)
+		if got != tt.want {
+			t.Errorf("claimNick(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if want := old; got == "" {
+			want = tt.name
+			if name := tt.requester.name(); name != want {
+				t.Errorf("claimNick(%q) set name %q, want %q", tt.name, name, want)
+			}
+		} else if name := tt.requester.name(); name != want {
+			t.Errorf("claimNick(%q) changed name to %q despite conflict", tt.name, name)
+		}
+	}
+}
+
+func TestNickLookupsFoldCase(t *testing.T) {
+	s := NewServer(Config{}, nil)
+	s.accounts["Ian"] = &Account{}
+	alice := &Client{username: "Alice"}
+	s.clients[alice] = true
+
+	tests := []struct {
+		name       string
+		wantClient *Client
+		resolved   string
+	}{
+		{"Alice", alice, "Alice"},
+		{"alice", alice, "Alice"},
+		{"IAN", nil, "Ian"},
+		{"clara", nil, "clara"},
+	}
+	for _, tt := range tests {
+		if got := s.findClient(tt.name); got != tt.wantClient {
+			t.Errorf("findClient(%q) = %v, want %v", tt.name, got, tt.wantClient)
+		}
+		if got := s.resolveNick(tt.name); got != tt.resolved {
+			t.Errorf("resolveNick(%q) = %q, want %q", tt.name, got, tt.resolved)
+		}
+	}
+}
+
+func TestSendSeenFoldsCase(t *testing.T) {
+	s := testServer(t)
+	s.seen["Bob"] = seenRecord{LastConnected: time.Now()}
+	alice, peer := testClient(t, "alice")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Bob", "Bob was last connected"},
+		{"bob", "Bob was last connected"},
+		{"carol", "I have not seen carol."},
+	}
+	for _, tt := range tests {
+		s.sendSeen(alice, tt.name)
+		if got := readEvent(t, peer).Text; !strings.HasPrefix(got, tt.want) {
+			t.Errorf("/seen %s = %q, want prefix %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChangeNick(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		wantName  string
+		wantError string
+	}{
+		{"free", "carol", "carol", ""},
+		{"normalised", "ｃａｒｏｌ", "carol", ""},
+		{"taken", "Bob", "alice", "Username 'Bob' is already taken."},
+		{"lookalike", "lan", "alice", "Username 'lan' is too similar to 'Ian'."},
+		{"account", "Ian", "Ian", ""},
+	}
+	for _, tt := range tests {
+		s := testServer(t)
+		s.accounts["Ian"] = &Account{}
+		bob, _ := testClient(t, "bob")
+		s.clients[bob] = true
+		alice, peer := testClient(t, "alice")
+		s.clients[alice] = true
+
+		s.changeNick(alice, "c1", tt.requested)
+		ev := readEvent(t, peer)
+		for ev.Type != eventNick {
+			ev = readEvent(t, peer)
+		}
+		if ev.ClientID != "c1" || ev.Text != tt.wantName || alice.name() != tt.wantName {
+			t.Errorf("%s: answer %q (%s), name %q, want %q", tt.name, ev.Text, ev.ClientID, alice.name(), tt.wantName)
+		}
+		if ev.Error != tt.wantError {
+			t.Errorf("%s: error %q, want %q", tt.name, ev.Error, tt.wantError)
+		}
+	}
+}
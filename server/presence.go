@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Presence states a client can be in.
+const (
+	presenceOnline = "online"
+	presenceAway   = "away"
+	presenceBusy   = "busy"
+)
+
+// defaultAwayMessage is used for /away without a message.
+const defaultAwayMessage = "Away"
+
+// presenceState returns the client's presence and away message.
+func (c *Client) presenceState() (presence, awayMessage string) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.presence, c.awayMessage
+}
+
+// presenceLabel is the suffix shown after the client's name in /list.
+func (c *Client) presenceLabel() string {
+	presence, awayMessage := c.presenceState()
+	switch presence {
+	case presenceAway:
+		return fmt.Sprintf(" (away: %s)", awayMessage)
+	case presenceBusy:
+		return " (busy)"
+	}
+	return ""
+}
+
+// isPresenceCommand reports whether message explicitly sets presence, in
+// which case it must not also count as returning from an automatic away.
+func isPresenceCommand(message string) bool {
+	command, _, _ := strings.Cut(message, " ")
+	return command == "/away" || command == "/back" || command == "/status"
+}
+
+// markActive records activity from client. Unless restore is false, a client
+// that idle detection had marked away is brought back online.
+func (s *Server) markActive(client *Client, restore bool) {
+	client.stateMux.Lock()
+	client.lastActive = time.Now()
+	returned := restore && client.autoAway
+	if returned {
+		client.presence = presenceOnline
+		client.awayMessage = ""
+		client.autoAway = false
+	}
+	client.stateMux.Unlock()
+
+	if returned && client.name() != "" {
+		s.broadcastSystem(fmt.Sprintf("%s is back.", client.name()))
+	}
+}
+
+// setPresence changes client's presence on request and announces it.
+func (s *Server) setPresence(client *Client, presence, awayMessage string) {
+	if client.name() == "" {
+		client.sendSystem("Please set a username first using /nick <username>")
+		return
+	}
+	if presence == presenceAway && awayMessage == "" {
+		awayMessage = defaultAwayMessage
+	}
+	if presence != presenceAway {
+		awayMessage = ""
+	}
+
+	client.stateMux.Lock()
+	unchanged := client.presence == presence && client.awayMessage == awayMessage
+	client.presence = presence
+	client.awayMessage = awayMessage
+	client.autoAway = false
+	client.stateMux.Unlock()
+
+	if unchanged {
+		client.sendSystem("Your status is already " + presence + ".")
+		return
+	}
+
+	var notice string
+	switch presence {
+	case presenceAway:
+		notice = fmt.Sprintf("%s is now away: %s", client.name(), awayMessage)
+	case presenceBusy:
+		notice = fmt.Sprintf("%s is now busy.", client.name())
+	default:
+		notice = fmt.Sprintf("%s is back.", client.name())
+	}
+	log.Println(notice)
+	s.broadcastSystem(notice)
+}
+
+// watchIdle periodically marks clients that have been inactive for longer
+// than the configured idle timeout as away. Busy and already-away clients are
+// left alone.
+func (s *Server) watchIdle() {
+	timeout := s.config.IdleTimeout
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []string
+		s.clientsMux.RLock()
+		for c := range s.clients {
+			if c.name() == "" {
+				continue
+			}
+			c.stateMux.Lock()
+			if c.presence == presenceOnline && time.Since(c.lastActive) >= timeout {
+				c.presence = presenceAway
+				c.awayMessage = "Idle"
+				c.autoAway = true
+				idle = append(idle, c.name())
+			}
+			c.stateMux.Unlock()
+		}
+		s.clientsMux.RUnlock()
+
+		for _, username := range idle {
+			s.broadcastSystem(fmt.Sprintf("%s is now away (idle).", username))
+		}
+	}
+}
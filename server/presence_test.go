@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestIsPresenceCommand(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"/away", true},
+		{"/away lunch", true},
+		{"/back", true},
+		{"/status busy", true},
+		{"/awayish", false},
+		{"/list", false},
+		{"away", false},
+	}
+	for _, tt := range tests {
+		if got := isPresenceCommand(tt.message); got != tt.want {
+			t.Errorf("isPresenceCommand(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestPresenceLabel(t *testing.T) {
+	tests := []struct {
+		presence    string
+		awayMessage string
+		want        string
+	}{
+		{presenceOnline, "", ""},
+		{presenceAway, "lunch", " (away: lunch)"},
+		{presenceBusy, "", " (busy)"},
+	}
+	for _, tt := range tests {
+		c := &Client{presence: tt.presence, awayMessage: tt.awayMessage}
+		if got := c.presenceLabel(); got != tt.want {
+			t.Errorf("presenceLabel() for %s = %q, want %q", tt.presence, got, tt.want)
+		}
+	}
+}
+
+func TestMarkActive(t *testing.T) {
+	tests := []struct {
+		name         string
+		autoAway     bool
+		restore      bool
+		wantPresence string
+	}{
+		{"idle client returns", true, true, presenceOnline},
+		{"presence command keeps away", true, false, presenceAway},
+		{"explicit away is kept", false, true, presenceAway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			c := &Client{presence: presenceAway, awayMessage: "Idle", autoAway: tt.autoAway}
+			s.markActive(c, tt.restore)
+			if presence, _ := c.presenceState(); presence != tt.wantPresence {
+				t.Errorf("presence = %s, want %s", presence, tt.wantPresence)
+			}
+			if c.lastActive.IsZero() {
+				t.Error("lastActive not updated")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types written by the server.
+const (
+	eventMessage = "message" // chat line broadcast to everyone
+	eventPM      = "pm"      // private message delivered to its target
+	eventSystem  = "system"  // server notice
+	eventAck     = "ack"     // delivery acknowledgement sent back to the author
+	eventReplay  = "replay"  // batch of room messages missed while disconnected
+
+	eventEdit   = "edit"   // a stored room message was edited
+	eventDelete = "delete" // a stored room message was deleted
+
+	eventReaction = "reaction" // the reactions on a stored room message changed
+
+	eventThread     = "thread"      // a thread's root message and all its replies
+	eventReplyCount = "reply_count" // a thread root's number of replies changed
+
+	eventMentions = "mentions" // the requesting user's mentions inbox
+
+	eventSearch = "search" // results of /search, best match first
+
+	eventConversation = "conversation" // a group conversation's member list changed
+
+	eventUpload   = "upload"   // a file was accepted; send its content as binary frames
+	eventDownload = "download" // a requested file follows as binary frames
+
+	eventTyping     = "typing"      // someone started typing in a room or to you
+	eventStopTyping = "stop_typing" // they cleared their input or sent it
+
+	eventKey = "key" // a user's public key for private messages, answering /key
+
+	eventNick = "nick" // answer to /nick: the name now in use, or why it was refused
+
+	eventRoom = "room" // a room's topic and pinned messages, on joining it and when they change
+)
+
+// Event is the JSON envelope for every frame the server sends to a client.
+type Event struct {
+	Type     string    `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	Seq      uint64    `json:"seq,omitempty"`
+	Room     string    `json:"room,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Auto     bool      `json:"auto,omitempty"`   // automatic reply, e.g. an away message
+	Action   bool      `json:"action,omitempty"` // sent with /me, shown as "* alice waves"
+	Edited   bool      `json:"edited,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Thread   string    `json:"thread,omitempty"`  // ID of the thread root this message replies to
+	Replies  int       `json:"replies,omitempty"` // number of replies, on thread roots
+	Time     time.Time `json:"time"`
+	History  []Event   `json:"history,omitempty"`
+	Mentions []string  `json:"mentions,omitempty"` // users mentioned as @username
+	Members  []string  `json:"members,omitempty"`  // members of a group conversation
+	Spans    []Span    `json:"spans,omitempty"`    // inline formatting of Text
+	Code     bool      `json:"code,omitempty"`     // Text is a code snippet
+	Lang     string    `json:"lang,omitempty"`     // language of a code snippet, if given
+	File     *FileInfo `json:"file,omitempty"`     // shared file, on file cards and downloads
+	Context  []Event   `json:"context,omitempty"`  // messages around a search result, oldest first
+	Key      string    `json:"key,omitempty"`      // base64 NaCl box public key: the sender's on PMs, the user's on key events
+	Sealed   string    `json:"sealed,omitempty"`   // base64 nonce and NaCl box of an end-to-end encrypted PM
+	Topic    string    `json:"topic,omitempty"`    // room topic, on room events
+	Pins     []Event   `json:"pins,omitempty"`     // pinned messages in pinning order, on room events
+
+	// Reactions maps each emoji on a message to the users who reacted with it.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+
+	// author is the connection that sent a room message. It is never sent
+	// to clients; it proves authorship when a nickname is reused.
+	author *Client
+}
+
+// Command is the JSON envelope for frames read from a client. ClientID is an
+// opaque token chosen by the client and echoed back in the matching ack.
+type Command struct {
+	ClientID string `json:"client_id,omitempty"`
+	Text     string `json:"text"`
+}
+
+// parseCommand decodes a client frame. Frames that are not a JSON object are
+// treated as plain text so that simple clients (e.g. wscat) keep working.
+func parseCommand(p []byte) Command {
+	var cmd Command
+	if err := json.Unmarshal(p, &cmd); err != nil {
+		return Command{Text: string(p)}
+	}
+	return cmd
+}
+
+// messageIDSize is the number of random bytes in an ID: enough that IDs of
+// messages, conversations and files never collide, as they are kept forever.
+const messageIDSize = 16
+
+// newMessageID returns a random identifier for an accepted message,
+// conversation or file.
+func newMessageID() (string, error) {
+	b := make([]byte, messageIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
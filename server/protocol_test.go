@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Command
+	}{
+		{"json", `{"client_id":"c1","text":"hello"}`, Command{ClientID: "c1", Text: "hello"}},
+		{"json without id", `{"text":"/list"}`, Command{Text: "/list"}},
+		{"plain text", "hello there", Command{Text: "hello there"}},
+		{"not an object", `"hello"`, Command{Text: `"hello"`}},
+		{"empty", "", Command{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCommand([]byte(tt.in)); got != tt.want {
+				t.Errorf("parseCommand(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := newMessageID()
+		if err != nil {
+			t.Fatalf("newMessageID: %v", err)
+		}
+		if b, err := hex.DecodeString(id); err != nil || len(b) != messageIDSize {
+			t.Fatalf("newMessageID() = %q, want %d hex-encoded bytes", id, messageIDSize)
+		}
+		if seen[id] {
+			t.Fatalf("newMessageID() returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxReactionsPerMessage caps how many distinct emoji a message can collect.
+const maxReactionsPerMessage = 20
+
+// reactionShortcodes maps the shortcodes accepted by /react to their emoji.
+var reactionShortcodes = map[string]string{
+	":+1:":               "👍",
+	":thumbsup:":         "👍",
+	":-1:":               "👎",
+	":thumbsdown:":       "👎",
+	":heart:":            "❤️",
+	":smile:":            "😄",
+	":laughing:":         "😆",
+	":joy:":              "😂",
+	":tada:":             "🎉",
+	":eyes:":             "👀",
+	":fire:":             "🔥",
+	":rocket:":           "🚀",
+	":thinking:":         "🤔",
+	":ok_hand:":          "👌",
+	":clap:":             "👏",
+	":pray:":             "🙏",
+	":100:":              "💯",
+	":cry:":              "😢",
+	":white_check_mark:": "✅",
+	":x:":                "❌",
+}
+
+var (
+	errInvalidReaction = errors.New("invalid reaction")
+	errTooManyReacts   = errors.New("too many reactions")
+)
+
+// normalizeReaction turns a shortcode into its emoji and checks that anything
+// else looks like a single emoji rather than arbitrary text.
+func normalizeReaction(reaction string) (string, error) {
+	if emoji, ok := reactionShortcodes[strings.ToLower(reaction)]; ok {
+		return emoji, nil
+	}
+	if reaction == "" || utf8.RuneCountInString(reaction) > 10 {
+		return "", errInvalidReaction
+	}
+	for _, r := range reaction {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r) {
+			return "", errInvalidReaction
+		}
+	}
+	return reaction, nil
+}
+
+// toggleReaction adds client's reaction to a stored room message, or removes
+// it if it was already there, and broadcasts the message's new reaction set.
+func (s *Server) toggleReaction(client *Client, id, reaction string) {
+	if client.name() == "" {
+		client.sendSystem("Please set a username first using /nick <username>")
+		return
+	}
+	emoji, err := normalizeReaction(reaction)
+	if err != nil {
+		client.sendSystem(fmt.Sprintf("'%s' is not an emoji or a known shortcode.", reaction))
+		return
+	}
+	room := s.roomWithMessage(client, id)
+	if room == nil {
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+		return
+	}
+
+	err = room.update(id, func(msg *Event) error {
+		if msg.Deleted {
+			return errMessageDeleted
+		}
+
+		// Build a fresh map so events already handed out stay unchanged
+		reactions := make(map[string][]string, len(msg.Reactions)+1)
+		for e, users := range msg.Reactions {
+			reactions[e] = slices.Clone(users)
+		}
+		users := reactions[emoji]
+		if i := slices.Index(users, client.name()); i >= 0 {
+			users = slices.Delete(users, i, i+1)
+		} else {
+			if _, exists := reactions[emoji]; !exists && len(reactions) >= maxReactionsPerMessage {
+				return errTooManyReacts
+			}
+			users = append(users, client.name())
+		}
+		if len(users) == 0 {
+			delete(reactions, emoji)
+		} else {
+			reactions[emoji] = users
+		}
+		msg.Reactions = reactions
+
+		log.Printf("%s toggled %s on %s in %s", client.name(), emoji, id, room.name)
+		s.broadcast(Event{Type: eventReaction, ID: msg.ID, Room: msg.Room, Seq: msg.Seq, Reactions: reactions, Time: time.Now()}, nil)
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+	case errors.Is(err, errMessageDeleted):
+		client.sendSystem(fmt.Sprintf("Message '%s' was deleted.", id))
+	case errors.Is(err, errTooManyReacts):
+		client.sendSystem(fmt.Sprintf("Message '%s' already has %d different reactions.", id, maxReactionsPerMessage))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeReaction(t *testing.T) {
+	tests := []struct {
+		reaction string
+		want     string
+		wantErr  error
+	}{
+		{":+1:", "👍", nil},
+		{":TADA:", "🎉", nil},
+		{"🚀", "🚀", nil},
+		{"❤️", "❤️", nil},
+		{"", "", errInvalidReaction},
+		{"lol", "", errInvalidReaction},
+		{"👍 ", "", errInvalidReaction},
+		{":unknown:", "", errInvalidReaction},
+		{"🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉", "", errInvalidReaction},
+	}
+	for _, tt := range tests {
+		got, err := normalizeReaction(tt.reaction)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("normalizeReaction(%q) = %q, %v; want %q, %v", tt.reaction, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestToggleReaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		toggles [][2]string // username, reaction
+		want    map[string][]string
+	}{
+		{"adds", [][2]string{{"alice", ":+1:"}}, map[string][]string{"👍": {"alice"}}},
+		{"adds second user", [][2]string{{"alice", ":+1:"}, {"bob", "👍"}}, map[string][]string{"👍": {"alice", "bob"}}},
+		{"removes", [][2]string{{"alice", ":+1:"}, {"alice", "👍"}}, map[string][]string{}},
+		{"keeps other emoji", [][2]string{{"alice", ":+1:"}, {"bob", ":tada:"}, {"alice", ":+1:"}}, map[string][]string{"🎉": {"bob"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			room := s.room(defaultRoom)
+			room.publish(Event{Type: eventMessage, ID: "m1", From: "carol"}, func(Event) {})
+			for _, toggle := range tt.toggles {
+				s.toggleReaction(&Client{username: toggle[0]}, "m1", toggle[1])
+			}
+			got := room.history[0].Reactions
+			if len(got) != len(tt.want) {
+				t.Fatalf("reactions = %v, want %v", got, tt.want)
+			}
+			for emoji, users := range tt.want {
+				if !slices.Equal(got[emoji], users) {
+					t.Errorf("reactions = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const retentionFile = "retention.json"
+
+const retentionUsage = "Usage: /retention [<room> age=<30d> count=<n> bytes=<10MB> | <room> default]"
+
+// Retention limits how much of a room's history is kept: messages older than
+// MaxAge, and the oldest messages beyond MaxCount or MaxBytes of log, are
+// purged. A zero field sets no limit.
+type Retention struct {
+	MaxAge   time.Duration `json:"max_age,omitempty"`
+	MaxCount int           `json:"max_count,omitempty"`
+	MaxBytes int64         `json:"max_bytes,omitempty"`
+}
+
+// retentionRules are the retention settings admins change at runtime.
+type retentionRules struct {
+	Rooms map[string]Retention `json:"rooms,omitempty"` // per-room limits, overriding the global ones they set
+	Holds map[string]time.Time `json:"holds,omitempty"` // rooms under legal hold, with when the hold was placed
+}
+
+// purgeStats counts what compaction removed from a room.
+type purgeStats struct {
+	messages int64
+	bytes    int64
+}
+
+// retentionMetrics describe the compaction runs since the server started.
+type retentionMetrics struct {
+	purged       map[string]purgeStats // by room
+	runs         int64
+	lastRun      time.Time
+	lastDuration time.Duration
+}
+
+// purgeCount returns how many of the oldest events r drops, given their
+// encoded sizes.
+func (r Retention) purgeCount(events []Event, sizes []int, now time.Time) int {
+	n := 0
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		for n < len(events) && events[n].Time.Before(cutoff) {
+			n++
+		}
+	}
+	if r.MaxCount > 0 && len(events)-n > r.MaxCount {
+		n = len(events) - r.MaxCount
+	}
+	if r.MaxBytes > 0 {
+		var total int64
+		for _, size := range sizes[n:] {
+			total += int64(size)
+		}
+		for n < len(events) && total > r.MaxBytes {
+			total -= int64(sizes[n])
+			n++
+		}
+	}
+	return n
+}
+
+// String describes r for /retention, e.g. "age 30d · count 1000".
+func (r Retention) String() string {
+	var limits []string
+	if r.MaxAge > 0 {
+		limits = append(limits, "age "+formatAge(r.MaxAge))
+	}
+	if r.MaxCount > 0 {
+		limits = append(limits, fmt.Sprintf("count %d", r.MaxCount))
+	}
+	if r.MaxBytes > 0 {
+		limits = append(limits, "bytes "+formatSize(r.MaxBytes))
+	}
+	if len(limits) == 0 {
+		return "keep everything"
+	}
+	return strings.Join(limits, " · ")
+}
+
+// loadRetention reads the retention settings from the store.
+func (s *Server) loadRetention() error {
+	s.retentionMux.Lock()
+	defer s.retentionMux.Unlock()
+	if err := s.store.load(retentionFile, &s.retention); err != nil {
+		return err
+	}
+	if s.retention.Rooms == nil {
+		s.retention.Rooms = make(map[string]Retention)
+	}
+	if s.retention.Holds == nil {
+		s.retention.Holds = make(map[string]time.Time)
+	}
+	return nil
+}
+
+// saveRetention writes the retention settings to the store. The caller must
+// hold retentionMux.
+func (s *Server) saveRetention() {
+	if err := s.store.save(retentionFile, s.retention); err != nil {
+		log.Printf("Error saving retention settings: %v", err)
+	}
+}
+
+// retentionFor returns the limits that apply to room: the global ones, with
+// any the room sets itself taking their place.
+func (s *Server) retentionFor(room string) Retention {
+	policy := Retention{MaxAge: s.config.RetainAge, MaxCount: s.config.RetainCount, MaxBytes: s.config.RetainBytes}
+	s.retentionMux.Lock()
+	own := s.retention.Rooms[room]
+	s.retentionMux.Unlock()
+	if own.MaxAge > 0 {
+		policy.MaxAge = own.MaxAge
+	}
+	if own.MaxCount > 0 {
+		policy.MaxCount = own.MaxCount
+	}
+	if own.MaxBytes > 0 {
+		policy.MaxBytes = own.MaxBytes
+	}
+	return policy
+}
+
+// isHeld reports whether room is under legal hold.
+func (s *Server) isHeld(room string) bool {
+	s.retentionMux.Lock()
+	defer s.retentionMux.Unlock()
+	_, held := s.retention.Holds[room]
+	return held
+}
+
+// watchRetention compacts every room's history now and then once per
+// compaction interval.
+func (s *Server) watchRetention() {
+	ticker := time.NewTicker(s.config.CompactInterval)
+	defer ticker.Stop()
+	for {
+		s.compactAll()
+		<-ticker.C
+	}
+}
+
+// compactAll enforces the retention limits of every room and rewrites its
+// log without superseded versions of messages. Purged messages also leave the
+// search index, mentions inboxes, /seen and the room's pins. Rooms under legal
+// hold are left alone entirely, so even earlier versions of their edited and
+// deleted messages are kept. It returns what was purged.
+func (s *Server) compactAll() purgeStats {
+	start := time.Now()
+	s.roomsMux.RLock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsMux.RUnlock()
+
+	var total purgeStats
+	for _, room := range rooms {
+		if s.isHeld(room.name) {
+			continue
+		}
+		policy := s.retentionFor(room.name)
+		purged, bytes, err := room.compact(func(events []Event, sizes []int) int {
+			return policy.purgeCount(events, sizes, start)
+		})
+		if err != nil {
+			log.Printf("Error compacting %s: %v", room.name, err)
+			continue
+		}
+		if len(purged) == 0 {
+			continue
+		}
+		s.index.removeMessages(room.name, purged)
+		s.purgeMentions(room.name, purged)
+		s.purgeSeen(purged)
+		s.purgePins(room.name, purged)
+		log.Printf("Purged %d messages (%s) from %s", len(purged), formatSize(bytes), room.name)
+
+		s.retentionMux.Lock()
+		stats := s.purges.purged[room.name]
+		stats.messages += int64(len(purged))
+		stats.bytes += bytes
+		s.purges.purged[room.name] = stats
+		s.retentionMux.Unlock()
+		total.messages += int64(len(purged))
+		total.bytes += bytes
+	}
+
+	s.retentionMux.Lock()
+	s.purges.runs++
+	s.purges.lastRun = start
+	s.purges.lastDuration = time.Since(start)
+	s.retentionMux.Unlock()
+	return total
+}
+
+// handleRetention handles /retention for admins: without arguments it shows
+// the limits, legal holds and purge counts; with a room it changes that
+// room's limits.
+func (s *Server) handleRetention(client *Client, args string) {
+	if !client.isAdmin() {
+		client.sendSystem("Only admins can manage retention.")
+		return
+	}
+	if args == "" {
+		s.sendRetention(client)
+		return
+	}
+
+	fields := strings.Fields(args)
+	room := strings.TrimPrefix(fields[0], "#")
+	if s.room(room) == nil {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", room))
+		return
+	}
+	if len(fields) == 2 && fields[1] == "default" {
+		s.retentionMux.Lock()
+		delete(s.retention.Rooms, room)
+		s.saveRetention()
+		s.retentionMux.Unlock()
+		log.Printf("%s reset the retention of %s", client.name(), room)
+		client.sendSystem(fmt.Sprintf("%s now follows the global retention: %s.", room, s.retentionFor(room)))
+		return
+	}
+	own, err := parseRetention(fields[1:])
+	if err != nil {
+		client.sendSystem(err.Error())
+		return
+	}
+	s.retentionMux.Lock()
+	s.retention.Rooms[room] = own
+	s.saveRetention()
+	s.retentionMux.Unlock()
+	log.Printf("%s set the retention of %s to %s", client.name(), room, own)
+	client.sendSystem(fmt.Sprintf("Retention of %s set to %s. It applies at the next compaction.", room, s.retentionFor(room)))
+}
+
+// parseRetention parses age=, count= and bytes= limits.
+func parseRetention(fields []string) (Retention, error) {
+	var r Retention
+	if len(fields) == 0 {
+		return r, errors.New(retentionUsage)
+	}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "age":
+			r.MaxAge, err = parseAge(value)
+		case "count":
+			r.MaxCount, err = strconv.Atoi(value)
+			if err == nil && r.MaxCount < 0 {
+				err = errors.New("negative count")
+			}
+		case "bytes":
+			r.MaxBytes, err = parseBytes(value)
+		default:
+			return r, errors.New(retentionUsage)
+		}
+		if err != nil {
+			return r, fmt.Errorf("Invalid %s '%s'.", key, value)
+		}
+	}
+	return r, nil
+}
+
+// parseAge parses a duration that may also be given in days, e.g. "30d".
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid days")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = errors.New("negative duration")
+	}
+	return d, err
+}
+
+// formatAge renders an age limit the way parseAge reads it.
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// parseBytes parses a size such as "4096", "512KB" or "10MB".
+func parseBytes(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	scale := int64(1)
+	upper := strings.ToUpper(value)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			upper, scale = number, unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return n * scale, nil
+}
+
+// sendRetention shows client the retention limits, legal holds and what
+// compaction has purged.
+func (s *Server) sendRetention(client *Client) {
+	global := Retention{MaxAge: s.config.RetainAge, MaxCount: s.config.RetainCount, MaxBytes: s.config.RetainBytes}
+	s.retentionMux.Lock()
+	details := []string{"global: " + global.String()}
+	for _, room := range sortedNames(s.retention.Rooms) {
+		details = append(details, fmt.Sprintf("%s: %s", room, s.retention.Rooms[room]))
+	}
+	for _, room := range sortedNames(s.retention.Holds) {
+		details = append(details, fmt.Sprintf("%s: legal hold since %s", room, s.retention.Holds[room].Format("2006-01-02")))
+	}
+	var total purgeStats
+	for _, stats := range s.purges.purged {
+		total.messages += stats.messages
+		total.bytes += stats.bytes
+	}
+	run := "compaction has not run yet"
+	if s.purges.runs > 0 {
+		run = fmt.Sprintf("last compaction %s ago", formatDuration(time.Since(s.purges.lastRun)))
+	}
+	s.retentionMux.Unlock()
+	details = append(details, fmt.Sprintf("purged %d messages (%s) since start", total.messages, formatSize(total.bytes)), run)
+	client.sendSystem("Retention: " + strings.Join(details, " · "))
+}
+
+// setLegalHold handles /hold and /unhold for admins. A room under legal hold
+// keeps its whole history, whatever the retention limits.
+func (s *Server) setLegalHold(client *Client, room string, hold bool) {
+	if !client.isAdmin() {
+		client.sendSystem("Only admins can manage legal holds.")
+		return
+	}
+	room = strings.TrimPrefix(room, "#")
+	if s.room(room) == nil {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", room))
+		return
+	}
+
+	s.retentionMux.Lock()
+	_, held := s.retention.Holds[room]
+	if hold && !held {
+		s.retention.Holds[room] = time.Now()
+	} else if !hold && held {
+		delete(s.retention.Holds, room)
+	}
+	s.saveRetention()
+	s.retentionMux.Unlock()
+
+	if hold {
+		log.Printf("%s placed %s under legal hold", client.name(), room)
+		client.sendSystem(fmt.Sprintf("%s is under legal hold: none of its history will be purged.", room))
+	} else {
+		log.Printf("%s released the legal hold on %s", client.name(), room)
+		client.sendSystem(fmt.Sprintf("%s is no longer under legal hold.", room))
+	}
+}
+
+// compactNow handles /compact: an admin runs compaction without waiting for
+// the next interval.
+func (s *Server) compactNow(client *Client) {
+	if !client.isAdmin() {
+		client.sendSystem("Only admins can run compaction.")
+		return
+	}
+	total := s.compactAll()
+	client.sendSystem(fmt.Sprintf("Compaction done: purged %d messages (%s).", total.messages, formatSize(total.bytes)))
+}
+
+// handleMetrics serves the purge metrics in the Prometheus text format. They
+// name every room and conversation, so scrapers must log in with the admin
+// password over HTTP basic auth; without one the endpoint is disabled.
+func (s *Server) handleMetrics(c echo.Context) error {
+	if s.config.AdminPassword == "" {
+		return echo.ErrNotFound
+	}
+	_, password, ok := c.Request().BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.config.AdminPassword)) != 1 {
+		log.Printf("Unauthorized metrics request from %s", c.RealIP())
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		return echo.ErrUnauthorized
+	}
+
+	s.retentionMux.Lock()
+	rooms := sortedNames(s.purges.purged)
+	var b strings.Builder
+	b.WriteString("# HELP chat_retention_purged_messages_total Messages purged by retention, by room.\n")
+	b.WriteString("# TYPE chat_retention_purged_messages_total counter\n")
+	for _, room := range rooms {
+		fmt.Fprintf(&b, "chat_retention_purged_messages_total{room=%q} %d\n", room, s.purges.purged[room].messages)
+	}
+	b.WriteString("# HELP chat_retention_purged_bytes_total Bytes of history purged by retention, by room.\n")
+	b.WriteString("# TYPE chat_retention_purged_bytes_total counter\n")
+	for _, room := range rooms {
+		fmt.Fprintf(&b, "chat_retention_purged_bytes_total{room=%q} %d\n", room, s.purges.purged[room].bytes)
+	}
+	b.WriteString("# HELP chat_retention_compactions_total Compaction runs.\n")
+	b.WriteString("# TYPE chat_retention_compactions_total counter\n")
+	fmt.Fprintf(&b, "chat_retention_compactions_total %d\n", s.purges.runs)
+	if s.purges.runs > 0 {
+		b.WriteString("# HELP chat_retention_last_compaction_timestamp_seconds When the last compaction started.\n")
+		b.WriteString("# TYPE chat_retention_last_compaction_timestamp_seconds gauge\n")
+		fmt.Fprintf(&b, "chat_retention_last_compaction_timestamp_seconds %d\n", s.purges.lastRun.Unix())
+		b.WriteString("# HELP chat_retention_last_compaction_duration_seconds How long the last compaction took.\n")
+		b.WriteString("# TYPE chat_retention_last_compaction_duration_seconds gauge\n")
+		fmt.Fprintf(&b, "chat_retention_last_compaction_duration_seconds %g\n", s.purges.lastDuration.Seconds())
+	}
+	b.WriteString("# HELP chat_retention_legal_holds Rooms under legal hold.\n")
+	b.WriteString("# TYPE chat_retention_legal_holds gauge\n")
+	fmt.Fprintf(&b, "chat_retention_legal_holds %d\n", len(s.retention.Holds))
+	s.retentionMux.Unlock()
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// sortedNames returns the keys of m in order.
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPurgeCount(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		{Time: now.Add(-72 * time.Hour)},
+		{Time: now.Add(-48 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+		{Time: now},
+	}
+	sizes := []int{100, 100, 100, 100}
+	tests := []struct {
+		name   string
+		policy Retention
+		want   int
+	}{
+		{"no limits", Retention{}, 0},
+		{"age", Retention{MaxAge: 24 * time.Hour}, 2},
+		{"count", Retention{MaxCount: 3}, 1},
+		{"bytes", Retention{MaxBytes: 250}, 2},
+		{"strictest wins", Retention{MaxAge: 60 * time.Hour, MaxCount: 2, MaxBytes: 150}, 3},
+		{"all purged", Retention{MaxBytes: 50}, 4},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.purgeCount(events, sizes, now); got != tt.want {
+			t.Errorf("%s: purgeCount = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    Retention
+		wantErr bool
+	}{
+		{"age=30d", Retention{MaxAge: 30 * 24 * time.Hour}, false},
+		{"age=90m count=10", Retention{MaxAge: 90 * time.Minute, MaxCount: 10}, false},
+		{"bytes=10MB", Retention{MaxBytes: 10 << 20}, false},
+		{"bytes=512kb", Retention{MaxBytes: 512 << 10}, false},
+		{"bytes=4096", Retention{MaxBytes: 4096}, false},
+		{"", Retention{}, true},
+		{"count=-1", Retention{}, true},
+		{"age=-1d", Retention{}, true},
+		{"bytes=lots", Retention{}, true},
+		{"size=1", Retention{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRetention(strings.Fields(tt.args))
+		if (err != nil) != tt.wantErr || (err == nil && got != tt.want) {
+			t.Errorf("parseRetention(%q) = %+v, %v; want %+v, error %v", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCompactAllPurges(t *testing.T) {
+	tests := []struct {
+		name        string
+		held        bool
+		wantPurged  int64
+		wantHistory int
+	}{
+		{"over the count limit", false, 2, 1},
+		{"under legal hold", true, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			s.config.RetainCount = 1
+			if err := s.loadState(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.held {
+				s.retention.Holds[defaultRoom] = time.Now()
+			}
+			alice, _ := testClient(t, "alice")
+			s.addClient(alice)
+			bob, _ := testClient(t, "bob")
+			s.addClient(bob)
+			lobby := s.room(defaultRoom)
+			for _, text := range []string{"hi @bob", "deploy", "latest"} {
+				if err := s.publishMessage(lobby, alice, "", chatEvent(alice, text)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			first := lobby.history[0].ID
+			alice.recordMessage(lobby.history[0])
+			s.roomInfoFor(defaultRoom).Pins = []Pin{{Message: lobby.history[0], By: "alice"}}
+
+			total := s.compactAll()
+			if total.messages != tt.wantPurged {
+				t.Errorf("purged %d messages, want %d", total.messages, tt.wantPurged)
+			}
+			if len(lobby.history) != tt.wantHistory {
+				t.Errorf("%d messages left, want %d", len(lobby.history), tt.wantHistory)
+			}
+			if events, _ := readHistory(s.store, defaultRoom); len(events) != tt.wantHistory {
+				t.Errorf("%d messages left in the log, want %d", len(events), tt.wantHistory)
+			}
+			purged := tt.wantPurged > 0
+			if hits := s.index.search(searchQuery{terms: []string{"deploy"}}, []string{defaultRoom}); (len(hits) == 0) != purged {
+				t.Errorf("search found %d messages", len(hits))
+			}
+			if inbox := s.mentions["bob"]; (len(inbox) == 0) != purged {
+				t.Errorf("mentions inbox has %d messages", len(inbox))
+			}
+			if (alice.lastMessageID != first) != purged {
+				t.Errorf("/seen message = %q", alice.lastMessageID)
+			}
+			if pins := s.roomInfoFor(defaultRoom).Pins; (len(pins) == 0) != purged {
+				t.Errorf("%d messages left pinned", len(pins))
+			}
+		})
+	}
+}
+
+func TestMetricsNeedAdminPassword(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminPassword string
+		password      string // "" sends no credentials
+		want          int
+	}{
+		{"disabled without admin password", "", "", http.StatusNotFound},
+		{"no credentials", "s3cret", "", http.StatusUnauthorized},
+		{"wrong password", "s3cret", "guess", http.StatusUnauthorized},
+		{"admin password", "s3cret", "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		s := testServer(t)
+		s.config.AdminPassword = tt.adminPassword
+		e := echo.New()
+		e.GET("/metrics", s.handleMetrics)
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.password != "" {
+			req.SetBasicAuth("prometheus", tt.password)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), "chat_retention_compactions_total 0") {
+			t.Errorf("%s: body %q", tt.name, rec.Body.String())
+		}
+	}
+}
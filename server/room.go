@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+)
+
+const (
+	// defaultRoom is the room every client talks in.
+	defaultRoom = "lobby"
+	// roomHistoryLimit bounds how many messages a room keeps for replay.
+	roomHistoryLimit = 1000
+)
+
+// errMessageNotFound is returned for IDs that are not in a room's history.
+var errMessageNotFound = errors.New("message not found")
+
+// Room is a chat channel with its own monotonic sequence counter and a
+// bounded buffer of recent messages used to replay gaps after a reconnect.
+// Every message is also appended to the room's log in the store, which keeps
+// the full history.
+type Room struct {
+	name    string
+	store   *Store
+	mux     sync.Mutex
+	seq     uint64
+	history []Event
+}
+
+func newRoom(name string, store *Store) *Room {
+	return &Room{name: name, store: store}
+}
+
+// restore resumes the room from its stored history: the sequence counter
+// continues after the last message and the newest messages are kept for
+// replay.
+func (r *Room) restore(events []Event) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	r.seq = events[len(events)-1].Seq
+	r.history = append([]Event(nil), events[max(0, len(events)-roomHistoryLimit):]...)
+}
+
+// persist appends the current state of msg to the room's log. The caller
+// must hold r.mux, so the log is written in sequence order.
+func (r *Room) persist(msg Event) {
+	if err := r.store.appendLine(historyFile(r.name), msg); err != nil {
+		log.Printf("Error storing message %s of %s: %v", msg.ID, r.name, err)
+	}
+}
+
+// publish stamps ev with the room name and the next sequence number, records
+// it and hands the stamped event to deliver. deliver runs with the room locked so that every
+// client observes the room's messages in sequence order.
+func (r *Room) publish(ev Event, deliver func(Event)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.seq++
+	ev.Room = r.name
+	ev.Seq = r.seq
+	r.history = append(r.history, ev)
+	if len(r.history) > roomHistoryLimit {
+		r.history = r.history[len(r.history)-roomHistoryLimit:]
+	}
+	r.persist(ev)
+
+	deliver(ev)
+}
+
+// replay passes every recorded message with a sequence number greater than
+// after to deliver. complete is false when some of those messages have already
+// been dropped from the buffer. deliver runs with the room locked so that no
+// new message can be published in between.
+func (r *Room) replay(after uint64, deliver func(events []Event, complete bool)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if after > r.seq {
+		// The counter restarted (e.g. the server was restarted), so
+		// everything still buffered is new to the client.
+		after = 0
+	}
+
+	var missed []Event
+	for _, ev := range r.history {
+		if ev.Seq > after {
+			missed = append(missed, ev)
+		}
+	}
+	complete := after >= r.seq || (len(r.history) > 0 && r.history[0].Seq <= after+1)
+	deliver(missed, complete)
+}
+
+// compact rewrites the room's log with only the latest state of each
+// message, leaving out the oldest ones: purge is given the messages in
+// sequence order with their encoded sizes and returns how many of them to
+// drop. Dropped messages are no longer replayed either. compact returns them
+// with the bytes they took up, and runs with the room locked so that nothing
+// is published meanwhile.
+func (r *Room) compact(purge func(events []Event, sizes []int) int) ([]Event, int64, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	events, err := readHistory(r.store, r.name)
+	if err != nil || len(events) == 0 {
+		return nil, 0, err
+	}
+	lines := make([][]byte, len(events))
+	sizes := make([]int, len(events))
+	for i, ev := range events {
+		if lines[i], err = json.Marshal(ev); err != nil {
+			return nil, 0, err
+		}
+		sizes[i] = len(lines[i]) + 1
+	}
+	n := purge(events, sizes)
+	if err := r.store.replaceLines(historyFile(r.name), lines[n:]); err != nil {
+		return nil, 0, err
+	}
+
+	var purgedBytes int64
+	for _, size := range sizes[:n] {
+		purgedBytes += int64(size)
+	}
+	if n > 0 {
+		last := events[n-1].Seq
+		r.history = slices.DeleteFunc(r.history, func(ev Event) bool { return ev.Seq <= last })
+	}
+	return events[:n], purgedBytes, nil
+}
+
+// has reports whether the message with the given ID is in the room's history.
+func (r *Room) has(id string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.indexOf(id) >= 0
+}
+
+// update lets change modify the recorded message with the given ID in place.
+// If change returns an error the message is left untouched. change runs with
+// the room locked, so it can also deliver the resulting event in order.
+func (r *Room) update(id string, change func(msg *Event) error) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	i := r.indexOf(id)
+	if i < 0 {
+		return errMessageNotFound
+	}
+	msg := r.history[i]
+	if err := change(&msg); err != nil {
+		return err
+	}
+	r.history[i] = msg
+	r.persist(msg)
+	return nil
+}
+
+// get returns a copy of the recorded message with the given ID.
+func (r *Room) get(id string) (Event, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if i := r.indexOf(id); i >= 0 {
+		return r.history[i], true
+	}
+	return Event{}, false
+}
+
+// thread returns the recorded thread root with the given ID followed by its
+// replies in sequence order.
+func (r *Room) thread(rootID string) []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var events []Event
+	for _, ev := range r.history {
+		if ev.ID == rootID || ev.Thread == rootID {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// indexOf returns the position of id in the history, or -1. The caller must
+// hold r.mux.
+func (r *Room) indexOf(id string) int {
+	for i := len(r.history) - 1; i >= 0; i-- {
+		if r.history[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestRoomReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		published    int
+		after        uint64
+		wantFirst    uint64
+		wantCount    int
+		wantComplete bool
+	}{
+		{"from the start", 3, 0, 1, 3, true},
+		{"one missed", 3, 2, 3, 1, true},
+		{"up to date", 3, 3, 0, 0, true},
+		{"counter restarted", 3, 10, 1, 3, true},
+		{"gap still buffered", roomHistoryLimit + 5, 5, 6, roomHistoryLimit, true},
+		{"gap partly dropped", roomHistoryLimit + 5, 2, 6, roomHistoryLimit, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRoom(defaultRoom, testStore(t))
+			for i := 0; i < tt.published; i++ {
+				r.publish(Event{Type: eventMessage}, func(Event) {})
+			}
+			var got []Event
+			var complete bool
+			r.replay(tt.after, func(events []Event, c bool) {
+				got, complete = events, c
+			})
+			if len(got) != tt.wantCount || complete != tt.wantComplete {
+				t.Fatalf("replay(%d) = %d events, complete %v; want %d, %v", tt.after, len(got), complete, tt.wantCount, tt.wantComplete)
+			}
+			if len(got) > 0 && got[0].Seq != tt.wantFirst {
+				t.Errorf("first replayed seq = %d, want %d", got[0].Seq, tt.wantFirst)
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].Seq != got[i-1].Seq+1 || got[i].Room != defaultRoom {
+					t.Fatalf("event %d = %s #%d after #%d", i, got[i].Room, got[i].Seq, got[i-1].Seq)
+				}
+			}
+		})
+	}
+}
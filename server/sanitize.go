@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sanitizeText removes the characters a terminal acts on instead of printing
+// them: C0 and C1 control characters, which start ANSI and OSC escape
+// sequences, carriage returns and backspaces. Newlines and tabs are kept, as
+// code snippets need them. Invalid UTF-8 is replaced too, since a stray byte
+// such as 0x9b is read as a control sequence by some terminals.
+func sanitizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, "�"))
+}
+
+// sanitizeName removes every control character from a nickname, newlines and
+// tabs included.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, ""))
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"line one\nline two\tend", "line one\nline two\tend"},
+		{"\x1b[31mred\x1b[0m", "[31mred[0m"},
+		{"\x1b]0;pwned\x07title", "]0;pwnedtitle"},
+		{"over\rwrite", "overwrite"},
+		{"back\bspace", "backspace"},
+		{"c1 \u009b2J", "c1 2J"},
+		{"bad \x9b byte", "bad � byte"},
+		{"emoji 🎉 and ünïcode", "emoji 🎉 and ünïcode"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.in); got != tt.want {
+			t.Errorf("sanitizeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"al\nice", "alice"},
+		{"al\tice", "alice"},
+		{"\x1b[2Jalice", "[2Jalice"},
+		{"al\xffice", "alice"},
+		{"zoë", "zoë"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeName(tt.in); got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
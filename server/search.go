@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// searchResultLimit bounds how many hits one /search returns.
+	searchResultLimit = 20
+	// searchContext is how many messages before and after a hit are sent
+	// along with it.
+	searchContext = 2
+	// searchDateLayout is the format of before: and after: dates.
+	searchDateLayout = "2006-01-02"
+)
+
+const searchUsage = "Usage: /search <words> [in:#room] [from:nick] [before:YYYY-MM-DD] [after:YYYY-MM-DD]"
+
+// searchIndex is an inverted index of the messages published to rooms. It is
+// updated as messages are stored, edited and deleted, so /search never has to
+// scan the history.
+type searchIndex struct {
+	mux      sync.RWMutex
+	postings map[string]map[string]int // term → message ID → occurrences
+	docs     map[string]*Event         // indexed messages keyed by ID
+	rooms    map[string][]*Event       // indexed messages of each room in sequence order
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		docs:     make(map[string]*Event),
+		rooms:    make(map[string][]*Event),
+	}
+}
+
+// tokenize splits text into the lower-case words it is indexed under.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// add indexes a message just published to a room. It must be called in
+// sequence order, which room.publish guarantees.
+func (x *searchIndex) add(ev Event) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	doc := ev
+	x.docs[doc.ID] = &doc
+	x.rooms[doc.Room] = append(x.rooms[doc.Room], &doc)
+	x.addTerms(&doc)
+}
+
+// update re-indexes a stored message after it was edited, and drops it once
+// it is deleted.
+func (x *searchIndex) update(msg Event) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	doc, ok := x.docs[msg.ID]
+	if !ok {
+		return
+	}
+	x.removeTerms(doc)
+	if msg.Deleted {
+		delete(x.docs, msg.ID)
+		x.rooms[msg.Room] = slices.DeleteFunc(x.rooms[msg.Room], func(d *Event) bool { return d == doc })
+		return
+	}
+	*doc = msg
+	x.addTerms(doc)
+}
+
+// removeRoom drops every message of room from the index.
+func (x *searchIndex) removeRoom(room string) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	for _, doc := range x.rooms[room] {
+		x.removeTerms(doc)
+		delete(x.docs, doc.ID)
+	}
+	delete(x.rooms, room)
+}
+
+// removeMessages drops events, purged from the history of room, from the
+// index.
+func (x *searchIndex) removeMessages(room string, events []Event) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	purged := make(map[string]bool, len(events))
+	for _, ev := range events {
+		doc, ok := x.docs[ev.ID]
+		if !ok || purged[ev.ID] {
+			continue
+		}
+		purged[ev.ID] = true
+		x.removeTerms(doc)
+		delete(x.docs, ev.ID)
+	}
+	x.rooms[room] = slices.DeleteFunc(x.rooms[room], func(d *Event) bool { return purged[d.ID] })
+}
+
+// addTerms adds doc to the postings of its words. The caller must hold x.mux.
+func (x *searchIndex) addTerms(doc *Event) {
+	for _, term := range tokenize(doc.Text) {
+		postings := x.postings[term]
+		if postings == nil {
+			postings = make(map[string]int)
+			x.postings[term] = postings
+		}
+		postings[doc.ID]++
+	}
+}
+
+// removeTerms removes doc from the postings of its words. The caller must
+// hold x.mux.
+func (x *searchIndex) removeTerms(doc *Event) {
+	for _, term := range tokenize(doc.Text) {
+		delete(x.postings[term], doc.ID)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+}
+
+// searchQuery is a parsed /search request.
+type searchQuery struct {
+	terms  []string
+	room   string
+	from   string
+	before time.Time // only messages sent before this
+	after  time.Time // only messages sent at or after this
+}
+
+// parseSearchQuery splits the arguments of /search into words to look for
+// and in:, from:, before: and after: filters. Dates are days in the server's
+// time zone; after: means after the end of that day.
+func parseSearchQuery(args string) (searchQuery, error) {
+	var q searchQuery
+	for _, word := range strings.Fields(args) {
+		key, value, _ := strings.Cut(word, ":")
+		switch key {
+		case "in":
+			q.room = strings.TrimPrefix(value, "#")
+			continue
+		case "from":
+			q.from = strings.TrimPrefix(value, "@")
+			continue
+		case "before", "after":
+			day, err := time.ParseInLocation(searchDateLayout, value, time.Local)
+			if err != nil {
+				return q, fmt.Errorf("Invalid date '%s'. Use YYYY-MM-DD.", value)
+			}
+			if key == "before" {
+				q.before = day
+			} else {
+				q.after = day.AddDate(0, 0, 1)
+			}
+			continue
+		}
+		for _, term := range tokenize(word) {
+			if !slices.Contains(q.terms, term) {
+				q.terms = append(q.terms, term)
+			}
+		}
+	}
+	if len(q.terms) == 0 && q.room == "" && q.from == "" && q.before.IsZero() && q.after.IsZero() {
+		return q, errors.New(searchUsage)
+	}
+	return q, nil
+}
+
+// matches reports whether doc passes the filters of q.
+func (q searchQuery) matches(doc *Event) bool {
+	switch {
+	case q.room != "" && doc.Room != q.room:
+		return false
+	case q.from != "" && doc.From != q.from:
+		return false
+	case !q.before.IsZero() && !doc.Time.Before(q.before):
+		return false
+	case !q.after.IsZero() && doc.Time.Before(q.after):
+		return false
+	}
+	return true
+}
+
+// search returns copies of the messages in rooms that contain every word of
+// q and pass its filters, best match first, each with the messages around it
+// as Context. Matches are ranked by TF-IDF, newer messages first on a tie; a
+// query without words just lists the newest messages that pass the filters.
+func (x *searchIndex) search(q searchQuery, rooms []string) []Event {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+
+	scores := make(map[*Event]float64)
+	if len(q.terms) == 0 {
+		for _, room := range rooms {
+			for _, doc := range x.rooms[room] {
+				scores[doc] = 0
+			}
+		}
+	}
+	for i, term := range q.terms {
+		postings := x.postings[term]
+		if len(postings) == 0 {
+			return nil
+		}
+		idf := math.Log(1 + float64(len(x.docs))/float64(len(postings)))
+		next := make(map[*Event]float64)
+		for id, n := range postings {
+			doc := x.docs[id]
+			score, ok := scores[doc]
+			if i > 0 && !ok {
+				continue
+			}
+			next[doc] = score + (1+math.Log(float64(n)))*idf
+		}
+		scores = next
+	}
+
+	hits := make([]*Event, 0, len(scores))
+	for doc := range scores {
+		if slices.Contains(rooms, doc.Room) && q.matches(doc) {
+			hits = append(hits, doc)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if scores[hits[i]] != scores[hits[j]] {
+			return scores[hits[i]] > scores[hits[j]]
+		}
+		return hits[i].Time.After(hits[j].Time)
+	})
+	if len(hits) > searchResultLimit {
+		hits = hits[:searchResultLimit]
+	}
+
+	results := make([]Event, len(hits))
+	for i, doc := range hits {
+		results[i] = *doc
+		results[i].Context = x.context(doc)
+	}
+	return results
+}
+
+// context returns the messages published to doc's room just before and after
+// it, oldest first. The caller must hold x.mux.
+func (x *searchIndex) context(doc *Event) []Event {
+	docs := x.rooms[doc.Room]
+	i := sort.Search(len(docs), func(i int) bool { return docs[i].Seq >= doc.Seq })
+	var context []Event
+	for j := max(0, i-searchContext); j < min(len(docs), i+searchContext+1); j++ {
+		if j != i {
+			context = append(context, *docs[j])
+		}
+	}
+	return context
+}
+
+// searchMessages handles /search: it sends client the best matches among the
+// rooms it can read, leaving out those its ignore and block lists hide.
+func (s *Server) searchMessages(client *Client, args string) {
+	q, err := parseSearchQuery(args)
+	if err != nil {
+		client.sendSystem(err.Error())
+		return
+	}
+	if q.room != "" && (s.room(q.room) == nil || !s.canAccess(client, q.room)) {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", q.room))
+		return
+	}
+
+	s.roomsMux.RLock()
+	rooms := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		rooms = append(rooms, name)
+	}
+	s.roomsMux.RUnlock()
+	rooms = slices.DeleteFunc(rooms, func(name string) bool { return !s.canAccess(client, name) })
+
+	results := client.filterEvents(s.index.search(q, rooms))
+	log.Printf("Search from %s: %d results", client.ip, len(results))
+	if err := client.send(Event{Type: eventSearch, Text: args, History: results, Time: time.Now()}); err != nil {
+		log.Printf("Error sending search results to %s: %v", client.ip, err)
+	}
+}
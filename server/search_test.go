@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation(searchDateLayout, s, time.Local)
+		return d
+	}
+	tests := []struct {
+		args    string
+		want    searchQuery
+		wantErr bool
+	}{
+		{"Deploy failed", searchQuery{terms: []string{"deploy", "failed"}}, false},
+		{"deploy deploy, DEPLOY", searchQuery{terms: []string{"deploy"}}, false},
+		{"in:#lobby from:@alice", searchQuery{room: "lobby", from: "alice"}, false},
+		{"x before:2026-01-02", searchQuery{terms: []string{"x"}, before: day("2026-01-02")}, false},
+		{"after:2026-01-02", searchQuery{after: day("2026-01-03")}, false},
+		{"after:yesterday", searchQuery{}, true},
+		{"", searchQuery{}, true},
+		{"!!!", searchQuery{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSearchQuery(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!slices.Equal(got.terms, tt.want.terms) || got.room != tt.want.room || got.from != tt.want.from ||
+			!got.before.Equal(tt.want.before) || !got.after.Equal(tt.want.after)) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	x := newSearchIndex()
+	now := time.Now()
+	msgs := []Event{
+		{ID: "a", Room: "lobby", Seq: 1, From: "alice", Text: "deploy is done", Time: now},
+		{ID: "b", Room: "lobby", Seq: 2, From: "bob", Text: "deploy deploy deploy failed", Time: now.Add(time.Second)},
+		{ID: "c", Room: "secret", Seq: 1, From: "carol", Text: "deploy secrets", Time: now},
+		{ID: "d", Room: "lobby", Seq: 3, From: "alice", Text: "lunch?", Time: now.Add(2 * time.Second)},
+	}
+	for _, ev := range msgs {
+		x.add(ev)
+	}
+	edited := msgs[3]
+	edited.Text = "lunch after the deploy?"
+	x.update(edited)
+
+	tests := []struct {
+		name  string
+		args  string
+		rooms []string
+		want  []string
+	}{
+		{"ranked by term frequency", "deploy", []string{"lobby"}, []string{"b", "d", "a"}},
+		{"all words must match", "deploy failed", []string{"lobby"}, []string{"b"}},
+		{"edited text is indexed", "lunch", []string{"lobby"}, []string{"d"}},
+		{"unreadable rooms left out", "secrets", []string{"lobby"}, nil},
+		{"readable rooms searched", "secrets", []string{"lobby", "secret"}, []string{"c"}},
+		{"from filter", "deploy from:alice", []string{"lobby"}, []string{"d", "a"}},
+		{"filters only, newest first", "from:alice", []string{"lobby"}, []string{"d", "a"}},
+		{"unknown word", "rollback", []string{"lobby"}, nil},
+	}
+	for _, tt := range tests {
+		q, err := parseSearchQuery(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ev := range x.search(q, tt.rooms) {
+			got = append(got, ev.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: search(%q) = %v, want %v", tt.name, tt.args, got, tt.want)
+		}
+	}
+
+	x.update(Event{ID: "b", Room: "lobby", Deleted: true})
+	if hits := x.search(searchQuery{terms: []string{"failed"}}, []string{"lobby"}); len(hits) != 0 {
+		t.Errorf("deleted message still found: %+v", hits)
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,24 +19,160 @@ import (
 
 type Client struct {
 	conn     *websocket.Conn
+	writeMux sync.Mutex // gorilla/websocket allows only one concurrent writer
 	ip       string
+
+	nameMux  sync.RWMutex // guards username, which /nick changes while others read it
 	username string
+
+	stateMux      sync.Mutex // guards the fields below
+	presence      string
+	awayMessage   string
+	autoAway      bool // away was set by idle detection
+	lastActive    time.Time
+	connectedAt   time.Time
+	lastMessage   string
+	lastMessageAt time.Time
+	lastMessageID string
+	admin         bool
+	identified    bool            // proved ownership of a registered username
+	pubKey        string          // public key for end-to-end encrypted PMs, base64
+	ignored       map[string]bool // users whose room messages are not sent to this client
+	blocked       map[string]bool // users who cannot send it PMs, typing or mentions
+
+	upload *upload // file being received; only touched by the read loop
+}
+
+// name returns the client's username, or "" if it has none yet.
+func (c *Client) name() string {
+	c.nameMux.RLock()
+	defer c.nameMux.RUnlock()
+	return c.username
+}
+
+// setName changes the client's username.
+func (c *Client) setName(username string) {
+	c.nameMux.Lock()
+	defer c.nameMux.Unlock()
+	c.username = username
+}
+
+// send encodes ev and writes it to the client's connection.
+func (c *Client) send(ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", ev.Type, err)
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// sendBinary writes a chunk of file data to the client's connection.
+func (c *Client) sendBinary(data []byte) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// sendSystem writes a server notice to the client.
+func (c *Client) sendSystem(text string) error {
+	return c.send(Event{Type: eventSystem, Text: text})
+}
+
+// sendAckError tells the client that the message it tagged with clientID was
+// rejected.
+func (c *Client) sendAckError(clientID, reason string) error {
+	return c.send(Event{Type: eventAck, ClientID: clientID, Error: reason})
 }
 
 type Server struct {
-	clients    map[*Client]bool
-	clientsMux sync.RWMutex
-	upgrader   websocket.Upgrader
+	clients          map[*Client]bool
+	clientsMux       sync.RWMutex
+	upgrader         websocket.Upgrader
+	rooms            map[string]*Room
+	roomsMux         sync.RWMutex
+	seen             map[string]seenRecord // keyed by username, for /seen
+	seenMux          sync.RWMutex
+	mentions         map[string][]Event // mentions inbox keyed by username
+	mentionsMux      sync.Mutex
+	accounts         map[string]*Account // registered usernames
+	accountsMux      sync.RWMutex
+	mailboxes        map[string][]Event // PMs waiting for offline registered users
+	mailboxMux       sync.Mutex
+	conversations    map[string]*Conversation // group conversations keyed by ID
+	conversationsMux sync.RWMutex
+	files            map[string]*StoredFile // shared files keyed by ID
+	filesMux         sync.Mutex
+	index            *searchIndex // full-text index of room messages for /search
+	retention        retentionRules
+	purges           retentionMetrics
+	retentionMux     sync.Mutex           // guards retention and purges
+	roomInfo         map[string]*RoomInfo // topics and pins keyed by room
+	roomInfoMux      sync.Mutex
+	store            *Store
+	config           Config
 }
 
-func NewServer() *Server {
+// loadState reads the accounts, undelivered messages, shared files,
+// conversations, retention settings, room topics and pins and room history
+// kept in the store.
+func (s *Server) loadState() error {
+	if err := s.loadAccounts(); err != nil {
+		return err
+	}
+	if err := s.loadMailboxes(); err != nil {
+		return err
+	}
+	if err := s.loadFiles(); err != nil {
+		return err
+	}
+	if err := s.loadConversations(); err != nil {
+		return err
+	}
+	if err := s.loadRetention(); err != nil {
+		return err
+	}
+	if err := s.loadRooms(); err != nil {
+		return err
+	}
+	return s.loadHistory()
+}
+
+func NewServer(config Config, store *Store) *Server {
 	return &Server{
-		clients:  make(map[*Client]bool),
-		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		config:        config,
+		store:         store,
+		accounts:      make(map[string]*Account),
+		mailboxes:     make(map[string][]Event),
+		conversations: make(map[string]*Conversation),
+		roomInfo:      make(map[string]*RoomInfo),
+		files:         make(map[string]*StoredFile),
+		index:         newSearchIndex(),
+		purges:        retentionMetrics{purged: make(map[string]purgeStats)},
+		clients:       make(map[*Client]bool),
+		upgrader:      websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		rooms:         map[string]*Room{defaultRoom: newRoom(defaultRoom, store)},
+		seen:          make(map[string]seenRecord),
+		mentions:      make(map[string][]Event),
 	}
 }
 
+// room returns the named room, or nil if it does not exist.
+func (s *Server) room(name string) *Room {
+	s.roomsMux.RLock()
+	defer s.roomsMux.RUnlock()
+	return s.rooms[name]
+}
+
 func (s *Server) addClient(client *Client) {
+	client.stateMux.Lock()
+	client.connectedAt = time.Now()
+	client.stateMux.Unlock()
+
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 	s.clients[client] = true
@@ -40,14 +181,15 @@ func (s *Server) addClient(client *Client) {
 func (s *Server) removeClient(client *Client) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
-	username := client.username
+	username := client.name()
 	delete(s.clients, client)
+	s.recordSeen(client, username)
 
 	if username != "" {
-		leaveMsg := fmt.Sprintf("[Server] %s has left the chat.", username)
+		leaveMsg := fmt.Sprintf("%s has left the chat.", username)
 		log.Println(leaveMsg)
 		// We need to use a goroutine to broadcast since we're holding the lock
-		go s.broadcastMessage(websocket.TextMessage, []byte(leaveMsg), nil)
+		go s.broadcastSystem(leaveMsg)
 	}
 }
 
@@ -57,14 +199,16 @@ func (s *Server) handleWebSocket(c echo.Context) error {
 		return fmt.Errorf("websocket upgrade error: %w", err)
 	}
 
-	client := &Client{conn: ws, ip: ws.RemoteAddr().String(), username: ""}
+	client := &Client{conn: ws, ip: ws.RemoteAddr().String(), username: "", presence: presenceOnline, lastActive: time.Now()}
+	ws.SetReadLimit(s.readLimit())
 	s.addClient(client)
 	log.Printf("New client connected: %s", client.ip)
 
 	defer func() {
+		s.abortUpload(client, "")
 		s.removeClient(client)
 		ws.Close()
-		log.Printf("Client disconnected: %s (Username: %s)", client.ip, client.username)
+		log.Printf("Client disconnected: %s (Username: %s)", client.ip, client.name())
 	}()
 
 	err = s.handleClientMessages(client)
@@ -88,74 +232,208 @@ func (s *Server) handleClientMessages(client *Client) error {
 			}
 			return nil
 		}
+		if messageType == websocket.BinaryMessage {
+			s.receiveChunk(client, p)
+			continue
+		}
 
-		message := string(p)
-		log.Printf("Received from %s: %s", client.ip, message)
+		// Nothing a client sends may carry escape sequences to the
+		// terminals of others
+		cmd := parseCommand(p)
+		message := sanitizeText(cmd.Text)
+		log.Printf("Received from %s: %s", client.ip, redactSecrets(message))
 
 		if len(message) == 0 {
 			log.Printf("Received empty message from %s, ignoring", client.ip)
 			continue
 		}
+		s.markActive(client, !isPresenceCommand(message))
 
 		if strings.HasPrefix(message, "/nick ") {
-			parts := strings.SplitN(message, " ", 2)
-			if len(parts) == 2 {
-				newUsername := strings.TrimSpace(parts[1])
-				if newUsername != "" && len(newUsername) < 20 {
-					if s.isUsernameTaken(newUsername, client) {
-						client.conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("[Server] Username '%s' is already taken.", newUsername)))
-					} else {
-						oldUsername := client.username
-						client.username = newUsername
-
-						if oldUsername == "" {
-							joinMsg := fmt.Sprintf("[Server] %s has joined the chat.", newUsername)
-							log.Println(joinMsg)
-							s.broadcastMessage(websocket.TextMessage, []byte(joinMsg), nil)
-						} else if oldUsername != newUsername {
-							changeMsg := fmt.Sprintf("[Server] %s changed nickname to %s.", oldUsername, newUsername)
-							log.Println(changeMsg)
-							s.broadcastMessage(websocket.TextMessage, []byte(changeMsg), nil)
-						}
-
-						client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Username set to "+newUsername))
-					}
-				} else {
-					client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Invalid username."))
-				}
-			} else {
-				client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Usage: /nick <username>"))
-			}
+			s.changeNick(client, cmd.ClientID, strings.TrimPrefix(message, "/nick "))
 			continue
 		} else if message == "/list" {
 			log.Printf("Client %s requested user list", client.ip)
 			s.sendClientList(client)
 			continue
 		} else if message == "/listips" {
-			log.Printf("Client %s (%s) requested IP list", client.username, client.ip)
+			log.Printf("Client %s (%s) requested IP list", client.name(), client.ip)
 			s.sendClientIPList(client)
 			continue
+		} else if strings.HasPrefix(message, "/resume ") {
+			parts := strings.Fields(message)
+			if len(parts) == 3 {
+				s.resumeRoom(client, parts[1], parts[2])
+			} else {
+				client.sendSystem("Usage: /resume <room> <last seq>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/typing ") {
+			parts := strings.Fields(message)
+			if len(parts) == 3 && (parts[1] == "on" || parts[1] == "off") {
+				s.relayTyping(client, parts[1] == "on", parts[2])
+			} else {
+				client.sendSystem("Usage: /typing <on|off> <room|@username>")
+			}
+			continue
+		} else if message == "/away" || strings.HasPrefix(message, "/away ") {
+			s.setPresence(client, presenceAway, strings.TrimSpace(strings.TrimPrefix(message, "/away")))
+			continue
+		} else if message == "/back" {
+			s.setPresence(client, presenceOnline, "")
+			continue
+		} else if strings.HasPrefix(message, "/status ") {
+			switch status := strings.TrimSpace(strings.TrimPrefix(message, "/status ")); status {
+			case presenceOnline, presenceAway, presenceBusy:
+				s.setPresence(client, status, "")
+			default:
+				client.sendSystem("Usage: /status busy|away|online")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/whois ") {
+			s.sendWhois(client, strings.TrimSpace(strings.TrimPrefix(message, "/whois ")))
+			continue
+		} else if strings.HasPrefix(message, "/seen ") {
+			s.sendSeen(client, strings.TrimSpace(strings.TrimPrefix(message, "/seen ")))
+			continue
+		} else if strings.HasPrefix(message, "/admin ") {
+			s.authenticateAdmin(client, strings.TrimSpace(strings.TrimPrefix(message, "/admin ")))
+			continue
+		} else if strings.HasPrefix(message, "/edit ") {
+			parts := strings.SplitN(message, " ", 3)
+			if len(parts) == 3 && strings.TrimSpace(parts[2]) != "" {
+				s.editMessage(client, strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
+			} else {
+				client.sendSystem("Usage: /edit <id> <text>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/delete ") {
+			s.deleteMessage(client, strings.TrimSpace(strings.TrimPrefix(message, "/delete ")))
+			continue
+		} else if strings.HasPrefix(message, "/react ") {
+			parts := strings.Fields(message)
+			if len(parts) == 3 {
+				s.toggleReaction(client, parts[1], parts[2])
+			} else {
+				client.sendSystem("Usage: /react <id> <emoji|:shortcode:>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/reply ") {
+			parts := strings.SplitN(message, " ", 3)
+			if len(parts) == 3 && strings.TrimSpace(parts[2]) != "" {
+				s.replyToMessage(client, cmd.ClientID, strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
+			} else {
+				client.sendSystem("Usage: /reply <id> <message>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/thread ") {
+			s.sendThread(client, strings.TrimSpace(strings.TrimPrefix(message, "/thread ")))
+			continue
+		} else if strings.HasPrefix(message, "/search ") {
+			s.searchMessages(client, strings.TrimSpace(strings.TrimPrefix(message, "/search ")))
+			continue
+		} else if message == "/mentions" {
+			s.sendMentions(client)
+			continue
+		} else if strings.HasPrefix(message, "/register ") {
+			s.register(client, strings.TrimSpace(strings.TrimPrefix(message, "/register ")))
+			continue
+		} else if strings.HasPrefix(message, "/identify ") {
+			s.identify(client, strings.TrimSpace(strings.TrimPrefix(message, "/identify ")))
+			continue
+		} else if strings.HasPrefix(message, "/dm ") {
+			parts := strings.SplitN(message, " ", 3)
+			if len(parts) == 3 && strings.TrimSpace(parts[1]) != "" && strings.TrimSpace(parts[2]) != "" {
+				s.sendDirectMessage(client, cmd.ClientID, strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
+			} else {
+				client.sendAckError(cmd.ClientID, "Usage: /dm <user1,user2,...|conversation> <message>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/invite ") {
+			parts := strings.Fields(message)
+			if len(parts) == 3 {
+				s.inviteToConversation(client, parts[1], parts[2])
+			} else {
+				client.sendSystem("Usage: /invite <conversation> <username>")
+			}
+			continue
+		} else if strings.HasPrefix(message, "/leave ") {
+			s.leaveConversation(client, strings.TrimSpace(strings.TrimPrefix(message, "/leave ")))
+			continue
+		} else if strings.HasPrefix(message, "/upload ") {
+			s.startUpload(client, cmd.ClientID, strings.TrimSpace(strings.TrimPrefix(message, "/upload ")))
+			continue
+		} else if strings.HasPrefix(message, "/download ") {
+			s.sendFile(client, strings.TrimSpace(strings.TrimPrefix(message, "/download ")))
+			continue
+		} else if message == "/retention" || strings.HasPrefix(message, "/retention ") {
+			s.handleRetention(client, strings.TrimSpace(strings.TrimPrefix(message, "/retention")))
+			continue
+		} else if strings.HasPrefix(message, "/hold ") {
+			s.setLegalHold(client, strings.TrimSpace(strings.TrimPrefix(message, "/hold ")), true)
+			continue
+		} else if strings.HasPrefix(message, "/unhold ") {
+			s.setLegalHold(client, strings.TrimSpace(strings.TrimPrefix(message, "/unhold ")), false)
+			continue
+		} else if message == "/compact" {
+			s.compactNow(client)
+			continue
+		} else if message == "/dms" {
+			s.listConversations(client)
+			continue
 		} else if message == "/exit" {
-			log.Printf("Client %s (%s) requested disconnect.", client.username, client.ip)
+			log.Printf("Client %s (%s) requested disconnect.", client.name(), client.ip)
 			return nil
 		} else if strings.HasPrefix(message, "/pm ") {
-			parts := strings.SplitN(message, " ", 3)
+			// Only clients can encrypt; a plaintext PM must never reach the
+			// server, let alone its log
+			client.sendAckError(cmd.ClientID, "Private messages are end-to-end encrypted. Please update your client.")
+			continue
+		} else if strings.HasPrefix(message, "/epm ") {
+			parts := strings.Fields(message)
 			if len(parts) == 3 {
-				targetUsername := strings.TrimSpace(parts[1])
-				pmText := strings.TrimSpace(parts[2])
-				if targetUsername != "" && pmText != "" {
-					s.sendPrivateMessage(client, targetUsername, pmText)
-				} else {
-					client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Usage: /pm <username> <message>"))
-				}
+				s.sendSealedMessage(client, cmd.ClientID, parts[1], parts[2])
 			} else {
-				client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Usage: /pm <username> <message>"))
+				client.sendAckError(cmd.ClientID, "Usage: /epm <username> <sealed message>")
 			}
 			continue
+		} else if message == "/topic" || strings.HasPrefix(message, "/topic ") {
+			room, text, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(message, "/topic")), " ")
+			if room == "" {
+				room = defaultRoom
+			}
+			s.setTopic(client, room, strings.TrimSpace(text))
+			continue
+		} else if strings.HasPrefix(message, "/pin ") {
+			s.pinMessage(client, strings.TrimSpace(strings.TrimPrefix(message, "/pin ")), true)
+			continue
+		} else if strings.HasPrefix(message, "/unpin ") {
+			s.pinMessage(client, strings.TrimSpace(strings.TrimPrefix(message, "/unpin ")), false)
+			continue
+		} else if message == "/pins" || strings.HasPrefix(message, "/pins ") {
+			room := strings.TrimSpace(strings.TrimPrefix(message, "/pins"))
+			if room == "" {
+				room = defaultRoom
+			}
+			s.sendPins(client, room)
+			continue
+		} else if isIgnoreCommand(message) {
+			command, username, _ := strings.Cut(message, " ")
+			s.updateIgnores(client, command, strings.TrimSpace(username))
+			continue
+		} else if message == "/ignores" {
+			s.sendIgnores(client)
+			continue
+		} else if strings.HasPrefix(message, "/pubkey ") {
+			s.publishKey(client, strings.TrimSpace(strings.TrimPrefix(message, "/pubkey ")))
+			continue
+		} else if strings.HasPrefix(message, "/key ") {
+			s.sendKey(client, strings.TrimSpace(strings.TrimPrefix(message, "/key ")))
+			continue
 		}
 
-		if client.username == "" {
-			err := client.conn.WriteMessage(websocket.TextMessage, []byte("[Server] Please set a username first using /nick <username>"))
+		if client.name() == "" {
+			err := client.sendAckError(cmd.ClientID, "Please set a username first using /nick <username>")
 			if err != nil {
 				log.Printf("Error sending username prompt to %s: %v", client.ip, err)
 				return err
@@ -163,70 +441,140 @@ func (s *Server) handleClientMessages(client *Client) error {
 			continue
 		}
 
-		if err := s.broadcastMessage(messageType, []byte(message), client); err != nil {
+		if err := s.broadcastMessage(client, cmd.ClientID, message); err != nil {
 			log.Printf("Error broadcasting message: %v", err)
 		}
 	}
 }
 
-func (s *Server) sendResponse(client *Client, messageType int, msg []byte) error {
-	response := fmt.Sprintf("Hi dear client with %s IP; your message was: %s", client.ip, msg)
-	return client.conn.WriteMessage(messageType, []byte(response))
+// broadcastMessage assigns an ID and room sequence number to a chat line from
+// sender, delivers it to every other client and acknowledges it to sender.
+// This also handles /me actions, which are chat lines too.
+func (s *Server) broadcastMessage(sender *Client, clientID, msg string) error {
+	return s.publishMessage(s.room(defaultRoom), sender, clientID, chatEvent(sender, msg))
 }
 
-func (s *Server) broadcastMessage(messageType int, msg []byte, sender *Client) error {
-	var formattedMsg string
-	if sender != nil {
-		timestamp := time.Now().Format("15:04:05")
-		formattedMsg = fmt.Sprintf("[%s] %s: %s", timestamp, sender.username, msg)
-		log.Printf("Broadcasting from %s (%s): %s", sender.username, sender.ip, msg)
-	} else {
-		formattedMsg = string(msg)
-		log.Println("Broadcasting server message:", formattedMsg)
+// publishMessage gives ev an ID, records it in room, delivers it to every
+// other client and acknowledges it to sender. It returns an error if ev was
+// rejected or could not be published; failing to deliver the ack is only
+// logged, as the message was published all the same.
+func (s *Server) publishMessage(room *Room, sender *Client, clientID string, ev Event) error {
+	if err := s.checkLength(ev); err != nil {
+		sender.sendAckError(clientID, err.Error())
+		return fmt.Errorf("rejected message from %s: %w", sender.ip, err)
+	}
+	id, err := newMessageID()
+	if err != nil {
+		sender.sendAckError(clientID, "The message could not be sent.")
+		return err
+	}
+	ev.ID = id
+	ev.author = sender
+	if !ev.Code {
+		ev.Mentions = s.onlyMembers(room.name, s.knownUsers(parseMentions(ev.Text)))
+	}
+
+	room.publish(ev, func(ev Event) {
+		// /seen shows the last message to anyone, so only public ones count
+		if !isConversation(ev.Room) {
+			sender.recordMessage(ev)
+		}
+		s.recordMentions(ev)
+		s.index.add(ev)
+		log.Printf("Broadcasting %s (%s #%d) from %s (%s): %s", ev.ID, ev.Room, ev.Seq, sender.name(), sender.ip, ev.Text)
+		s.broadcast(ev, sender)
+
+		// The ack goes out under the room lock too, so the sender never sees
+		// a later sequence number before its own message.
+		ack := ev
+		ack.Type = eventAck
+		ack.ClientID = clientID
+		if err := sender.send(ack); err != nil {
+			log.Printf("Error acknowledging %s to %s: %v", ev.ID, sender.ip, err)
+		}
+	})
+	return nil
+}
+
+// broadcastSystem sends a server notice to every connected client.
+func (s *Server) broadcastSystem(msg string) {
+	log.Println("Broadcasting server message:", msg)
+	s.broadcast(Event{Type: eventSystem, Text: msg, Time: time.Now()}, nil)
+}
+
+// broadcast writes ev to every connected client except skip. Events of a
+// group conversation only go to its members, and each client's ignore and
+// block lists are applied.
+func (s *Server) broadcast(ev Event, skip *Client) {
+	private := isConversation(ev.Room)
+	var members []string
+	if private {
+		members = s.conversationMembers(ev.Room)
 	}
 
 	s.clientsMux.RLock()
 	defer s.clientsMux.RUnlock()
 
 	for client := range s.clients {
-		if client != sender {
-			if err := client.conn.WriteMessage(messageType, []byte(formattedMsg)); err != nil {
+		if private && !client.isMemberOf(members) {
+			continue
+		}
+		if client == skip {
+			continue
+		}
+		if ev, ok := client.filterEvent(ev); ok {
+			if err := client.send(ev); err != nil {
 				log.Printf("Error sending message to %s: %v. Removing client.", client.ip, err)
 			}
 		}
 	}
-	return nil
+}
+
+// resumeRoom replays the messages of roomName that came after the sequence
+// number the client last rendered.
+func (s *Server) resumeRoom(client *Client, roomName, lastSeq string) {
+	room := s.room(roomName)
+	if room == nil || !s.canAccess(client, roomName) {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", roomName))
+		return
+	}
+	after, err := strconv.ParseUint(lastSeq, 10, 64)
+	if err != nil {
+		client.sendSystem("Usage: /resume <room> <last seq>")
+		return
+	}
+
+	room.replay(after, func(events []Event, complete bool) {
+		ev := Event{Type: eventReplay, Room: room.name, Seq: after, History: client.filterEvents(events)}
+		if !complete {
+			ev.Text = "Some earlier messages are no longer available."
+		}
+		if err := client.send(ev); err != nil {
+			log.Printf("Error replaying %s to %s: %v", room.name, client.ip, err)
+			return
+		}
+		log.Printf("Replayed %d messages of %s after #%d to %s", len(events), room.name, after, client.ip)
+	})
 }
 
 func (s *Server) sendClientList(requestingClient *Client) {
 	var usernames []string
 	s.clientsMux.RLock()
 	for c := range s.clients {
-		username := c.username
+		username := c.name()
 		if username == "" {
 			continue
 		}
-		usernames = append(usernames, username)
+		usernames = append(usernames, username+c.presenceLabel())
 	}
 	s.clientsMux.RUnlock()
 
-	listMsg := "[Server] Connected users: " + strings.Join(usernames, ", ")
-	if err := requestingClient.conn.WriteMessage(websocket.TextMessage, []byte(listMsg)); err != nil {
+	listMsg := "Connected users: " + strings.Join(usernames, ", ")
+	if err := requestingClient.sendSystem(listMsg); err != nil {
 		log.Printf("Error sending user list to %s: %v", requestingClient.ip, err)
 	}
 }
 
-func (s *Server) isUsernameTaken(username string, requestingClient *Client) bool {
-	s.clientsMux.Lock()
-	defer s.clientsMux.Unlock()
-	for c := range s.clients {
-		if c != requestingClient && c.username == username {
-			return true
-		}
-	}
-	return false
-}
-
 func (s *Server) sendClientIPList(requestingClient *Client) {
 	var clientIPs []string
 	s.clientsMux.RLock()
@@ -235,50 +583,173 @@ func (s *Server) sendClientIPList(requestingClient *Client) {
 	}
 	s.clientsMux.RUnlock()
 
-	listMsg := "[Server] Connected IPs: " + strings.Join(clientIPs, ", ")
-	if err := requestingClient.conn.WriteMessage(websocket.TextMessage, []byte(listMsg)); err != nil {
+	listMsg := "Connected IPs: " + strings.Join(clientIPs, ", ")
+	if err := requestingClient.sendSystem(listMsg); err != nil {
 		log.Printf("Error sending IP list to %s: %v", requestingClient.ip, err)
 	}
 }
 
-func (s *Server) sendPrivateMessage(sender *Client, targetUsername string, message string) {
-	var targetClient *Client
+// findClient returns the connected client using username, in any case, or
+// nil.
+func (s *Server) findClient(username string) *Client {
 	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
 	for c := range s.clients {
-		if c.username == targetUsername {
-			targetClient = c
-			break
+		if sameNick(c.name(), username) {
+			return c
 		}
 	}
-	s.clientsMux.RUnlock()
+	return nil
+}
 
+// relayTyping forwards a typing notification from sender to a room, or to a
+// PM partner when target is "@username". Typing events are never stored.
+func (s *Server) relayTyping(sender *Client, typing bool, target string) {
+	if sender.name() == "" {
+		return
+	}
+	ev := Event{Type: eventStopTyping, From: sender.name(), Time: time.Now()}
+	if typing {
+		ev.Type = eventTyping
+	}
+
+	if username, ok := strings.CutPrefix(target, "@"); ok {
+		targetClient := s.findClient(username)
+		if targetClient == nil || targetClient == sender || targetClient.isBlocking(sender.name()) {
+			return
+		}
+		ev.To = username
+		if err := targetClient.send(ev); err != nil {
+			log.Printf("Error relaying typing to %s: %v", targetClient.ip, err)
+		}
+		return
+	}
+
+	if s.room(target) == nil || !s.canAccess(sender, target) {
+		return
+	}
+	ev.Room = target
+	s.broadcast(ev, sender)
+}
+
+// deliverPrivateMessage sends the private message ev to its target, or keeps
+// it for later if the target is registered but offline or not identified, and
+// acknowledges it to sender.
+func (s *Server) deliverPrivateMessage(sender *Client, clientID string, ev Event) {
+	id, err := newMessageID()
+	if err != nil {
+		log.Printf("Error delivering PM from %s: %v", sender.ip, err)
+		sender.sendAckError(clientID, "The message could not be sent.")
+		return
+	}
+	ev.ID = id
+	ev.To = s.resolveNick(ev.To)
+	targetUsername := ev.To
+	if s.blocks(targetUsername, sender.name()) {
+		errMsg := fmt.Sprintf("%s is not accepting private messages from you.", targetUsername)
+		if err := sender.sendAckError(clientID, errMsg); err != nil {
+			log.Printf("Error sending PM error to %s: %v", sender.ip, err)
+		}
+		return
+	}
+	// A client using a registered name without identifying is not its
+	// owner, who counts as offline until they connect and identify
+	targetClient := s.findClient(targetUsername)
+	if s.isRegistered(targetUsername) && (targetClient == nil || !targetClient.isIdentified()) {
+		s.queueOffline(sender, clientID, ev)
+		return
+	}
 	if targetClient == nil {
-		errMsg := fmt.Sprintf("[Server] User '%s' not found.", targetUsername)
-		if err := sender.conn.WriteMessage(websocket.TextMessage, []byte(errMsg)); err != nil {
+		errMsg := fmt.Sprintf("User '%s' not found.", targetUsername)
+		if err := sender.sendAckError(clientID, errMsg); err != nil {
 			log.Printf("Error sending PM error to %s: %v", sender.ip, err)
 		}
 		return
 	}
 
-	timestamp := time.Now().Format("15:04:05")
-	msgToTarget := fmt.Sprintf("[%s] [PM from %s]: %s", timestamp, sender.username, message)
-	msgToSender := fmt.Sprintf("[%s] [PM to %s]: %s", timestamp, targetUsername, message)
-
-	if err := targetClient.conn.WriteMessage(websocket.TextMessage, []byte(msgToTarget)); err != nil {
+	if err := targetClient.send(ev); err != nil {
 		log.Printf("Error sending PM to target %s: %v", targetClient.ip, err)
 	}
 
-	if err := sender.conn.WriteMessage(websocket.TextMessage, []byte(msgToSender)); err != nil {
+	ack := ev
+	ack.Type = eventAck
+	ack.ClientID = clientID
+	if err := sender.send(ack); err != nil {
 		log.Printf("Error sending PM confirmation to sender %s: %v", sender.ip, err)
 	}
 
-	log.Printf("PM from %s to %s relayed.", sender.username, targetUsername)
+	log.Printf("PM %s from %s to %s relayed.", ev.ID, sender.name(), targetUsername)
+
+	if presence, awayMessage := targetClient.presenceState(); presence == presenceAway {
+		id, err := newMessageID()
+		if err != nil {
+			log.Printf("Error sending away reply to %s: %v", sender.ip, err)
+			return
+		}
+		reply := Event{
+			Type: eventPM,
+			ID:   id,
+			From: targetUsername,
+			To:   sender.name(),
+			Text: awayMessage,
+			Auto: true,
+			Time: time.Now(),
+		}
+		if err := sender.send(reply); err != nil {
+			log.Printf("Error sending away reply to %s: %v", sender.ip, err)
+		}
+	}
 }
 
 func main() {
-	server := NewServer()
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "export:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "encrypt" {
+		if err := runEncrypt(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "encrypt:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := runKeygen(); err != nil {
+			fmt.Fprintln(os.Stderr, "keygen:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	config := loadConfig()
+	keys, err := loadKeyring(config.KeyFile)
+	if err != nil {
+		log.Fatalf("Error loading storage key: %v", err)
+	}
+	store, err := NewStore(config.DataDir, keys)
+	if err != nil {
+		log.Fatalf("Error opening data store: %v", err)
+	}
+	server := NewServer(config, store)
+	if err := server.loadState(); err != nil {
+		log.Fatalf("Error loading state: %v", err)
+	}
+	go server.reencrypt()
+	if config.IdleTimeout > 0 {
+		go server.watchIdle()
+	}
+	if config.CompactInterval > 0 {
+		go server.watchRetention()
+	}
+
 	e := echo.New()
 	e.GET("/ws", server.handleWebSocket)
+	e.GET("/metrics", server.handleMetrics)
 
 	fmt.Println("Server is running on :8000")
 	if err := e.Start(":8000"); err != nil {
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer returns a server keeping its state in a temporary directory.
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	return NewServer(Config{
+		MailboxQuota:    50,
+		MaxMessageBytes: 2000,
+		MaxSnippetBytes: 64 * 1024,
+		MaxFileBytes:    1000,
+		FileQuota:       2000,
+	}, testStore(t))
+}
+
+// testStore returns a store in a temporary directory.
+func testStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir(), testKeyring(t, 1))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	return store
+}
+
+// testClient returns a client connected over a real websocket, and the other
+// end of that connection, on which the test reads what the server sends.
+func testClient(t *testing.T, username string) (*Client, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading test connection: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing test server: %v", err)
+	}
+	conn := <-conns
+	t.Cleanup(func() {
+		peer.Close()
+		conn.Close()
+	})
+	return &Client{conn: conn, ip: "127.0.0.1", username: username}, peer
+}
+
+// readEvent returns the next event sent to peer.
+func readEvent(t *testing.T, peer *websocket.Conn) Event {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := peer.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+	var ev Event
+	if err := json.Unmarshal(data, &ev); err != nil {
+		t.Fatalf("decoding event %s: %v", data, err)
+	}
+	return ev
+}
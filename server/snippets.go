@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// codeFence opens and closes a code snippet, as in Markdown:
+//
+//	```go
+//	fmt.Println("hi")
+//	```
+const codeFence = "```"
+
+// frameOverhead is the room a frame needs besides the message text, for the
+// JSON envelope and the command in front of the text.
+const frameOverhead = 4096
+
+// parseSnippet splits a fenced code block into its language tag and code. ok
+// is false if text is not a code block. The closing fence may be left out.
+func parseSnippet(text string) (lang, code string, ok bool) {
+	rest, found := strings.CutPrefix(text, codeFence)
+	if !found {
+		return "", "", false
+	}
+	info, code, found := strings.Cut(rest, "\n")
+	if !found {
+		return "", "", false
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = strings.ToLower(fields[0])
+	}
+	code = strings.TrimRight(code, " \t\r\n")
+	code = strings.TrimSuffix(code, codeFence)
+	return lang, strings.TrimRight(code, "\r\n"), true
+}
+
+// setBody fills in ev from what the user typed: a fenced code block becomes a
+// snippet, and anything else is parsed for inline formatting.
+func setBody(ev *Event, text string) {
+	if lang, code, ok := parseSnippet(text); ok {
+		ev.Code, ev.Lang, ev.Text, ev.Spans = true, lang, code, nil
+		return
+	}
+	ev.Code, ev.Lang = false, ""
+	ev.Text, ev.Spans = parseFormatting(text)
+}
+
+// checkLength returns an error if the text of ev is longer than allowed. Code
+// snippets have their own, larger limit.
+func (s *Server) checkLength(ev Event) error {
+	if ev.Code {
+		if len(ev.Text) > s.config.MaxSnippetBytes {
+			return fmt.Errorf("code snippets are limited to %d bytes", s.config.MaxSnippetBytes)
+		}
+		return nil
+	}
+	if len(ev.Text) > s.config.MaxMessageBytes {
+		return fmt.Errorf("messages are limited to %d bytes", s.config.MaxMessageBytes)
+	}
+	return nil
+}
+
+// readLimit is the largest frame a client may send. Anything bigger could
+// never be accepted, so the connection is dropped instead of reading it.
+func (s *Server) readLimit() int64 {
+	return int64(max(s.config.MaxMessageBytes, s.config.MaxSnippetBytes, fileChunkSize) + frameOverhead)
+}
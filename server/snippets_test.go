@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSnippet(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantLang string
+		wantCode string
+		wantOK   bool
+	}{
+		{"```go\nfmt.Println(1)\n```", "go", "fmt.Println(1)", true},
+		{"```Python extra\nprint(1)\n```", "python", "print(1)", true},
+		{"```\nplain\n```", "", "plain", true},
+		{"```sh\necho hi", "sh", "echo hi", true},
+		{"```go\n\tx := 1\n\n```  \n", "go", "\tx := 1", true},
+		{"```go", "", "", false},
+		{"not code", "", "", false},
+		{"text ```go\nx\n```", "", "", false},
+	}
+	for _, tt := range tests {
+		lang, code, ok := parseSnippet(tt.in)
+		if lang != tt.wantLang || code != tt.wantCode || ok != tt.wantOK {
+			t.Errorf("parseSnippet(%q) = %q, %q, %v; want %q, %q, %v", tt.in, lang, code, ok, tt.wantLang, tt.wantCode, tt.wantOK)
+		}
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		ev      Event
+		wantErr bool
+	}{
+		{"short message", Event{Text: "hi"}, false},
+		{"message at limit", Event{Text: strings.Repeat("a", 10)}, false},
+		{"long message", Event{Text: strings.Repeat("a", 11)}, true},
+		{"snippet over message limit", Event{Code: true, Text: strings.Repeat("a", 50)}, false},
+		{"long snippet", Event{Code: true, Text: strings.Repeat("a", 101)}, true},
+	}
+	for _, tt := range tests {
+		s := &Server{config: Config{MaxMessageBytes: 10, MaxSnippetBytes: 100}}
+		if err := s.checkLength(tt.ev); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkLength = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPublishMessageRejectsLongMessage(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr bool
+	}{
+		{"short", false},
+		{strings.Repeat("a", 2001), true},
+	}
+	for _, tt := range tests {
+		s := testServer(t)
+		alice, peer := testClient(t, "alice")
+		err := s.publishMessage(s.room(defaultRoom), alice, "c1", chatEvent(alice, tt.text))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("publishMessage(%d bytes) = %v, want error %v", len(tt.text), err, tt.wantErr)
+		}
+		if ack := readEvent(t, peer); (ack.Error != "") != tt.wantErr {
+			t.Errorf("ack = %+v", ack)
+		}
+		if got := len(s.room(defaultRoom).history); got != map[bool]int{false: 1, true: 0}[tt.wantErr] {
+			t.Errorf("room has %d messages", got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxLineBytes bounds one line of a JSON Lines file, e.g. a stored message
+// with a large code snippet and many reactions.
+const maxLineBytes = 4 << 20
+
+// Store keeps the server's persistent state as JSON and JSON Lines files in a
+// data directory. Every JSON file and every line is encrypted with keys.
+type Store struct {
+	dir  string
+	keys *keyring
+	mux  sync.Mutex // serialises writes so files are never interleaved
+}
+
+// NewStore returns a store rooted at dir, creating the directory if needed.
+func NewStore(dir string, keys *keyring) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	return &Store{dir: dir, keys: keys}, nil
+}
+
+// path returns the location of elem inside the data directory.
+func (st *Store) path(elem ...string) string {
+	return filepath.Join(append([]string{st.dir}, elem...)...)
+}
+
+// load decodes the named file into v. A missing file leaves v untouched.
+func (st *Store) load(name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(st.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	if data, err = st.keys.open(name, bytes.TrimSpace(data)); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return nil
+}
+
+// save encodes v into the named file. The file is replaced atomically so a
+// crash never leaves it half written.
+func (st *Store) save(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", name, err)
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	sealed, err := st.keys.seal(name, data)
+	if err != nil {
+		return err
+	}
+	return st.write(name, append(sealed, '\n'))
+}
+
+// write atomically replaces the named file with data. The caller must hold
+// st.mux.
+func (st *Store) write(name string, data []byte) error {
+	path := filepath.Join(st.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing %s: %w", name, err)
+	}
+	return nil
+}
+
+// appendLine adds v to the end of the named file as one line of JSON,
+// creating the file if needed.
+func (st *Store) appendLine(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", name, err)
+	}
+
+	sealed, err := st.keys.seal(name, data)
+	if err != nil {
+		return err
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	f, err := os.OpenFile(filepath.Join(st.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", name, err)
+	}
+	if _, err := f.Write(append(sealed, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// readLines passes each line of the named file to each, in order. A missing
+// file has no lines.
+func (st *Store) readLines(name string, each func(line []byte) error) error {
+	return st.scanLines(name, func(record []byte) error {
+		line, err := st.keys.open(name, record)
+		if err != nil {
+			return err
+		}
+		return each(line)
+	})
+}
+
+// scanLines is readLines without decryption.
+func (st *Store) scanLines(name string, each func(record []byte) error) error {
+	f, err := os.Open(filepath.Join(st.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxLineBytes)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := each(line); err != nil {
+			return fmt.Errorf("%s line %d: %w", name, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+// replaceLines atomically replaces the named file with lines, each of which
+// is one encoded JSON value.
+func (st *Store) replaceLines(name string, lines [][]byte) error {
+	data, err := st.keys.sealLines(name, lines)
+	if err != nil {
+		return err
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	return st.write(name, data)
+}
+
+// remove deletes the named file. A missing file is not an error.
+func (st *Store) remove(name string) error {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if err := os.Remove(filepath.Join(st.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// replyToMessage posts text as a reply in the thread of parentID. Replies to a
+// reply join the same thread, so threads stay one level deep.
+func (s *Server) replyToMessage(sender *Client, clientID, parentID, text string) {
+	if sender.name() == "" {
+		sender.sendAckError(clientID, "Please set a username first using /nick <username>")
+		return
+	}
+	room := s.roomWithMessage(sender, parentID)
+	if room == nil {
+		sender.sendAckError(clientID, fmt.Sprintf("Message '%s' not found.", parentID))
+		return
+	}
+	parent, ok := room.get(parentID)
+	if !ok {
+		sender.sendAckError(clientID, fmt.Sprintf("Message '%s' not found.", parentID))
+		return
+	}
+	rootID := parent.ID
+	if parent.Thread != "" {
+		rootID = parent.Thread
+	}
+
+	ev := chatEvent(sender, text)
+	ev.Thread = rootID
+	if err := s.publishMessage(room, sender, clientID, ev); err != nil {
+		log.Printf("Error publishing reply: %v", err)
+		return
+	}
+
+	err := room.update(rootID, func(root *Event) error {
+		root.Replies++
+		s.broadcast(Event{Type: eventReplyCount, ID: root.ID, Room: root.Room, Seq: root.Seq, Replies: root.Replies, Time: time.Now()}, nil)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating reply count of %s: %v", rootID, err)
+	}
+}
+
+// sendThread sends client the root message of a thread and all its replies.
+func (s *Server) sendThread(client *Client, id string) {
+	room := s.roomWithMessage(client, id)
+	if room == nil {
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+		return
+	}
+	if msg, ok := room.get(id); ok && msg.Thread != "" {
+		id = msg.Thread
+	}
+
+	thread := room.thread(id)
+	if err := client.send(Event{Type: eventThread, ID: id, Room: room.name, History: client.filterEvents(thread)}); err != nil {
+		log.Printf("Error sending thread %s to %s: %v", id, client.ip, err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestReplyToMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		parent      string // "root" or "reply"
+		wantReplies int
+	}{
+		{"reply to root", "root", 2},
+		{"reply to reply joins the root's thread", "reply", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t)
+			room := s.room(defaultRoom)
+			room.publish(Event{Type: eventMessage, ID: "root", From: "bob"}, func(Event) {})
+			alice, peer := testClient(t, "alice")
+
+			s.replyToMessage(alice, "c1", "root", "first")
+			first := readEvent(t, peer)
+			if first.Type != eventAck || first.Thread != "root" {
+				t.Fatalf("first reply ack = %+v", first)
+			}
+			parent := "root"
+			if tt.parent == "reply" {
+				parent = first.ID
+			}
+			s.replyToMessage(alice, "c2", parent, "second")
+			if second := readEvent(t, peer); second.Thread != "root" {
+				t.Errorf("second reply thread = %q, want root", second.Thread)
+			}
+
+			root, _ := room.get("root")
+			if root.Replies != tt.wantReplies {
+				t.Errorf("root has %d replies, want %d", root.Replies, tt.wantReplies)
+			}
+			if thread := room.thread("root"); len(thread) != tt.wantReplies+1 {
+				t.Errorf("thread has %d messages, want %d", len(thread), tt.wantReplies+1)
+			}
+		})
+	}
+}
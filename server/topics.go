@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// roomsFile keeps the topic and pinned messages of every room.
+	roomsFile = "rooms.json"
+	// maxTopicLength bounds a room topic, in characters.
+	maxTopicLength = 200
+	// maxPins bounds how many messages a room can have pinned.
+	maxPins = 50
+)
+
+// RoomInfo is what members set on a room: its topic and pinned messages.
+type RoomInfo struct {
+	Topic    string    `json:"topic,omitempty"`
+	TopicBy  string    `json:"topic_by,omitempty"`
+	TopicSet time.Time `json:"topic_set"`
+	Pins     []Pin     `json:"pins,omitempty"`
+}
+
+// Pin is a pinned message. It keeps a copy of the message, updated when the
+// message is edited, so that a pin outlives the room's replay buffer. It goes
+// once the message is deleted or purged by retention.
+type Pin struct {
+	Message Event     `json:"message"`
+	By      string    `json:"by"`
+	Pinned  time.Time `json:"pinned"`
+}
+
+// loadRooms reads the room topics and pins from the store.
+func (s *Server) loadRooms() error {
+	s.roomInfoMux.Lock()
+	defer s.roomInfoMux.Unlock()
+	if err := s.store.load(roomsFile, &s.roomInfo); err != nil {
+		return err
+	}
+	if s.roomInfo == nil {
+		s.roomInfo = make(map[string]*RoomInfo)
+	}
+	return nil
+}
+
+// saveRooms writes the room topics and pins to the store. The caller must
+// hold roomInfoMux.
+func (s *Server) saveRooms() {
+	if err := s.store.save(roomsFile, s.roomInfo); err != nil {
+		log.Printf("Error saving room topics and pins: %v", err)
+	}
+}
+
+// canManageRoom reports whether client may set room's topic and pins: any
+// member of a conversation, but only admins in public rooms.
+func (s *Server) canManageRoom(client *Client, room string) bool {
+	if isConversation(room) {
+		return s.canAccess(client, room)
+	}
+	return client.isAdmin()
+}
+
+// roomState returns the event carrying room's topic and pinned messages.
+// Members get it when they join the room and whenever either changes.
+func (s *Server) roomState(room string) Event {
+	ev := Event{Type: eventRoom, Room: room, Time: time.Now()}
+	s.roomInfoMux.Lock()
+	defer s.roomInfoMux.Unlock()
+	if info, ok := s.roomInfo[room]; ok {
+		ev.Topic = info.Topic
+		for _, pin := range info.Pins {
+			ev.Pins = append(ev.Pins, pin.Message)
+		}
+	}
+	return ev
+}
+
+// sendRoomState sends client the topic and pinned messages of room.
+func (s *Server) sendRoomState(client *Client, room string) {
+	if err := client.send(s.roomState(room)); err != nil {
+		log.Printf("Error sending state of %s to %s: %v", room, client.ip, err)
+	}
+}
+
+// announceRoomState sends every member of room its new topic and pins, with
+// text describing what changed.
+func (s *Server) announceRoomState(room, text string) {
+	if text != "" {
+		log.Printf("%s: %s", room, text)
+	}
+	ev := s.roomState(room)
+	ev.Text = text
+	s.broadcast(ev, nil)
+}
+
+// setTopic handles /topic <room> [text]: without text it shows the topic,
+// "-" clears it.
+func (s *Server) setTopic(client *Client, room, text string) {
+	if s.room(room) == nil || !s.canAccess(client, room) {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", room))
+		return
+	}
+	if text == "" {
+		s.roomInfoMux.Lock()
+		var info RoomInfo
+		if stored, ok := s.roomInfo[room]; ok {
+			info = *stored
+		}
+		s.roomInfoMux.Unlock()
+		if info.Topic == "" {
+			client.sendSystem(fmt.Sprintf("%s has no topic.", room))
+		} else {
+			client.sendSystem(fmt.Sprintf("Topic of %s: %s (set by %s on %s)", room, info.Topic, info.TopicBy, info.TopicSet.Format("2006-01-02 15:04")))
+		}
+		return
+	}
+	if !s.canManageRoom(client, room) {
+		client.sendSystem(fmt.Sprintf("Only admins can change the topic of %s.", room))
+		return
+	}
+	if text == "-" {
+		text = ""
+	}
+	text = sanitizeName(text)
+	if utf8.RuneCountInString(text) > maxTopicLength {
+		client.sendSystem(fmt.Sprintf("Topics are limited to %d characters.", maxTopicLength))
+		return
+	}
+
+	s.roomInfoMux.Lock()
+	info := s.roomInfoFor(room)
+	info.Topic, info.TopicBy, info.TopicSet = text, client.name(), time.Now()
+	s.saveRooms()
+	s.roomInfoMux.Unlock()
+
+	if text == "" {
+		s.announceRoomState(room, fmt.Sprintf("%s cleared the topic.", client.name()))
+	} else {
+		s.announceRoomState(room, fmt.Sprintf("%s set the topic to: %s", client.name(), text))
+	}
+}
+
+// pinMessage handles /pin and /unpin.
+func (s *Server) pinMessage(client *Client, id string, pin bool) {
+	if id == "" {
+		if pin {
+			client.sendSystem("Usage: /pin <message id>")
+		} else {
+			client.sendSystem("Usage: /unpin <message id>")
+		}
+		return
+	}
+	room := s.roomWithMessage(client, id)
+	if room == nil {
+		room = s.roomWithPin(client, id)
+	}
+	if room == nil {
+		client.sendSystem(fmt.Sprintf("Message '%s' not found.", id))
+		return
+	}
+	if !s.canManageRoom(client, room.name) {
+		client.sendSystem(fmt.Sprintf("Only admins can change the pinned messages of %s.", room.name))
+		return
+	}
+
+	// The message is read first: refreshPin takes roomInfoMux with the
+	// room locked, so it must not be held while locking the room.
+	msg, found := room.get(id)
+
+	s.roomInfoMux.Lock()
+	info := s.roomInfoFor(room.name)
+	i := slices.IndexFunc(info.Pins, func(p Pin) bool { return p.Message.ID == id })
+	var reason string
+	switch {
+	case pin && i >= 0:
+		reason = fmt.Sprintf("Message '%s' is already pinned.", id)
+	case !pin && i < 0:
+		reason = fmt.Sprintf("Message '%s' is not pinned.", id)
+	case pin && len(info.Pins) >= maxPins:
+		reason = fmt.Sprintf("%s already has %d pinned messages; unpin one first.", room.name, maxPins)
+	case pin:
+		if !found || msg.Deleted {
+			reason = fmt.Sprintf("Message '%s' not found.", id)
+			break
+		}
+		info.Pins = append(info.Pins, Pin{Message: msg, By: client.name(), Pinned: time.Now()})
+	default:
+		info.Pins = slices.Delete(info.Pins, i, i+1)
+	}
+	if reason == "" {
+		s.saveRooms()
+	}
+	s.roomInfoMux.Unlock()
+
+	if reason != "" {
+		client.sendSystem(reason)
+	} else if pin {
+		s.announceRoomState(room.name, fmt.Sprintf("%s pinned message %s.", client.name(), id))
+	} else {
+		s.announceRoomState(room.name, fmt.Sprintf("%s unpinned message %s.", client.name(), id))
+	}
+}
+
+// sendPins handles /pins <room>: it lists the room's pinned messages.
+func (s *Server) sendPins(client *Client, room string) {
+	if s.room(room) == nil || !s.canAccess(client, room) {
+		client.sendSystem(fmt.Sprintf("Room '%s' not found.", room))
+		return
+	}
+	s.roomInfoMux.Lock()
+	var pins []Pin
+	if info, ok := s.roomInfo[room]; ok {
+		pins = slices.Clone(info.Pins)
+	}
+	s.roomInfoMux.Unlock()
+
+	if len(pins) == 0 {
+		client.sendSystem(fmt.Sprintf("Nothing is pinned in %s.", room))
+		return
+	}
+	lines := make([]string, len(pins))
+	for i, pin := range pins {
+		lines[i] = fmt.Sprintf("[%s] %s: %s (pinned by %s)", pin.Message.ID, pin.Message.From, pinText(pin.Message), pin.By)
+	}
+	client.sendSystem(fmt.Sprintf("Pinned in %s:\n%s", room, strings.Join(lines, "\n")))
+}
+
+// pinText summarises a pinned message on one line.
+func pinText(msg Event) string {
+	switch {
+	case msg.File != nil:
+		return "[file] " + msg.File.Name
+	case msg.Code:
+		return "[code snippet]"
+	default:
+		return strings.ReplaceAll(msg.Text, "\n", " ")
+	}
+}
+
+// roomInfoFor returns the info of room, creating it if needed. The caller
+// must hold roomInfoMux.
+func (s *Server) roomInfoFor(room string) *RoomInfo {
+	info, ok := s.roomInfo[room]
+	if !ok {
+		info = &RoomInfo{}
+		s.roomInfo[room] = info
+	}
+	return info
+}
+
+// roomWithPin returns the room client can access that has message id pinned,
+// for unpinning messages that are no longer in the room's history.
+func (s *Server) roomWithPin(client *Client, id string) *Room {
+	s.roomInfoMux.Lock()
+	var name string
+	for room, info := range s.roomInfo {
+		if slices.ContainsFunc(info.Pins, func(p Pin) bool { return p.Message.ID == id }) {
+			name = room
+			break
+		}
+	}
+	s.roomInfoMux.Unlock()
+	if name == "" || !s.canAccess(client, name) {
+		return nil
+	}
+	return s.room(name)
+}
+
+// refreshPin updates the pinned copy of msg after it was edited, or unpins
+// it once deleted, and tells the room's members.
+func (s *Server) refreshPin(msg Event) {
+	s.roomInfoMux.Lock()
+	info, ok := s.roomInfo[msg.Room]
+	i := -1
+	if ok {
+		i = slices.IndexFunc(info.Pins, func(p Pin) bool { return p.Message.ID == msg.ID })
+	}
+	if i < 0 {
+		s.roomInfoMux.Unlock()
+		return
+	}
+	if msg.Deleted {
+		info.Pins = slices.Delete(info.Pins, i, i+1)
+	} else {
+		info.Pins[i].Message = msg
+	}
+	s.saveRooms()
+	s.roomInfoMux.Unlock()
+	s.announceRoomState(msg.Room, "")
+}
+
+// purgePins unpins the messages purged from room by retention and tells the
+// room's members.
+func (s *Server) purgePins(room string, events []Event) {
+	purged := make(map[string]bool, len(events))
+	for _, ev := range events {
+		purged[ev.ID] = true
+	}
+	s.roomInfoMux.Lock()
+	info, ok := s.roomInfo[room]
+	if !ok {
+		s.roomInfoMux.Unlock()
+		return
+	}
+	pins := len(info.Pins)
+	info.Pins = slices.DeleteFunc(info.Pins, func(p Pin) bool { return purged[p.Message.ID] })
+	changed := len(info.Pins) < pins
+	if changed {
+		s.saveRooms()
+	}
+	s.roomInfoMux.Unlock()
+	if changed {
+		s.announceRoomState(room, "")
+	}
+}
+
+// forgetRoom drops the topic and pins of a room that no longer exists.
+func (s *Server) forgetRoom(room string) {
+	s.roomInfoMux.Lock()
+	defer s.roomInfoMux.Unlock()
+	if _, ok := s.roomInfo[room]; ok {
+		delete(s.roomInfo, room)
+		s.saveRooms()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPinText(t *testing.T) {
+	tests := []struct {
+		msg  Event
+		want string
+	}{
+		{Event{Text: "ship it\ntoday"}, "ship it today"},
+		{Event{Text: "fmt.Println()", Code: true}, "[code snippet]"},
+		{Event{Text: "report.pdf", File: &FileInfo{Name: "report.pdf"}}, "[file] report.pdf"},
+	}
+	for _, tt := range tests {
+		if got := pinText(tt.msg); got != tt.want {
+			t.Errorf("pinText(%+v) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestPinMessage(t *testing.T) {
+	s := testServer(t)
+	for _, id := range []string{"m1", "m2"} {
+		s.room(defaultRoom).publish(Event{Type: eventMessage, ID: id, Room: defaultRoom, From: "bob", Text: "hello"}, func(Event) {})
+	}
+	admin, peer := testClient(t, "carol")
+	admin.admin = true
+	user, userPeer := testClient(t, "dave")
+
+	// Each step runs on the pins left by the previous ones
+	tests := []struct {
+		name     string
+		client   *Client
+		id       string
+		pin      bool
+		wantPins []string
+		wantText string
+	}{
+		{"pin", admin, "m1", true, []string{"m1"}, ""},
+		{"pin twice", admin, "m1", true, []string{"m1"}, "Message 'm1' is already pinned."},
+		{"unknown message", admin, "m9", true, []string{"m1"}, "Message 'm9' not found."},
+		{"not an admin", user, "m2", true, []string{"m1"}, "Only admins can change the pinned messages of lobby."},
+		{"pin another", admin, "m2", true, []string{"m1", "m2"}, ""},
+		{"unpin", admin, "m1", false, []string{"m2"}, ""},
+		{"unpin twice", admin, "m1", false, []string{"m2"}, "Message 'm1' is not pinned."},
+	}
+	for _, tt := range tests {
+		s.pinMessage(tt.client, tt.id, tt.pin)
+		var got []string
+		for _, pin := range s.roomInfoFor(defaultRoom).Pins {
+			got = append(got, pin.Message.ID)
+		}
+		if !slices.Equal(got, tt.wantPins) {
+			t.Errorf("%s: pins = %v, want %v", tt.name, got, tt.wantPins)
+		}
+		if tt.wantText != "" {
+			from := peer
+			if tt.client == user {
+				from = userPeer
+			}
+			if text := readEvent(t, from).Text; text != tt.wantText {
+				t.Errorf("%s: told %q, want %q", tt.name, text, tt.wantText)
+			}
+		}
+	}
+}
+
+func TestRefreshAndPurgePins(t *testing.T) {
+	s := testServer(t)
+	info := s.roomInfoFor(defaultRoom)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		info.Pins = append(info.Pins, Pin{Message: Event{ID: id, Room: defaultRoom, Text: "hello"}})
+	}
+
+	s.refreshPin(Event{ID: "m1", Room: defaultRoom, Text: "edited"})
+	s.refreshPin(Event{ID: "m2", Room: defaultRoom, Deleted: true})
+	s.purgePins(defaultRoom, []Event{{ID: "m3"}, {ID: "m4"}})
+
+	var got []string
+	for _, pin := range info.Pins {
+		got = append(got, pin.Message.ID+":"+pin.Message.Text)
+	}
+	if want := []string{"m1:edited"}; !slices.Equal(got, want) {
+		t.Errorf("pins = %v, want %v", got, want)
+	}
+	if state := s.roomState(defaultRoom); len(state.Pins) != 1 || !strings.Contains(state.Pins[0].Text, "edited") {
+		t.Errorf("room state pins = %+v", state.Pins)
+	}
+}
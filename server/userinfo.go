@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seenRecord is what the server remembers about a nickname after its client
+// disconnects or switches to another nickname.
+type seenRecord struct {
+	LastConnected time.Time
+	LastMessage   string
+	LastMessageAt time.Time
+	LastMessageID string
+}
+
+// recordMessage remembers ev as the client's latest chat line for /seen.
+func (c *Client) recordMessage(ev Event) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	c.lastMessage = ev.Text
+	c.lastMessageAt = ev.Time
+	c.lastMessageID = ev.ID
+}
+
+// forgetMessage clears the client's latest chat line if it is one of ids.
+func (c *Client) forgetMessage(ids map[string]bool) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	if ids[c.lastMessageID] {
+		c.lastMessage, c.lastMessageAt, c.lastMessageID = "", time.Time{}, ""
+	}
+}
+
+// purgeSeen forgets the latest chat lines /seen shows when retention purges
+// them from their room.
+func (s *Server) purgeSeen(events []Event) {
+	purged := make(map[string]bool, len(events))
+	for _, ev := range events {
+		purged[ev.ID] = true
+	}
+	s.clientsMux.RLock()
+	for client := range s.clients {
+		client.forgetMessage(purged)
+	}
+	s.clientsMux.RUnlock()
+
+	s.seenMux.Lock()
+	defer s.seenMux.Unlock()
+	for username, record := range s.seen {
+		if purged[record.LastMessageID] {
+			record.LastMessage, record.LastMessageAt, record.LastMessageID = "", time.Time{}, ""
+			s.seen[username] = record
+		}
+	}
+}
+
+// recordSeen stores when client was last connected under username, together
+// with its latest message.
+func (s *Server) recordSeen(client *Client, username string) {
+	if username == "" {
+		return
+	}
+	client.stateMux.Lock()
+	record := seenRecord{
+		LastConnected: time.Now(),
+		LastMessage:   client.lastMessage,
+		LastMessageAt: client.lastMessageAt,
+		LastMessageID: client.lastMessageID,
+	}
+	client.stateMux.Unlock()
+
+	s.seenMux.Lock()
+	defer s.seenMux.Unlock()
+	s.seen[username] = record
+}
+
+// roomsOf returns the names of the public rooms client takes part in. Every
+// client is currently a member of every public room; group conversations are
+// private and never listed.
+func (s *Server) roomsOf(client *Client) []string {
+	s.roomsMux.RLock()
+	defer s.roomsMux.RUnlock()
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		if !isConversation(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sendWhois reports what the server knows about a connected user. The IP
+// address is only included for admins.
+func (s *Server) sendWhois(requestingClient *Client, username string) {
+	target := s.findClient(username)
+	if target == nil {
+		requestingClient.sendSystem(fmt.Sprintf("User '%s' is not connected. Try /seen %s.", username, username))
+		return
+	}
+
+	target.stateMux.Lock()
+	connectedAt := target.connectedAt
+	idle := time.Since(target.lastActive)
+	target.stateMux.Unlock()
+
+	presence, awayMessage := target.presenceState()
+	if presence == presenceAway {
+		presence = fmt.Sprintf("%s (%s)", presence, awayMessage)
+	}
+
+	details := []string{
+		fmt.Sprintf("connected %s (%s ago)", connectedAt.Format("2006-01-02 15:04:05"), formatDuration(time.Since(connectedAt))),
+		"idle " + formatDuration(idle),
+		"rooms: " + strings.Join(s.roomsOf(target), ", "),
+		"status: " + presence,
+	}
+	if requestingClient.isAdmin() {
+		details = append(details, "IP: "+target.ip)
+	}
+
+	log.Printf("Client %s (%s) requested whois %s", requestingClient.name(), requestingClient.ip, username)
+	username = target.name()
+	requestingClient.sendSystem(fmt.Sprintf("%s: %s", username, strings.Join(details, " · ")))
+}
+
+// sendSeen reports when a user was last connected and what they last said.
+func (s *Server) sendSeen(requestingClient *Client, username string) {
+	if s.findClient(username) != nil {
+		requestingClient.sendSystem(fmt.Sprintf("%s is online right now.", username))
+		return
+	}
+
+	s.seenMux.RLock()
+	record, ok := s.seen[username]
+	if !ok {
+		for name, r := range s.seen {
+			if sameNick(name, username) {
+				username, record, ok = name, r, true
+				break
+			}
+		}
+	}
+	s.seenMux.RUnlock()
+	if !ok {
+		requestingClient.sendSystem(fmt.Sprintf("I have not seen %s.", username))
+		return
+	}
+
+	msg := fmt.Sprintf("%s was last connected %s (%s ago).", username,
+		record.LastConnected.Format("2006-01-02 15:04:05"), formatDuration(time.Since(record.LastConnected)))
+	if record.LastMessage != "" {
+		msg += fmt.Sprintf(" Last message (%s): %s", record.LastMessageAt.Format("2006-01-02 15:04:05"), record.LastMessage)
+	}
+	requestingClient.sendSystem(msg)
+}
+
+// formatDuration renders d with its two most significant units, e.g. "45s",
+// "12m", "3h5m" or "2d4h".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+}
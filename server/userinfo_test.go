@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{45 * time.Second, "45s"},
+		{12*time.Minute + 30*time.Second, "12m"},
+		{3*time.Hour + 5*time.Minute, "3h5m"},
+		{52 * time.Hour, "2d4h"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"/admin s3cret", "/admin ****"},
+		{"/admin", "/admin"},
+		{"/register hunter22", "/register ****"},
+		{"/identify hunter22", "/identify ****"},
+		{"/whois bob", "/whois bob"},
+		{"hello /admin s3cret", "hello /admin s3cret"},
+	}
+	for _, tt := range tests {
+		if got := redactSecrets(tt.message); got != tt.want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}